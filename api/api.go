@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"bytes"
 	"code.google.com/p/go.net/websocket"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"expvar"
@@ -277,6 +279,98 @@ func getImagesHistory(eng *engine.Engine, version float64, w http.ResponseWriter
 	return nil
 }
 
+func getContainersStats(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	stream := true
+	if r.Form.Get("stream") != "" {
+		var err error
+		if stream, err = getBoolParam(r.Form.Get("stream")); err != nil {
+			return err
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	job := eng.Job("stats", vars["name"])
+	job.SetenvBool("stream", stream)
+	job.Stdout.Add(utils.NewWriteFlusher(w))
+	return job.Run()
+}
+
+func getContainersLogs(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+
+	// Validate the options before the response is committed
+	stdout, err := getBoolParam(r.Form.Get("stdout"))
+	if err != nil {
+		return err
+	}
+	stderr, err := getBoolParam(r.Form.Get("stderr"))
+	if err != nil {
+		return err
+	}
+	if !(stdout || stderr) {
+		return fmt.Errorf("Bad parameters: you must choose at least one stream")
+	}
+	if tail := r.Form.Get("tail"); tail != "" && tail != "all" {
+		if n, err := strconv.Atoi(tail); err != nil || n < 0 {
+			return fmt.Errorf("Bad parameters: invalid number of lines to tail: %s", tail)
+		}
+	}
+	if since := r.Form.Get("since"); since != "" {
+		if _, err := strconv.ParseInt(since, 10, 64); err != nil {
+			return fmt.Errorf("Bad parameters: invalid timestamp: %s", since)
+		}
+	}
+
+	var (
+		job = eng.Job("inspect", vars["name"], "container")
+		c   *engine.Env
+	)
+	if c, err = job.Stdout.AddEnv(); err != nil {
+		return err
+	}
+	if err = job.Run(); err != nil {
+		return err
+	}
+
+	var (
+		outStream io.Writer = utils.NewWriteFlusher(w)
+		errStream io.Writer
+	)
+	if c.GetSubEnv("Config") != nil && !c.GetSubEnv("Config").GetBool("Tty") {
+		errStream = utils.NewStdWriter(outStream, utils.Stderr)
+		outStream = utils.NewStdWriter(outStream, utils.Stdout)
+	} else {
+		errStream = outStream
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.docker.raw-stream")
+	job = eng.Job("logs", vars["name"])
+	job.SetenvBool("stdout", stdout)
+	job.SetenvBool("stderr", stderr)
+	job.Setenv("follow", r.Form.Get("follow"))
+	job.Setenv("timestamps", r.Form.Get("timestamps"))
+	job.Setenv("tail", r.Form.Get("tail"))
+	job.Setenv("since", r.Form.Get("since"))
+	job.Stdout.Add(outStream)
+	job.Stderr.Set(errStream)
+	if err := job.Run(); err != nil {
+		// The error was already written to the stream
+		utils.Errorf("%s", err)
+	}
+	return nil
+}
+
 func getContainersChanges(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -376,6 +470,12 @@ func postCommit(eng *engine.Engine, version float64, w http.ResponseWriter, r *h
 	job.Setenv("author", r.Form.Get("author"))
 	job.Setenv("comment", r.Form.Get("comment"))
 	job.SetenvSubEnv("config", &config)
+	// Pause by default, for clients which don't know about it
+	if r.Form.Get("pause") == "" {
+		job.SetenvBool("pause", true)
+	} else {
+		job.Setenv("pause", r.Form.Get("pause"))
+	}
 
 	var id string
 	job.Stdout.AddString(&id)
@@ -595,6 +695,56 @@ func postContainersCreate(eng *engine.Engine, version float64, w http.ResponseWr
 	return writeJSON(w, http.StatusCreated, out)
 }
 
+func getVolumesJSON(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	job := eng.Job("volumes")
+	w.Header().Set("Content-Type", "application/json")
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func getVolumesByName(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	job := eng.Job("volume_inspect", vars["name"])
+	w.Header().Set("Content-Type", "application/json")
+	job.Stdout.Add(w)
+	return job.Run()
+}
+
+func postVolumesCreate(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	var (
+		out  engine.Env
+		name string
+		job  = eng.Job("volume_create")
+	)
+	if n := r.Form.Get("name"); n != "" {
+		job.Args = append(job.Args, n)
+	}
+	job.Setenv("Driver", r.Form.Get("driver"))
+	job.SetenvList("Opts", r.Form["opt"])
+	job.Stdout.AddString(&name)
+	if err := job.Run(); err != nil {
+		return err
+	}
+	out.Set("Name", name)
+	return writeJSON(w, http.StatusCreated, out)
+}
+
+func deleteVolumes(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := eng.Job("volume_delete", vars["name"]).Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 func postContainersRestart(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -679,6 +829,39 @@ func postContainersStop(eng *engine.Engine, version float64, w http.ResponseWrit
 	return nil
 }
 
+func postGc(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	job := eng.Job("gc")
+	job.Stdout.Add(w)
+	job.Setenv("DryRun", r.Form.Get("dryrun"))
+	job.Setenv("ContainerAge", r.Form.Get("containerage"))
+	return job.Run()
+}
+
+func postContainersPause(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := eng.Job("pause", vars["name"]).Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func postContainersUnpause(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := eng.Job("unpause", vars["name"]).Run(); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 func postContainersWait(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -701,6 +884,23 @@ func postContainersWait(eng *engine.Engine, version float64, w http.ResponseWrit
 	return writeJSON(w, http.StatusOK, env)
 }
 
+func postExecWait(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	var (
+		env    engine.Env
+		status string
+		job    = eng.Job("execwait", vars["id"])
+	)
+	job.Stdout.AddString(&status)
+	if err := job.Run(); err != nil {
+		return err
+	}
+	env.Set("StatusCode", status)
+	return writeJSON(w, http.StatusOK, env)
+}
+
 func postContainersResize(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -779,6 +979,82 @@ func postContainersAttach(eng *engine.Engine, version float64, w http.ResponseWr
 	return nil
 }
 
+func postContainersExec(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if len(r.Form["cmd"]) == 0 {
+		return fmt.Errorf("Missing parameter: cmd")
+	}
+	tty, err := getBoolParam(r.Form.Get("tty"))
+	if err != nil {
+		return err
+	}
+
+	var (
+		job = eng.Job("inspect", vars["name"], "container")
+		c   *engine.Env
+	)
+	if c, err = job.Stdout.AddEnv(); err != nil {
+		return err
+	}
+	if err = job.Run(); err != nil {
+		return err
+	}
+	if c.GetSubEnv("State") == nil || !c.GetSubEnv("State").GetBool("Running") {
+		return fmt.Errorf("Container %s is not running", vars["name"])
+	}
+
+	inStream, outStream, err := hijackServer(w)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if tcpc, ok := inStream.(*net.TCPConn); ok {
+			tcpc.CloseWrite()
+		} else {
+			inStream.Close()
+		}
+	}()
+	defer func() {
+		if tcpc, ok := outStream.(*net.TCPConn); ok {
+			tcpc.CloseWrite()
+		} else if closer, ok := outStream.(io.Closer); ok {
+			closer.Close()
+		}
+	}()
+
+	var errStream io.Writer
+
+	fmt.Fprintf(outStream, "HTTP/1.1 200 OK\r\nContent-Type: application/vnd.docker.raw-stream\r\n\r\n")
+
+	if !tty {
+		errStream = utils.NewStdWriter(outStream, utils.Stderr)
+		outStream = utils.NewStdWriter(outStream, utils.Stdout)
+	} else {
+		errStream = outStream
+	}
+
+	job = eng.Job("exec", vars["name"])
+	job.Setenv("Id", r.Form.Get("id"))
+	job.SetenvList("Cmd", r.Form["cmd"])
+	job.Setenv("User", r.Form.Get("user"))
+	job.SetenvBool("Tty", tty)
+	job.Setenv("stdin", r.Form.Get("stdin"))
+	job.Setenv("stdout", r.Form.Get("stdout"))
+	job.Setenv("stderr", r.Form.Get("stderr"))
+	job.Stdin.Add(inStream)
+	job.Stdout.Add(outStream)
+	job.Stderr.Set(errStream)
+	if err := job.Run(); err != nil {
+		fmt.Fprintf(outStream, "Error: %s\n", err)
+	}
+	return nil
+}
+
 func wsContainersAttach(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -928,7 +1204,7 @@ func writeCorsHeaders(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Access-Control-Allow-Methods", "GET, POST, DELETE, PUT, OPTIONS")
 }
 
-func makeHttpHandler(eng *engine.Engine, logging bool, localMethod string, localRoute string, handlerFunc HttpApiFunc, enableCors bool, dockerVersion string) http.HandlerFunc {
+func makeHttpHandler(eng *engine.Engine, logging bool, localMethod string, localRoute string, handlerFunc HttpApiFunc, enableCors bool, dockerVersion string, authz *authzPlugin) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// log the request
 		utils.Debugf("Calling %s %s", localMethod, localRoute)
@@ -956,6 +1232,23 @@ func makeHttpHandler(eng *engine.Engine, logging bool, localMethod string, local
 			return
 		}
 
+		if authz != nil {
+			res, err := authz.authorize(localRoute, r)
+			if err != nil {
+				utils.Errorf("Error: %s", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !res.Allow {
+				msg := res.Msg
+				if msg == "" {
+					msg = "request denied by the authorization plugin"
+				}
+				http.Error(w, "Forbidden, "+msg, http.StatusForbidden)
+				return
+			}
+		}
+
 		if err := handlerFunc(eng, version, w, r, mux.Vars(r)); err != nil {
 			utils.Errorf("Error: %s", err)
 			httpError(w, err)
@@ -989,7 +1282,7 @@ func AttachProfiler(router *mux.Router) {
 	router.HandleFunc("/debug/pprof/threadcreate", pprof.Handler("threadcreate").ServeHTTP)
 }
 
-func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion string) (*mux.Router, error) {
+func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion string, authz *authzPlugin) (*mux.Router, error) {
 	r := mux.NewRouter()
 	if os.Getenv("DEBUG") != "" {
 		AttachProfiler(r)
@@ -1011,7 +1304,11 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 			"/containers/{name:.*}/changes":   getContainersChanges,
 			"/containers/{name:.*}/json":      getContainersByName,
 			"/containers/{name:.*}/top":       getContainersTop,
+			"/containers/{name:.*}/stats":     getContainersStats,
+			"/containers/{name:.*}/logs":      getContainersLogs,
 			"/containers/{name:.*}/attach/ws": wsContainersAttach,
+			"/volumes/json":                   getVolumesJSON,
+			"/volumes/{name:.*}/json":         getVolumesByName,
 		},
 		"POST": {
 			"/auth":                         postAuth,
@@ -1027,14 +1324,21 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 			"/containers/{name:.*}/restart": postContainersRestart,
 			"/containers/{name:.*}/start":   postContainersStart,
 			"/containers/{name:.*}/stop":    postContainersStop,
+			"/containers/{name:.*}/pause":   postContainersPause,
+			"/containers/{name:.*}/unpause": postContainersUnpause,
 			"/containers/{name:.*}/wait":    postContainersWait,
 			"/containers/{name:.*}/resize":  postContainersResize,
 			"/containers/{name:.*}/attach":  postContainersAttach,
 			"/containers/{name:.*}/copy":    postContainersCopy,
+			"/containers/{name:.*}/exec":    postContainersExec,
+			"/exec/{id:.*}/wait":            postExecWait,
+			"/gc":                           postGc,
+			"/volumes/create":               postVolumesCreate,
 		},
 		"DELETE": {
 			"/containers/{name:.*}": deleteContainers,
 			"/images/{name:.*}":     deleteImages,
+			"/volumes/{name:.*}":    deleteVolumes,
 		},
 		"OPTIONS": {
 			"": optionsHandler,
@@ -1050,7 +1354,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 			localMethod := method
 
 			// build the handler function
-			f := makeHttpHandler(eng, logging, localMethod, localRoute, localFct, enableCors, dockerVersion)
+			f := makeHttpHandler(eng, logging, localMethod, localRoute, localFct, enableCors, dockerVersion, authz)
 
 			// add the new route
 			if localRoute == "" {
@@ -1069,7 +1373,7 @@ func createRouter(eng *engine.Engine, logging, enableCors bool, dockerVersion st
 // FIXME: refactor this to be part of Server and not require re-creating a new
 // router each time. This requires first moving ListenAndServe into Server.
 func ServeRequest(eng *engine.Engine, apiversion float64, w http.ResponseWriter, req *http.Request) error {
-	router, err := createRouter(eng, false, true, "")
+	router, err := createRouter(eng, false, true, "", nil)
 	if err != nil {
 		return err
 	}
@@ -1080,8 +1384,8 @@ func ServeRequest(eng *engine.Engine, apiversion float64, w http.ResponseWriter,
 }
 
 // ServeFD creates an http.Server and sets it up to serve given a socket activated
-// argument.
-func ServeFd(addr string, handle http.Handler) error {
+// argument. The tcp sockets are served with TLS when tlsConfig is not nil.
+func ServeFd(addr string, handle http.Handler, tlsConfig *tls.Config, authz *authzPlugin) error {
 	ls, e := systemd.ListenFD(addr)
 	if e != nil {
 		return e
@@ -1093,6 +1397,11 @@ func ServeFd(addr string, handle http.Handler) error {
 	// to create a go func to spawn off multiple serves
 	for i := range ls {
 		listener := ls[i]
+		if tlsConfig != nil && listener.Addr().Network() == "tcp" {
+			listener = tls.NewListener(listener, tlsConfig)
+		} else if authz != nil && listener.Addr().Network() == "unix" {
+			listener = authzListener(listener)
+		}
 		go func() {
 			httpSrv := http.Server{Handler: handle}
 			chErrors <- httpSrv.Serve(listener)
@@ -1109,16 +1418,45 @@ func ServeFd(addr string, handle http.Handler) error {
 	return nil
 }
 
+// NewServerTLSConfig returns the TLS configuration of a daemon serving with
+// the certificate cert and its key. When ca is set, only the clients which
+// present a certificate signed by ca are accepted.
+func NewServerTLSConfig(cert, key, ca string) (*tls.Config, error) {
+	tlsCert, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't load the X509 key pair (%s, %s): %s", cert, key, err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{tlsCert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't read the CA certificate: %s", err)
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificate found in %s", ca)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		tlsConfig.ClientCAs = certPool
+	}
+	return tlsConfig, nil
+}
+
 // ListenAndServe sets up the required http.Server and gets it listening for
-// each addr passed in and does protocol specific checking.
-func ListenAndServe(proto, addr string, eng *engine.Engine, logging, enableCors bool, dockerVersion string) error {
-	r, err := createRouter(eng, logging, enableCors, dockerVersion)
+// each addr passed in and does protocol specific checking. The tcp sockets
+// are served with TLS when tlsConfig is not nil. Each request is allowed or
+// denied by the plugin authz, when it is not nil.
+func ListenAndServe(proto, addr string, eng *engine.Engine, logging, enableCors bool, dockerVersion string, tlsConfig *tls.Config, authz *authzPlugin) error {
+	r, err := createRouter(eng, logging, enableCors, dockerVersion, authz)
 	if err != nil {
 		return err
 	}
 
 	if proto == "fd" {
-		return ServeFd(addr, r)
+		return ServeFd(addr, r, tlsConfig, authz)
 	}
 
 	if proto == "unix" {
@@ -1135,8 +1473,11 @@ func ListenAndServe(proto, addr string, eng *engine.Engine, logging, enableCors
 	// Basic error and sanity checking
 	switch proto {
 	case "tcp":
-		if !strings.HasPrefix(addr, "127.0.0.1") {
-			log.Println("/!\\ DON'T BIND ON ANOTHER IP ADDRESS THAN 127.0.0.1 IF YOU DON'T KNOW WHAT YOU'RE DOING /!\\")
+		if tlsConfig != nil {
+			l = tls.NewListener(l, tlsConfig)
+		}
+		if !strings.HasPrefix(addr, "127.0.0.1") && (tlsConfig == nil || tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert) {
+			log.Println("/!\\ DON'T BIND ON ANOTHER IP ADDRESS THAN 127.0.0.1 WITHOUT --tlsverify IF YOU DON'T KNOW WHAT YOU'RE DOING /!\\")
 		}
 	case "unix":
 		if err := os.Chmod(addr, 0660); err != nil {
@@ -1162,6 +1503,10 @@ func ListenAndServe(proto, addr string, eng *engine.Engine, logging, enableCors
 		return fmt.Errorf("Invalid protocol format.")
 	}
 
+	if proto == "unix" && authz != nil {
+		l = authzListener(l)
+	}
+
 	httpSrv := http.Server{Addr: addr, Handler: r}
 	return httpSrv.Serve(l)
 }
@@ -1172,11 +1517,35 @@ func ServeApi(job *engine.Job) engine.Status {
 	protoAddrs := job.Args
 	chErrors := make(chan error, len(protoAddrs))
 
+	var tlsConfig *tls.Config
+	if job.GetenvBool("Tls") || job.GetenvBool("TlsVerify") {
+		ca := ""
+		if job.GetenvBool("TlsVerify") {
+			ca = job.Getenv("TlsCa")
+		}
+		var err error
+		if tlsConfig, err = NewServerTLSConfig(job.Getenv("TlsCert"), job.Getenv("TlsKey"), ca); err != nil {
+			return job.Error(err)
+		}
+	}
+
+	var authz *authzPlugin
+	if name := job.Getenv("AuthzPlugin"); name != "" {
+		var err error
+		if authz, err = newAuthzPlugin(name); err != nil {
+			return job.Error(err)
+		}
+	}
+
 	for _, protoAddr := range protoAddrs {
 		protoAddrParts := strings.SplitN(protoAddr, "://", 2)
 		go func() {
-			log.Printf("Listening for HTTP on %s (%s)\n", protoAddrParts[0], protoAddrParts[1])
-			chErrors <- ListenAndServe(protoAddrParts[0], protoAddrParts[1], job.Eng, job.GetenvBool("Logging"), job.GetenvBool("EnableCors"), job.Getenv("Version"))
+			if tlsConfig != nil && protoAddrParts[0] == "tcp" {
+				log.Printf("Listening for HTTPS on %s (%s)\n", protoAddrParts[0], protoAddrParts[1])
+			} else {
+				log.Printf("Listening for HTTP on %s (%s)\n", protoAddrParts[0], protoAddrParts[1])
+			}
+			chErrors <- ListenAndServe(protoAddrParts[0], protoAddrParts[1], job.Eng, job.GetenvBool("Logging"), job.GetenvBool("EnableCors"), job.Getenv("Version"), tlsConfig, authz)
 		}()
 	}
 
@@ -1,10 +1,28 @@
 package api
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
+	"github.com/dotcloud/docker/engine"
+	"io/ioutil"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"os/user"
+	"path"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestJsonContentType(t *testing.T) {
@@ -63,3 +81,261 @@ func TesthttpError(t *testing.T) {
 		t.Fatalf("Expected %d, got %d", http.StatusInternalServerError, r.Code)
 	}
 }
+
+// writeTestCert writes a certificate signed by parent, or self-signed when
+// parent is nil, and its key in dir
+func writeTestCert(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestServerTLSConfigVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca, caKey := writeTestCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	writeTestCert(t, dir, "server", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "server"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	writeTestCert(t, dir, "client", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "client"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+	// A client certificate which is not signed by the CA
+	writeTestCert(t, dir, "other", &x509.Certificate{
+		SerialNumber: big.NewInt(4),
+		Subject:      pkix.Name{CommonName: "other"},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, nil, nil)
+
+	tlsConfig, err := NewServerTLSConfig(path.Join(dir, "server.pem"), path.Join(dir, "server-key.pem"), path.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	get := func(name string) error {
+		clientConfig := &tls.Config{RootCAs: roots}
+		if name != "" {
+			cert, err := tls.LoadX509KeyPair(path.Join(dir, name+".pem"), path.Join(dir, name+"-key.pem"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientConfig.Certificates = []tls.Certificate{cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: clientConfig}}
+		res, err := client.Get("https://" + l.Addr().String() + "/")
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		return nil
+	}
+	if err := get("client"); err != nil {
+		t.Fatalf("Expected the client certificate to be accepted, got %s", err)
+	}
+	if err := get(""); err == nil {
+		t.Fatal("Expected an error without client certificate")
+	}
+	if err := get("other"); err == nil {
+		t.Fatal("Expected an error with a client certificate not signed by the CA")
+	}
+
+	if _, err := NewServerTLSConfig(path.Join(dir, "server.pem"), path.Join(dir, "server-key.pem"), path.Join(dir, "server-key.pem")); err == nil {
+		t.Fatal("Expected an error with a CA file without certificate")
+	}
+}
+
+func TestAuthzPlugin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	l, err := net.Listen("unix", path.Join(dir, "test.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// Deny the privileged containers and the removal of images
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req AuthzRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		var hostConfig struct{ Privileged bool }
+		if req.Body != nil {
+			json.Unmarshal(req.Body, &hostConfig)
+		}
+		res := AuthzResponse{Allow: true}
+		if hostConfig.Privileged {
+			res = AuthzResponse{Msg: "privileged containers are not allowed"}
+		} else if req.Method == "DELETE" && req.Route == "/images/{name:.*}" {
+			res = AuthzResponse{Msg: "images can't be removed"}
+		}
+		json.NewEncoder(w).Encode(res)
+	}))
+
+	oldDir := AuthzPluginsDir
+	AuthzPluginsDir = dir
+	defer func() { AuthzPluginsDir = oldDir }()
+	if _, err := newAuthzPlugin("nothing"); err == nil {
+		t.Fatal("Expected an error with a plugin which does not exist")
+	}
+	authz, err := newAuthzPlugin("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var body string
+	handler := func(eng *engine.Engine, version float64, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+		b, err := ioutil.ReadAll(r.Body)
+		body = string(b)
+		return err
+	}
+	call := func(method, route, uri, contentType, data string) *httptest.ResponseRecorder {
+		body = ""
+		req, err := http.NewRequest(method, uri, strings.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RequestURI = uri
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		w := httptest.NewRecorder()
+		makeHttpHandler(nil, false, method, route, handler, false, "", authz)(w, req)
+		return w
+	}
+
+	if w := call("POST", "/containers/{name:.*}/start", "/containers/test/start", "application/json", `{"Binds":["/tmp:/tmp"]}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected the request to be allowed, got %d: %s", w.Code, w.Body)
+	}
+	if body != `{"Binds":["/tmp:/tmp"]}` {
+		t.Fatalf("Expected the handler to read the body, got %q", body)
+	}
+	if w := call("POST", "/containers/{name:.*}/start", "/containers/test/start", "application/json", `{"Privileged":true}`); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected a privileged container to be denied, got %d", w.Code)
+	} else if !strings.Contains(w.Body.String(), "privileged containers are not allowed") {
+		t.Fatalf("Expected the message of the plugin, got %s", w.Body)
+	}
+	// The handlers decode the body whatever its Content-Type
+	for _, contentType := range []string{"", "text/plain", "application/x-tar"} {
+		if w := call("POST", "/containers/create", "/containers/create", contentType, `{"Privileged":true}`); w.Code != http.StatusForbidden {
+			t.Fatalf("Expected a privileged container to be denied with the Content-Type %q, got %d", contentType, w.Code)
+		}
+	}
+	if w := call("POST", "/containers/create", "/containers/create", "application/json", `{"Privileged":true} trailing`); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected a privileged container to be denied with a body which isn't only JSON, got %d", w.Code)
+	}
+	if w := call("POST", "/containers/{name:.*}/start", "/containers/test/start", "application/json", `{"Binds":["`+strings.Repeat("a", authzMaxBody)+`"]}`); w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected a JSON body too big to be authorized to fail, got %d", w.Code)
+	}
+	// The tarballs aren't sent to the plugin, whatever their size
+	tarball := strings.Repeat("\x00", authzMaxBody+1)
+	if w := call("POST", "/build", "/build", "application/tar", tarball); w.Code != http.StatusOK {
+		t.Fatalf("Expected a big tarball to be allowed, got %d", w.Code)
+	}
+	if body != tarball {
+		t.Fatal("Expected the handler to read the whole tarball")
+	}
+	if w := call("DELETE", "/images/{name:.*}", "/images/busybox", "", ""); w.Code != http.StatusForbidden {
+		t.Fatalf("Expected the removal of an image to be denied, got %d", w.Code)
+	}
+}
+
+func TestAuthzListener(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("The peer credentials are only read on linux")
+	}
+	dir, err := ioutil.TempDir("", "docker-authz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := path.Join(dir, "docker.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	users := make(chan string, 1)
+	go http.Serve(authzListener(l), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		users <- peerUser(r.RemoteAddr)
+	}))
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial: func(proto, addr string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		},
+	}
+	// Twice, the connection must still be usable once its credentials
+	// were read
+	for i := 0; i < 2; i++ {
+		res, err := client.Get("http://docker/info")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		expected := fmt.Sprint(os.Getuid())
+		if u, err := user.LookupId(expected); err == nil {
+			expected = u.Username
+		}
+		if u := <-users; u != expected {
+			t.Fatalf("Expected the user %s, got %q", expected, u)
+		}
+	}
+
+	// The remote addresses of the tcp connections are not users
+	if u := peerUser("127.0.0.1:4243"); u != "" {
+		t.Fatalf("Expected no user for a tcp connection, got %s", u)
+	}
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/user"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuthzPluginsDir is where the authorization plugins listen, each on a unix
+// socket named after the plugin
+var AuthzPluginsDir = "/run/docker/plugins"
+
+const (
+	authzTimeout = 30 * time.Second
+	// The requests with a bigger JSON body are refused. The other bodies,
+	// such as the tarballs of images, are not sent to the plugin.
+	authzMaxBody = 1 << 20
+)
+
+// AuthzRequest is what the authorization plugin is sent for each request of
+// the API, as a POST of a JSON object to /AuthzPlugin.AuthZReq.
+type AuthzRequest struct {
+	// User is the CN of the TLS client certificate, or the user of the
+	// process connected on the unix socket. It is empty when the client
+	// is unknown.
+	User string
+	// UserAuthMethod is how the user was identified, TLS or unix
+	UserAuthMethod string
+	Method         string
+	// Route is the pattern of the API endpoint, such as
+	// /containers/{name:.*}/start
+	Route string
+	URI   string
+	// Body is the JSON value which the body of the request starts with,
+	// if any, whatever its Content-Type
+	Body json.RawMessage `json:",omitempty"`
+}
+
+// AuthzResponse is the answer of the authorization plugin. Msg is sent to
+// the client when the request is denied.
+type AuthzResponse struct {
+	Allow bool
+	Msg   string
+	Err   string
+}
+
+type authzPlugin struct {
+	name   string
+	client *http.Client
+}
+
+// newAuthzPlugin returns the authorization plugin name, which is either the
+// path of a unix socket or the name of a socket of AuthzPluginsDir
+func newAuthzPlugin(name string) (*authzPlugin, error) {
+	socket := name
+	if !path.IsAbs(socket) {
+		socket = path.Join(AuthzPluginsDir, name+".sock")
+	}
+	if _, err := os.Stat(socket); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("No such authorization plugin: %s", name)
+		}
+		return nil, err
+	}
+	return &authzPlugin{
+		name: name,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Dial: func(proto, addr string) (net.Conn, error) {
+					return net.DialTimeout("unix", socket, authzTimeout)
+				},
+				ResponseHeaderTimeout: authzTimeout,
+			},
+		},
+	}, nil
+}
+
+// authorize asks the plugin whether the request r of the API endpoint
+// route is allowed. The body of r is read and replaced.
+func (p *authzPlugin) authorize(route string, r *http.Request) (*AuthzResponse, error) {
+	req := &AuthzRequest{
+		Method: r.Method,
+		Route:  route,
+		URI:    r.RequestURI,
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		req.User = r.TLS.PeerCertificates[0].Subject.CommonName
+		req.UserAuthMethod = "TLS"
+	} else if user := peerUser(r.RemoteAddr); user != "" {
+		req.User = user
+		req.UserAuthMethod = "unix"
+	}
+
+	if r.Body != nil {
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, authzMaxBody+1))
+		if err != nil {
+			return nil, err
+		}
+		// Give the handler the whole body back
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+		// The handlers decode the JSON value at the start of the body,
+		// without looking at its Content-Type
+		var value json.RawMessage
+		switch err := json.NewDecoder(bytes.NewReader(body)).Decode(&value); {
+		case err == nil:
+			req.Body = value
+		case err == io.ErrUnexpectedEOF && len(body) > authzMaxBody:
+			return nil, fmt.Errorf("The body of the request is too big to be authorized")
+		}
+	}
+
+	buf, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	// The host is ignored, the connection is made to the socket
+	res, err := p.client.Post("http://plugin/AuthzPlugin.AuthZReq", "application/json", bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("Error calling the authorization plugin %s: %s", p.name, err)
+	}
+	defer res.Body.Close()
+
+	var ret AuthzResponse
+	if err := json.NewDecoder(res.Body).Decode(&ret); err != nil {
+		return nil, fmt.Errorf("Invalid response of the authorization plugin %s: %s", p.name, err)
+	}
+	if ret.Err != "" {
+		return nil, fmt.Errorf("Authorization plugin %s failed: %s", p.name, ret.Err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Authorization plugin %s failed: %s", p.name, res.Status)
+	}
+	return &ret, nil
+}
+
+// peerCredAddr is the address of a connection on a unix socket, with the
+// credentials of the process at the other end. The handlers get it as
+// the RemoteAddr of the requests.
+type peerCredAddr struct {
+	uid, gid uint32
+}
+
+const peerCredPrefix = "peercred:"
+
+func (addr peerCredAddr) Network() string {
+	return "unix"
+}
+
+func (addr peerCredAddr) String() string {
+	return fmt.Sprintf("%s%d:%d", peerCredPrefix, addr.uid, addr.gid)
+}
+
+// peerCredConn is a connection on a unix socket whose peer credentials
+// were read when it was accepted
+type peerCredConn struct {
+	net.Conn
+	addr peerCredAddr
+}
+
+func (c *peerCredConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// peerUser returns the user of the process connected on a unix socket,
+// from the remote address of its request, or "" if it is unknown
+func peerUser(remoteAddr string) string {
+	if !strings.HasPrefix(remoteAddr, peerCredPrefix) {
+		return ""
+	}
+	parts := strings.Split(remoteAddr[len(peerCredPrefix):], ":")
+	if len(parts) != 2 {
+		return ""
+	}
+	uid := parts[0]
+	if _, err := strconv.ParseUint(uid, 10, 32); err != nil {
+		return ""
+	}
+	if u, err := user.LookupId(uid); err == nil {
+		return u.Username
+	}
+	return uid
+}
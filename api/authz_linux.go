@@ -0,0 +1,52 @@
+// +build linux
+
+package api
+
+import (
+	"github.com/dotcloud/docker/utils"
+	"net"
+	"syscall"
+)
+
+// peerCredListener reads the credentials of the processes connecting to a
+// unix socket, for the authorization plugin
+type peerCredListener struct {
+	net.Listener
+}
+
+func authzListener(l net.Listener) net.Listener {
+	return &peerCredListener{l}
+}
+
+func (l *peerCredListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	unixc, ok := c.(*net.UnixConn)
+	if !ok {
+		return c, nil
+	}
+	addr, err := peerCred(unixc)
+	if err != nil {
+		utils.Errorf("Couldn't get the credentials of the client: %s", err)
+		return c, nil
+	}
+	return &peerCredConn{Conn: c, addr: addr}, nil
+}
+
+func peerCred(c *net.UnixConn) (peerCredAddr, error) {
+	f, err := c.File()
+	if err != nil {
+		return peerCredAddr{}, err
+	}
+	defer f.Close()
+	fd := int(f.Fd())
+	// File makes the socket blocking, give it back to the poller
+	defer syscall.SetNonblock(fd, true)
+	cred, err := syscall.GetsockoptUcred(fd, syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	if err != nil {
+		return peerCredAddr{}, err
+	}
+	return peerCredAddr{uid: cred.Uid, gid: cred.Gid}, nil
+}
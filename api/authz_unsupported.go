@@ -0,0 +1,13 @@
+// +build !linux
+
+package api
+
+import (
+	"net"
+)
+
+// authzListener returns l, the users of the processes connected on the unix
+// sockets are only known on linux
+func authzListener(l net.Listener) net.Listener {
+	return l
+}
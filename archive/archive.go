@@ -430,6 +430,29 @@ func TarUntar(src string, dst string) error {
 	return Untar(archive, dst, nil)
 }
 
+// Verify reads the whole archive, compressed like those of Untar, and
+// returns an error if it is truncated or corrupted.
+func Verify(archive io.Reader) error {
+	decompressed, err := DecompressStream(archive)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(decompressed)
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if _, err := io.Copy(ioutil.Discard, tr); err != nil {
+			return err
+		}
+	}
+	// Read up to the end of the compressed stream, to check its checksum
+	_, err = io.Copy(ioutil.Discard, decompressed)
+	return err
+}
+
 // UntarPath is a convenience function which looks for an archive
 // at filesystem path `src`, and unpacks it at `dst`.
 func UntarPath(src, dst string) error {
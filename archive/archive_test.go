@@ -3,6 +3,7 @@ package archive
 import (
 	"archive/tar"
 	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -136,3 +137,43 @@ func TestTypeXGlobalHeaderDoesNotFail(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestVerify(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	content := bytes.Repeat([]byte("hello world"), 1000)
+	if err := tw.WriteHeader(&tar.Header{Name: "1", Mode: 0600, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tarData := buf.Bytes()
+
+	buf = bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	if _, err := gw.Write(tarData); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gzipData := buf.Bytes()
+
+	for _, data := range [][]byte{tarData, gzipData} {
+		if err := Verify(bytes.NewReader(data)); err != nil {
+			t.Fatalf("Error verifying an archive: %s", err)
+		}
+		if err := Verify(bytes.NewReader(data[:len(data)/2])); err == nil {
+			t.Fatal("Expected an error verifying a truncated archive")
+		}
+	}
+	// Corrupt the checksum of the compressed stream
+	gzipData[len(gzipData)-5] ^= 0xff
+	if err := Verify(bytes.NewReader(gzipData)); err == nil {
+		t.Fatal("Expected an error verifying a corrupted archive")
+	}
+}
@@ -23,6 +23,7 @@ const INDEXSERVER = "https://index.docker.io/v1/"
 
 var (
 	ErrConfigFileMissing = errors.New("The Auth config file is missing")
+	ErrWrongLogin        = errors.New("Wrong login/password, please try again")
 )
 
 type AuthConfig struct {
@@ -34,8 +35,11 @@ type AuthConfig struct {
 }
 
 type ConfigFile struct {
-	Configs  map[string]AuthConfig `json:"configs,omitempty"`
-	rootPath string
+	Configs map[string]AuthConfig `json:"configs,omitempty"`
+	// CredsStore is the credentials helper keeping the credentials of the
+	// configs, the config file then only has their email
+	CredsStore string `json:"-"`
+	rootPath   string
 }
 
 func IndexServerAddress() string {
@@ -84,7 +88,8 @@ func LoadConfig(rootPath string) (*ConfigFile, error) {
 		return &configFile, err
 	}
 
-	if err := json.Unmarshal(b, &configFile.Configs); err != nil {
+	var entries map[string]json.RawMessage
+	if err := json.Unmarshal(b, &entries); err != nil {
 		arr := strings.Split(string(b), "\n")
 		if len(arr) < 2 {
 			return &configFile, fmt.Errorf("The Auth config file is empty")
@@ -106,11 +111,24 @@ func LoadConfig(rootPath string) (*ConfigFile, error) {
 		authConfig.ServerAddress = IndexServerAddress()
 		configFile.Configs[IndexServerAddress()] = authConfig
 	} else {
-		for k, authConfig := range configFile.Configs {
-			authConfig.Username, authConfig.Password, err = decodeAuth(authConfig.Auth)
-			if err != nil {
+		for k, entry := range entries {
+			if k == "credsStore" {
+				if err := json.Unmarshal(entry, &configFile.CredsStore); err != nil {
+					return &configFile, err
+				}
+				continue
+			}
+			var authConfig AuthConfig
+			if err := json.Unmarshal(entry, &authConfig); err != nil {
 				return &configFile, err
 			}
+			// Without auth, the credentials are kept by the credentials helper
+			if authConfig.Auth != "" {
+				authConfig.Username, authConfig.Password, err = decodeAuth(authConfig.Auth)
+				if err != nil {
+					return &configFile, err
+				}
+			}
 			authConfig.Auth = ""
 			configFile.Configs[k] = authConfig
 			authConfig.ServerAddress = k
@@ -122,21 +140,31 @@ func LoadConfig(rootPath string) (*ConfigFile, error) {
 // save the auth config
 func SaveConfig(configFile *ConfigFile) error {
 	confFile := path.Join(configFile.rootPath, CONFIGFILE)
-	if len(configFile.Configs) == 0 {
+	if len(configFile.Configs) == 0 && configFile.CredsStore == "" {
 		os.Remove(confFile)
 		return nil
 	}
+	if configFile.CredsStore != "" {
+		if err := configFile.storeCredentials(); err != nil {
+			return err
+		}
+	}
 
-	configs := make(map[string]AuthConfig, len(configFile.Configs))
+	configs := make(map[string]interface{}, len(configFile.Configs)+1)
 	for k, authConfig := range configFile.Configs {
 		authCopy := authConfig
 
-		authCopy.Auth = encodeAuth(&authCopy)
+		if configFile.CredsStore == "" {
+			authCopy.Auth = encodeAuth(&authCopy)
+		}
 		authCopy.Username = ""
 		authCopy.Password = ""
 		authCopy.ServerAddress = ""
 		configs[k] = authCopy
 	}
+	if configFile.CredsStore != "" {
+		configs["credsStore"] = configFile.CredsStore
+	}
 
 	b, err := json.Marshal(configs)
 	if err != nil {
@@ -150,12 +178,14 @@ func SaveConfig(configFile *ConfigFile) error {
 }
 
 // try to register/login to the registry server
-func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory) (string, error) {
+// The requests are sent through transport, or the default one if it is nil.
+// The token servers may be reached over http if insecure accepts their host.
+func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory, transport http.RoundTripper, insecure func(host string) bool) (string, error) {
 	var (
 		status        string
 		reqBody       []byte
 		err           error
-		client        = &http.Client{}
+		client        = &http.Client{Transport: transport}
 		reqStatusCode = 0
 		serverAddress = authConfig.ServerAddress
 	)
@@ -177,7 +207,7 @@ func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory) (string, e
 
 	// using `bytes.NewReader(jsonBody)` here causes the server to respond with a 411 status.
 	b := strings.NewReader(string(jsonBody))
-	req1, err := http.Post(serverAddress+"users/", "application/json; charset=utf-8", b)
+	req1, err := client.Post(serverAddress+"users/", "application/json; charset=utf-8", b)
 	if err != nil {
 		return "", fmt.Errorf("Server Error: %s", err)
 	}
@@ -211,7 +241,7 @@ func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory) (string, e
 			if resp.StatusCode == 200 {
 				status = "Login Succeeded"
 			} else if resp.StatusCode == 401 {
-				return "", fmt.Errorf("Wrong login/password, please try again")
+				return "", ErrWrongLogin
 			} else if resp.StatusCode == 403 {
 				if loginAgainstOfficialIndex {
 					return "", fmt.Errorf("Login: Account is not Active. Please check your e-mail for a confirmation link.")
@@ -239,8 +269,15 @@ func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory) (string, e
 		}
 		if resp.StatusCode == 200 {
 			status = "Login Succeeded"
+		} else if challenge := ParseBearerChallenge(resp.Header.Get("WWW-Authenticate")); resp.StatusCode == 401 && challenge != nil {
+			// The registry accepts the tokens of a token server, check
+			// that it gives one for these credentials
+			if _, err := FetchToken(authConfig, challenge, client, insecure); err != nil {
+				return "", err
+			}
+			status = "Login Succeeded"
 		} else if resp.StatusCode == 401 {
-			return "", fmt.Errorf("Wrong login/password, please try again")
+			return "", ErrWrongLogin
 		} else {
 			return "", fmt.Errorf("Login: %s (Code: %d; Headers: %s)", body,
 				resp.StatusCode, resp.Header)
@@ -252,10 +289,28 @@ func Login(authConfig *AuthConfig, factory *utils.HTTPRequestFactory) (string, e
 }
 
 // this method matches a auth configuration to a server address or a url
+// The credentials are asked to the credentials helper if there is one.
 func (config *ConfigFile) ResolveAuthConfig(registry string) AuthConfig {
+	serverAddress, authConfig := config.lookupAuthConfig(registry)
+	if serverAddress == "" {
+		return authConfig
+	}
+	authConfig, err := config.withCredentials(serverAddress, authConfig)
+	if err != nil {
+		utils.Errorf("Couldn't get the credentials of %s: %s", serverAddress, err)
+	}
+	return authConfig
+}
+
+// lookupAuthConfig returns the auth config matching registry, and the
+// server address it is saved for, or "" if there is none
+func (config *ConfigFile) lookupAuthConfig(registry string) (string, AuthConfig) {
 	if registry == IndexServerAddress() || len(registry) == 0 {
 		// default to the index server
-		return config.Configs[IndexServerAddress()]
+		if c, found := config.Configs[IndexServerAddress()]; found {
+			return IndexServerAddress(), c
+		}
+		return "", AuthConfig{}
 	}
 	// if it's not the index server there are three cases:
 	//
@@ -276,16 +331,16 @@ func (config *ConfigFile) ResolveAuthConfig(registry string) AuthConfig {
 		return url
 	}
 
-	resolveIgnoringProtocol := func(url string) AuthConfig {
+	resolveIgnoringProtocol := func(url string) (string, AuthConfig) {
 		if c, found := config.Configs[url]; found {
-			return c
+			return url, c
 		}
 		registrySwappedProtocol := swapProtocol(url)
 		// now try to match with the different protocol
 		if c, found := config.Configs[registrySwappedProtocol]; found {
-			return c
+			return registrySwappedProtocol, c
 		}
-		return AuthConfig{}
+		return "", AuthConfig{}
 	}
 
 	// match both protocols as it could also be a server name like httpfoo
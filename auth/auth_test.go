@@ -1,8 +1,14 @@
 package auth
 
 import (
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path"
+	"strings"
 	"testing"
 )
 
@@ -140,3 +146,172 @@ func TestResolveAuthConfigFullURL(t *testing.T) {
 		}
 	}
 }
+
+func TestParseBearerChallenge(t *testing.T) {
+	challenge := ParseBearerChallenge(`Bearer realm="https://auth.local/token",service="registry.local",scope="repository:foo:push,pull"`)
+	if challenge == nil {
+		t.Fatal("Expected a Bearer challenge")
+	}
+	expected := BearerChallenge{Realm: "https://auth.local/token", Service: "registry.local", Scope: "repository:foo:push,pull"}
+	if *challenge != expected {
+		t.Fatalf("Expected %v, not %v", expected, *challenge)
+	}
+
+	challenge = ParseBearerChallenge(`bearer realm=https://auth.local/token, service="registry \"local\""`)
+	if challenge == nil || challenge.Realm != "https://auth.local/token" || challenge.Service != `registry "local"` {
+		t.Fatalf("Unexpected challenge %v", challenge)
+	}
+
+	for _, header := range []string{"", `Basic realm="registry"`, `Bearer service="registry.local"`} {
+		if challenge := ParseBearerChallenge(header); challenge != nil {
+			t.Fatalf("Expected no Bearer challenge in %q, got %v", header, challenge)
+		}
+	}
+}
+
+func TestFetchTokenInsecureRealm(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"token": "foo"}`)
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authConfig := &AuthConfig{Username: "ken", Password: "test"}
+	challenge := &BearerChallenge{Realm: server.URL + "/token", Service: "registry.local"}
+
+	for _, insecure := range []func(string) bool{
+		nil,
+		func(host string) bool { return false },
+		func(host string) bool { return host == "registry.local" },
+	} {
+		if _, err := FetchToken(authConfig, challenge, &http.Client{}, insecure); err == nil {
+			t.Fatal("Expected an error fetching a token from an http realm which isn't insecure")
+		}
+	}
+	if _, err := FetchToken(authConfig, &BearerChallenge{Realm: "ftp://" + u.Host + "/token"}, &http.Client{}, func(host string) bool { return true }); err == nil {
+		t.Fatal("Expected an error fetching a token from an ftp realm")
+	}
+	if requests != 0 {
+		t.Fatalf("Expected the credentials not to be sent, got %d requests", requests)
+	}
+
+	token, err := FetchToken(authConfig, challenge, &http.Client{}, func(host string) bool { return host == u.Host })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Token != "foo" {
+		t.Fatalf("Expected the token foo, got %s", token.Token)
+	}
+}
+
+// The credentials helper of the tests keeps the credentials of a single
+// server in the file creds of its directory
+const testCredentialsHelper = `#!/bin/sh
+case "$1" in
+get)
+	if [ "$(cat)" = "broken.example.com" ]; then
+		echo '{"Username":"leaked-user","Secret":"leaked-secret"}'
+		echo "keychain locked" >&2
+		exit 1
+	fi
+	if [ ! -f "$(dirname "$0")/creds" ]; then
+		echo "credentials not found in native keychain"
+		exit 1
+	fi
+	cat "$(dirname "$0")/creds"
+	;;
+store)
+	cat > "$(dirname "$0")/creds"
+	;;
+erase)
+	rm "$(dirname "$0")/creds"
+	;;
+esac
+`
+
+func TestCredentialsHelper(t *testing.T) {
+	configFile, err := setupTempConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configFile.rootPath)
+	helperDir := path.Join(configFile.rootPath, "helper")
+	if err := os.Mkdir(helperDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(helperDir, "docker-credential-test"), []byte(testCredentialsHelper), 0700); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", helperDir+":"+os.Getenv("PATH"))
+
+	delete(configFile.Configs, "testIndex")
+	configFile.CredsStore = "test"
+	if err := SaveConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path.Join(configFile.rootPath, CONFIGFILE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), encodeAuth(&AuthConfig{Username: "docker-user", Password: "docker-pass"})) {
+		t.Fatalf("Expected the credentials not to be saved in the config file: %s", b)
+	}
+	creds, err := ioutil.ReadFile(path.Join(helperDir, "creds"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(creds), "docker-pass") {
+		t.Fatalf("Expected the credentials to be stored by the helper: %s", creds)
+	}
+
+	configFile, err = LoadConfig(configFile.rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if configFile.CredsStore != "test" {
+		t.Fatalf("Expected the credsStore to be test, not %q", configFile.CredsStore)
+	}
+	resolved := configFile.ResolveAuthConfig(IndexServerAddress())
+	if resolved.Username != "docker-user" || resolved.Password != "docker-pass" || resolved.Email != "docker@docker.io" {
+		t.Fatalf("Unexpected auth config %v", resolved)
+	}
+
+	// The credentials of the servers removed from the config are erased
+	delete(configFile.Configs, IndexServerAddress())
+	if err := SaveConfig(configFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(helperDir, "creds")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the credentials to be erased: %v", err)
+	}
+	if resolved := configFile.ResolveAuthConfig(IndexServerAddress()); resolved.Username != "" {
+		t.Fatalf("Unexpected auth config %v", resolved)
+	}
+
+	// A failing helper doesn't prevent getting the other credentials, and
+	// what it prints on its stdout is kept out of the error
+	configFile.Configs["broken.example.com"] = AuthConfig{}
+	configFile.Configs["other.example.com"] = AuthConfig{Username: "other-user", Password: "other-pass"}
+	configFile.LoadCredentials()
+	if other := configFile.Configs["other.example.com"]; other.Password != "other-pass" {
+		t.Fatalf("Unexpected auth config %v", other)
+	}
+	if broken := configFile.Configs["broken.example.com"]; broken.Username != "" || broken.Password != "" {
+		t.Fatalf("Unexpected auth config %v", broken)
+	}
+	_, _, err = credentialsHelper("test").get("broken.example.com")
+	if err == nil {
+		t.Fatal("Expected an error from the helper")
+	}
+	if strings.Contains(err.Error(), "leaked") {
+		t.Fatalf("Expected the stdout of the helper not to be in the error: %s", err)
+	}
+	if !strings.Contains(err.Error(), "keychain locked") {
+		t.Fatalf("Expected the stderr of the helper in the error: %s", err)
+	}
+}
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+	"os/exec"
+	"strings"
+)
+
+// The credentials helpers keep the credentials of the registries out of the
+// config file, for instance in the keychain of the system. The helper of the
+// credsStore foo is the program docker-credential-foo, run with the action
+// get, store or erase as argument. It reads the server address or, for
+// store, the credentials as JSON on its stdin, and writes the credentials
+// as JSON on its stdout for get.
+const credentialsHelperPrefix = "docker-credential-"
+
+// The helpers fail with this message when they have no credentials for a
+// server
+const errCredentialsNotFound = "credentials not found in native keychain"
+
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+type credentialsHelper string
+
+// run runs the helper with action, and returns its stdout even when it
+// fails. The error only tells its stderr, as the stdout might hold
+// credentials.
+func (helper credentialsHelper) run(action string, input []byte) ([]byte, error) {
+	name := credentialsHelperPrefix + string(helper)
+	cmd := exec.Command(name, action)
+	cmd.Stdin = bytes.NewReader(input)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return stdout.Bytes(), fmt.Errorf("%s %s: %s", name, action, msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// notFound returns whether the helper failed because it has no
+// credentials, which it tells on its stdout
+func notFound(out []byte) bool {
+	return bytes.Contains(out, []byte(errCredentialsNotFound))
+}
+
+// get returns the credentials of serverAddress, which are empty if the
+// helper has none
+func (helper credentialsHelper) get(serverAddress string) (string, string, error) {
+	out, err := helper.run("get", []byte(serverAddress))
+	if err != nil {
+		if notFound(out) {
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	var creds helperCredentials
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return "", "", fmt.Errorf("Invalid credentials from %s%s: %s", credentialsHelperPrefix, helper, err)
+	}
+	return creds.Username, creds.Secret, nil
+}
+
+func (helper credentialsHelper) store(serverAddress string, authConfig AuthConfig) error {
+	input, err := json.Marshal(&helperCredentials{
+		ServerURL: serverAddress,
+		Username:  authConfig.Username,
+		Secret:    authConfig.Password,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = helper.run("store", input)
+	return err
+}
+
+func (helper credentialsHelper) erase(serverAddress string) error {
+	if out, err := helper.run("erase", []byte(serverAddress)); err != nil && !notFound(out) {
+		return err
+	}
+	return nil
+}
+
+// withCredentials returns authConfig, the config of serverAddress, with its
+// credentials from the credentials helper when they aren't in the file
+func (config *ConfigFile) withCredentials(serverAddress string, authConfig AuthConfig) (AuthConfig, error) {
+	if config.CredsStore == "" || authConfig.Username != "" {
+		return authConfig, nil
+	}
+	username, password, err := credentialsHelper(config.CredsStore).get(serverAddress)
+	if err != nil {
+		return authConfig, err
+	}
+	authConfig.Username = username
+	authConfig.Password = password
+	return authConfig, nil
+}
+
+// GetAuthConfig returns the config saved for serverAddress, with its
+// credentials
+func (config *ConfigFile) GetAuthConfig(serverAddress string) (AuthConfig, error) {
+	authConfig, exists := config.Configs[serverAddress]
+	if !exists {
+		return AuthConfig{}, nil
+	}
+	return config.withCredentials(serverAddress, authConfig)
+}
+
+// storeCredentials stores the credentials of the configs with the
+// credentials helper, and erases those of the servers removed from the
+// config file
+func (config *ConfigFile) storeCredentials() error {
+	helper := credentialsHelper(config.CredsStore)
+	for serverAddress, authConfig := range config.Configs {
+		// The configs without username still have their credentials
+		// in the helper
+		if authConfig.Username == "" {
+			continue
+		}
+		if err := helper.store(serverAddress, authConfig); err != nil {
+			return err
+		}
+	}
+	saved, err := LoadConfig(config.rootPath)
+	if err != nil {
+		return err
+	}
+	for serverAddress := range saved.Configs {
+		if _, exists := config.Configs[serverAddress]; !exists {
+			if err := helper.erase(serverAddress); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// LoadCredentials gets the credentials of all the servers from the
+// credentials helper, so that the configs can be sent to the daemon. The
+// servers whose credentials can't be got are left without, like with
+// ResolveAuthConfig.
+func (config *ConfigFile) LoadCredentials() {
+	for serverAddress, authConfig := range config.Configs {
+		authConfig, err := config.withCredentials(serverAddress, authConfig)
+		if err != nil {
+			utils.Errorf("Couldn't get the credentials of %s: %s", serverAddress, err)
+			continue
+		}
+		config.Configs[serverAddress] = authConfig
+	}
+}
@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The lifetime of the tokens which don't have one
+const defaultTokenExpiration = 60 * time.Second
+
+// BearerChallenge is the challenge of a registry asking for a token, from
+// its WWW-Authenticate header, such as Bearer realm="https://auth.local/token",
+// service="registry.local",scope="repository:foo:pull"
+type BearerChallenge struct {
+	Realm   string
+	Service string
+	Scope   string
+}
+
+// Token is a token given by the realm of a challenge
+type Token struct {
+	Token   string
+	Expires time.Time
+}
+
+func (token *Token) Expired() bool {
+	return !time.Now().Before(token.Expires)
+}
+
+// ParseBearerChallenge returns the Bearer challenge of a WWW-Authenticate
+// header, or nil if it has none
+func ParseBearerChallenge(header string) *BearerChallenge {
+	header = strings.TrimSpace(header)
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return nil
+	}
+	params := parseChallengeParams(header[7:])
+	if params["realm"] == "" {
+		return nil
+	}
+	return &BearerChallenge{
+		Realm:   params["realm"],
+		Service: params["service"],
+		Scope:   params["scope"],
+	}
+}
+
+// parseChallengeParams parses the key=value parameters of a challenge. The
+// values may be quoted, and then contain commas and escaped characters.
+func parseChallengeParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " ,")
+		i := strings.Index(s, "=")
+		if i == -1 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:i]))
+		s = strings.TrimLeft(s[i+1:], " ")
+		var value []byte
+		if strings.HasPrefix(s, `"`) {
+			i = 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				value = append(value, s[i])
+			}
+			s = s[i:]
+			if len(s) > 0 {
+				s = s[1:]
+			}
+		} else {
+			i = strings.Index(s, ",")
+			if i == -1 {
+				i = len(s)
+			}
+			value = []byte(strings.TrimSpace(s[:i]))
+			s = s[i:]
+		}
+		params[key] = string(value)
+	}
+}
+
+// FetchToken asks the realm of challenge for a token, with the credentials
+// of authConfig if it has some. The realm must use https, unless insecure
+// accepts its host.
+func FetchToken(authConfig *AuthConfig, challenge *BearerChallenge, client *http.Client, insecure func(host string) bool) (*Token, error) {
+	u, err := url.Parse(challenge.Realm)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid token realm %s: %s", challenge.Realm, err)
+	}
+	// The credentials and the token must not be sent in clear to a realm
+	// which the registry chose
+	if u.Scheme != "https" && (u.Scheme != "http" || insecure == nil || !insecure(u.Host)) {
+		return nil, fmt.Errorf("The token realm %s must use https, unless its host is an insecure registry", challenge.Realm)
+	}
+	q := u.Query()
+	if challenge.Service != "" {
+		q.Set("service", challenge.Service)
+	}
+	if challenge.Scope != "" {
+		q.Set("scope", challenge.Scope)
+	}
+	if authConfig.Username != "" {
+		q.Set("account", authConfig.Username)
+	}
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if authConfig.Username != "" {
+		req.SetBasicAuth(authConfig.Username, authConfig.Password)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == 401 {
+		return nil, ErrWrongLogin
+	} else if res.StatusCode != 200 {
+		return nil, fmt.Errorf("Token server %s: %s (Code: %d)", challenge.Realm, body, res.StatusCode)
+	}
+
+	var tokenRes struct {
+		Token       string    `json:"token"`
+		AccessToken string    `json:"access_token"`
+		ExpiresIn   int       `json:"expires_in"`
+		IssuedAt    time.Time `json:"issued_at"`
+	}
+	if err := json.Unmarshal(body, &tokenRes); err != nil {
+		return nil, fmt.Errorf("Invalid response of the token server %s: %s", challenge.Realm, err)
+	}
+	token := &Token{Token: tokenRes.Token}
+	if token.Token == "" {
+		// The OAuth2 servers name it access_token
+		token.Token = tokenRes.AccessToken
+	}
+	if token.Token == "" {
+		return nil, fmt.Errorf("The token server %s didn't return a token", challenge.Realm)
+	}
+	expiration := defaultTokenExpiration
+	if tokenRes.ExpiresIn > 0 {
+		expiration = time.Duration(tokenRes.ExpiresIn) * time.Second
+	}
+	issuedAt := tokenRes.IssuedAt
+	if issuedAt.IsZero() {
+		issuedAt = time.Now()
+	}
+	token.Expires = issuedAt.Add(expiration)
+	return token, nil
+}
@@ -77,7 +77,7 @@ func (b *buildFile) CmdFrom(name string) error {
 			pullRegistryAuth := b.authConfig
 			if len(b.configFile.Configs) > 0 {
 				// The request came with a full auth config file, we prefer to use that
-				endpoint, _, err := registry.ResolveRepositoryName(remote)
+				endpoint, err := registry.RegistryAddress(remote)
 				if err != nil {
 					return err
 				}
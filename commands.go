@@ -4,6 +4,7 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -56,8 +57,8 @@ func (cli *DockerCli) getMethod(name string) (func(...string) error, bool) {
 	return method.Interface().(func(...string) error), true
 }
 
-func ParseCommands(proto, addr string, args ...string) error {
-	cli := NewDockerCli(os.Stdin, os.Stdout, os.Stderr, proto, addr)
+func ParseCommands(proto, addr string, tlsConfig *tls.Config, args ...string) error {
+	cli := NewDockerCli(os.Stdin, os.Stdout, os.Stderr, proto, addr, tlsConfig)
 
 	if len(args) > 0 {
 		method, exists := cli.getMethod(args[0])
@@ -88,7 +89,9 @@ func (cli *DockerCli) CmdHelp(args ...string) error {
 		{"cp", "Copy files/folders from the containers filesystem to the host path"},
 		{"diff", "Inspect changes on a container's filesystem"},
 		{"events", "Get real time events from the server"},
+		{"exec", "Run a command in a running container"},
 		{"export", "Stream the contents of a container as a tar archive"},
+		{"gc", "Remove unused images, containers and volumes"},
 		{"history", "Show the history of an image"},
 		{"images", "List images"},
 		{"import", "Create a new filesystem image from the contents of a tarball"},
@@ -99,6 +102,7 @@ func (cli *DockerCli) CmdHelp(args ...string) error {
 		{"load", "Load an image from a tar archive"},
 		{"login", "Register or Login to the docker registry server"},
 		{"logs", "Fetch the logs of a container"},
+		{"pause", "Pause all processes within a container"},
 		{"port", "Lookup the public-facing port which is NAT-ed to PRIVATE_PORT"},
 		{"ps", "List containers"},
 		{"pull", "Pull an image or a repository from the docker registry server"},
@@ -110,10 +114,13 @@ func (cli *DockerCli) CmdHelp(args ...string) error {
 		{"save", "Save an image to a tar archive"},
 		{"search", "Search for an image in the docker index"},
 		{"start", "Start a stopped container"},
+		{"stats", "Display a live stream of a container's resource usage"},
 		{"stop", "Stop a running container"},
 		{"tag", "Tag an image into a repository"},
 		{"top", "Lookup the running processes of a container"},
+		{"unpause", "Unpause a paused container"},
 		{"version", "Show the docker version information"},
+		{"volume", "Manage volumes"},
 		{"wait", "Block until a container stops, then print its exit code"},
 	} {
 		help += fmt.Sprintf("    %-10.10s%s\n", command[0], command[1])
@@ -229,6 +236,8 @@ func (cli *DockerCli) CmdBuild(args ...string) error {
 	}
 
 	cli.LoadConfigFile()
+	// The daemon can't ask the credentials helper
+	cli.configFile.LoadCredentials()
 
 	headers := http.Header(make(map[string][]string))
 	buf, err := json.Marshal(cli.configFile)
@@ -288,9 +297,9 @@ func (cli *DockerCli) CmdLogin(args ...string) error {
 	}
 
 	cli.LoadConfigFile()
-	authconfig, ok := cli.configFile.Configs[serverAddress]
-	if !ok {
-		authconfig = auth.AuthConfig{}
+	authconfig, err := cli.configFile.GetAuthConfig(serverAddress)
+	if err != nil {
+		return err
 	}
 
 	if username == "" {
@@ -483,8 +492,8 @@ func (cli *DockerCli) CmdInfo(args ...string) error {
 
 	if len(remoteInfo.GetList("IndexServerAddress")) != 0 {
 		cli.LoadConfigFile()
-		u := cli.configFile.Configs[remoteInfo.Get("IndexServerAddress")].Username
-		if len(u) > 0 {
+		authConfig, _ := cli.configFile.GetAuthConfig(remoteInfo.Get("IndexServerAddress"))
+		if u := authConfig.Username; len(u) > 0 {
 			fmt.Fprintf(cli.out, "Username: %v\n", u)
 			fmt.Fprintf(cli.out, "Registry: %v\n", remoteInfo.GetList("IndexServerAddress"))
 		}
@@ -528,6 +537,50 @@ func (cli *DockerCli) CmdStop(args ...string) error {
 	return encounteredError
 }
 
+func (cli *DockerCli) CmdPause(args ...string) error {
+	cmd := cli.Subcmd("pause", "CONTAINER [CONTAINER...]", "Pause all processes within a container")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() < 1 {
+		cmd.Usage()
+		return nil
+	}
+
+	var encounteredError error
+	for _, name := range cmd.Args() {
+		if _, _, err := readBody(cli.call("POST", "/containers/"+name+"/pause", nil, false)); err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			encounteredError = fmt.Errorf("Error: failed to pause one or more containers")
+		} else {
+			fmt.Fprintf(cli.out, "%s\n", name)
+		}
+	}
+	return encounteredError
+}
+
+func (cli *DockerCli) CmdUnpause(args ...string) error {
+	cmd := cli.Subcmd("unpause", "CONTAINER [CONTAINER...]", "Unpause all processes within a container")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() < 1 {
+		cmd.Usage()
+		return nil
+	}
+
+	var encounteredError error
+	for _, name := range cmd.Args() {
+		if _, _, err := readBody(cli.call("POST", "/containers/"+name+"/unpause", nil, false)); err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			encounteredError = fmt.Errorf("Error: failed to unpause one or more containers")
+		} else {
+			fmt.Fprintf(cli.out, "%s\n", name)
+		}
+	}
+	return encounteredError
+}
+
 func (cli *DockerCli) CmdRestart(args ...string) error {
 	cmd := cli.Subcmd("restart", "[OPTIONS] CONTAINER [CONTAINER...]", "Restart a running container")
 	nSeconds := cmd.Int([]string{"t", "-time"}, 10, "Number of seconds to try to stop for before killing the container. Once killed it will then be restarted. Default=10")
@@ -772,6 +825,65 @@ func (cli *DockerCli) CmdTop(args ...string) error {
 	return nil
 }
 
+func (cli *DockerCli) CmdStats(args ...string) error {
+	cmd := cli.Subcmd("stats", "[OPTIONS] CONTAINER", "Display a live stream of a container's resource usage")
+	noStream := cmd.Bool([]string{"-no-stream"}, false, "Only display the current statistics and exit")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 1 {
+		cmd.Usage()
+		return nil
+	}
+	name := cmd.Arg(0)
+
+	v := url.Values{}
+	if *noStream {
+		v.Set("stream", "0")
+	}
+	stream, _, err := cli.call("GET", "/containers/"+name+"/stats?"+v.Encode(), nil, false)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	format := "%-20s %-8s %-24s %-8s %-24s %s\n"
+	fmt.Fprintf(cli.out, format, "CONTAINER", "CPU %", "MEM USAGE/LIMIT", "MEM %", "NET I/O", "BLOCK I/O")
+
+	var (
+		dec      = json.NewDecoder(stream)
+		previous *ContainerStats
+	)
+	for {
+		stats := &ContainerStats{}
+		if err := dec.Decode(stats); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		// The CPU usage is relative to the whole host, since the
+		// previous statistics
+		cpuPercent := "--"
+		if previous != nil && stats.SystemCpuUsage > previous.SystemCpuUsage {
+			cpuPercent = fmt.Sprintf("%.2f%%", float64(stats.CpuUsage-previous.CpuUsage)/float64(stats.SystemCpuUsage-previous.SystemCpuUsage)*100)
+		}
+		var memPercent float64
+		if stats.MemoryLimit != 0 {
+			memPercent = float64(stats.MemoryUsage) / float64(stats.MemoryLimit) * 100
+		}
+		fmt.Fprintf(cli.out, format,
+			utils.TruncateID(name),
+			cpuPercent,
+			fmt.Sprintf("%s/%s", utils.HumanSize(int64(stats.MemoryUsage)), utils.HumanSize(int64(stats.MemoryLimit))),
+			fmt.Sprintf("%.2f%%", memPercent),
+			fmt.Sprintf("%s/%s", utils.HumanSize(int64(stats.Network.RxBytes)), utils.HumanSize(int64(stats.Network.TxBytes))),
+			fmt.Sprintf("%s/%s", utils.HumanSize(int64(stats.BlkioRead)), utils.HumanSize(int64(stats.BlkioWrite))),
+		)
+		previous = stats
+	}
+}
+
 func (cli *DockerCli) CmdPort(args ...string) error {
 	cmd := cli.Subcmd("port", "CONTAINER PRIVATE_PORT", "Lookup the public-facing port which is NAT-ed to PRIVATE_PORT")
 	if err := cmd.Parse(args); err != nil {
@@ -935,6 +1047,216 @@ func (cli *DockerCli) CmdRm(args ...string) error {
 	return encounteredError
 }
 
+func (cli *DockerCli) CmdGc(args ...string) error {
+	cmd := cli.Subcmd("gc", "[OPTIONS]", "Remove the untagged images used by no container, the volumes and the storage driver layers of no container, and optionally the old stopped containers")
+	dryRun := cmd.Bool([]string{"n", "-dry-run"}, false, "Only show what would be removed")
+	containerAge := cmd.String([]string{"-container-age"}, "", "Also remove the containers stopped for longer than this duration, such as 24h")
+
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		return nil
+	}
+	val := url.Values{}
+	if *dryRun {
+		val.Set("dryrun", "1")
+	}
+	if *containerAge != "" {
+		val.Set("containerage", *containerAge)
+	}
+
+	body, _, err := readBody(cli.call("POST", "/gc?"+val.Encode(), nil, false))
+	if err != nil {
+		return err
+	}
+	outs := engine.NewTable("", 0)
+	if _, err := outs.ReadListFrom(body); err != nil {
+		return err
+	}
+
+	verb := "Removed"
+	if *dryRun {
+		verb = "Would remove"
+	}
+	var total int64
+	for _, out := range outs.Data {
+		fmt.Fprintf(cli.out, "%s %s %s (%s)\n", verb, out.Get("Type"), utils.TruncateID(out.Get("ID")), utils.HumanSize(out.GetInt64("Size")))
+		total += out.GetInt64("Size")
+	}
+	if *dryRun {
+		fmt.Fprintf(cli.out, "Space that would be reclaimed: %s\n", utils.HumanSize(total))
+	} else {
+		fmt.Fprintf(cli.out, "Space reclaimed: %s\n", utils.HumanSize(total))
+	}
+	return nil
+}
+
+// 'docker volume COMMAND' manages the volumes
+func (cli *DockerCli) CmdVolume(args ...string) error {
+	commands := []struct {
+		name, description string
+		run               func(...string) error
+	}{
+		{"create", "Create a volume", cli.volumeCreate},
+		{"inspect", "Return low-level information on one or more volumes", cli.volumeInspect},
+		{"ls", "List volumes", cli.volumeLs},
+		{"rm", "Remove one or more volumes", cli.volumeRm},
+	}
+	if len(args) > 0 {
+		for _, command := range commands {
+			if command.name == args[0] {
+				return command.run(args[1:]...)
+			}
+		}
+	}
+	help := "\nUsage: docker volume COMMAND\n\nManage volumes\n\nCommands:\n"
+	for _, command := range commands {
+		help += fmt.Sprintf("    %-10.10s%s\n", command.name, command.description)
+	}
+	fmt.Fprintf(cli.err, "%s\n", help)
+	return nil
+}
+
+func (cli *DockerCli) volumeCreate(args ...string) error {
+	cmd := cli.Subcmd("volume create", "[OPTIONS] [NAME]", "Create a volume, with a random name if none is given")
+	driver := cmd.String([]string{"d", "-driver"}, "", "Driver of the volume, local by default")
+	var opts ListOpts
+	cmd.Var(&opts, []string{"o", "-opt"}, "Add an option of the driver (e.g. -o size=10G)")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() > 1 {
+		cmd.Usage()
+		return nil
+	}
+	val := url.Values{}
+	if cmd.NArg() == 1 {
+		val.Set("name", cmd.Arg(0))
+	}
+	if *driver != "" {
+		val.Set("driver", *driver)
+	}
+	for _, opt := range opts.GetAll() {
+		val.Add("opt", opt)
+	}
+	body, _, err := readBody(cli.call("POST", "/volumes/create?"+val.Encode(), nil, false))
+	if err != nil {
+		return err
+	}
+	out := &engine.Env{}
+	if err := out.Decode(bytes.NewReader(body)); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "%s\n", out.Get("Name"))
+	return nil
+}
+
+func (cli *DockerCli) volumeInspect(args ...string) error {
+	cmd := cli.Subcmd("volume inspect", "VOLUME [VOLUME...]", "Return low-level information on one or more volumes")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() < 1 {
+		cmd.Usage()
+		return nil
+	}
+
+	indented := new(bytes.Buffer)
+	indented.WriteByte('[')
+	status := 0
+
+	for _, name := range cmd.Args() {
+		obj, _, err := readBody(cli.call("GET", "/volumes/"+name+"/json", nil, false))
+		if err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			status = 1
+			continue
+		}
+		if err = json.Indent(indented, obj, "", "    "); err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			status = 1
+			continue
+		}
+		indented.WriteString(",")
+	}
+
+	if indented.Len() > 1 {
+		// Remove trailing ','
+		indented.Truncate(indented.Len() - 1)
+	}
+	indented.WriteString("]\n")
+	if _, err := io.Copy(cli.out, indented); err != nil {
+		return err
+	}
+	if status != 0 {
+		return &utils.StatusError{StatusCode: status}
+	}
+	return nil
+}
+
+func (cli *DockerCli) volumeLs(args ...string) error {
+	cmd := cli.Subcmd("volume ls", "[OPTIONS]", "List volumes")
+	quiet := cmd.Bool([]string{"q", "-quiet"}, false, "Only display the volume names")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() != 0 {
+		cmd.Usage()
+		return nil
+	}
+
+	body, _, err := readBody(cli.call("GET", "/volumes/json", nil, false))
+	if err != nil {
+		return err
+	}
+	outs := engine.NewTable("", 0)
+	if _, err := outs.ReadListFrom(body); err != nil {
+		return err
+	}
+
+	if *quiet {
+		for _, out := range outs.Data {
+			fmt.Fprintln(cli.out, out.Get("Name"))
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDRIVER\tCREATED\tCONTAINERS")
+	for _, out := range outs.Data {
+		containers := out.GetList("Containers")
+		for i, id := range containers {
+			containers[i] = utils.TruncateID(id)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s ago\t%s\n", out.Get("Name"), out.Get("Driver"), utils.HumanDuration(time.Now().UTC().Sub(time.Unix(out.GetInt64("Created"), 0))), strings.Join(containers, ","))
+	}
+	w.Flush()
+	return nil
+}
+
+func (cli *DockerCli) volumeRm(args ...string) error {
+	cmd := cli.Subcmd("volume rm", "VOLUME [VOLUME...]", "Remove one or more volumes which no container uses")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() < 1 {
+		cmd.Usage()
+		return nil
+	}
+
+	var encounteredError error
+	for _, name := range cmd.Args() {
+		if _, _, err := readBody(cli.call("DELETE", "/volumes/"+name, nil, false)); err != nil {
+			fmt.Fprintf(cli.err, "%s\n", err)
+			encounteredError = fmt.Errorf("Error: failed to remove one or more volumes")
+		} else {
+			fmt.Fprintf(cli.out, "%s\n", name)
+		}
+	}
+	return encounteredError
+}
+
 // 'docker kill NAME' kills a running container
 func (cli *DockerCli) CmdKill(args ...string) error {
 	cmd := cli.Subcmd("kill", "[OPTIONS] CONTAINER [CONTAINER...]", "Kill a running container (send SIGKILL, or specified signal)")
@@ -1008,8 +1330,8 @@ func (cli *DockerCli) CmdPush(args ...string) error {
 
 	cli.LoadConfigFile()
 
-	// Resolve the Repository name from fqn to endpoint + name
-	endpoint, _, err := registry.ResolveRepositoryName(name)
+	// Resolve the address of the registry of the repository
+	endpoint, err := registry.RegistryAddress(name)
 	if err != nil {
 		return err
 	}
@@ -1020,7 +1342,7 @@ func (cli *DockerCli) CmdPush(args ...string) error {
 	// Custom repositories can have different rules, and we must also
 	// allow pushing by image ID.
 	if len(strings.SplitN(name, "/", 2)) == 1 {
-		username := cli.configFile.Configs[auth.IndexServerAddress()].Username
+		username := authConfig.Username
 		if username == "" {
 			username = "<user>"
 		}
@@ -1073,8 +1395,8 @@ func (cli *DockerCli) CmdPull(args ...string) error {
 		*tag = parsedTag
 	}
 
-	// Resolve the Repository name from fqn to endpoint + name
-	endpoint, _, err := registry.ResolveRepositoryName(remote)
+	// Resolve the address of the registry of the repository
+	endpoint, err := registry.RegistryAddress(remote)
 	if err != nil {
 		return err
 	}
@@ -1425,6 +1747,7 @@ func (cli *DockerCli) CmdCommit(args ...string) error {
 	flComment := cmd.String([]string{"m", "-message"}, "", "Commit message")
 	flAuthor := cmd.String([]string{"a", "#author", "-author"}, "", "Author (eg. \"John Hannibal Smith <hannibal@a-team.com>\"")
 	flConfig := cmd.String([]string{"#run", "-run"}, "", "Config automatically applied when the image is run. "+`(ex: -run='{"Cmd": ["cat", "/world"], "PortSpecs": ["22"]}')`)
+	flPause := cmd.Bool([]string{"p", "-pause"}, true, "Pause the container during commit")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
@@ -1450,6 +1773,9 @@ func (cli *DockerCli) CmdCommit(args ...string) error {
 	v.Set("tag", tag)
 	v.Set("comment", *flComment)
 	v.Set("author", *flAuthor)
+	if !*flPause {
+		v.Set("pause", "0")
+	}
 	var (
 		config *Config
 		env    engine.Env
@@ -1558,8 +1884,13 @@ func (cli *DockerCli) CmdDiff(args ...string) error {
 }
 
 func (cli *DockerCli) CmdLogs(args ...string) error {
-	cmd := cli.Subcmd("logs", "CONTAINER", "Fetch the logs of a container")
+	cmd := cli.Subcmd("logs", "[OPTIONS] CONTAINER", "Fetch the logs of a container")
 	follow := cmd.Bool([]string{"f", "-follow"}, false, "Follow log output")
+	times := cmd.Bool([]string{"t", "-timestamps"}, false, "Show timestamps")
+	tail := cmd.String([]string{"-tail"}, "all", "Output the specified number of lines at the end of logs (defaults to all logs)")
+	since := cmd.Int64([]string{"-since"}, 0, "Only output logs written since the given unix timestamp")
+	stdout := cmd.Bool([]string{"-stdout"}, true, "Output the stdout of the container")
+	stderr := cmd.Bool([]string{"-stderr"}, true, "Output the stderr of the container")
 	if err := cmd.Parse(args); err != nil {
 		return nil
 	}
@@ -1580,17 +1911,31 @@ func (cli *DockerCli) CmdLogs(args ...string) error {
 	}
 
 	v := url.Values{}
-	v.Set("logs", "1")
-	v.Set("stdout", "1")
-	v.Set("stderr", "1")
-	if *follow && container.State.Running {
-		v.Set("stream", "1")
+	v.Set("stdout", strconv.FormatBool(*stdout))
+	v.Set("stderr", strconv.FormatBool(*stderr))
+	v.Set("tail", *tail)
+	if *follow {
+		v.Set("follow", "1")
+	}
+	if *times {
+		v.Set("timestamps", "1")
+	}
+	if *since != 0 {
+		v.Set("since", strconv.FormatInt(*since, 10))
 	}
 
-	if err := cli.hijack("POST", "/containers/"+name+"/attach?"+v.Encode(), container.Config.Tty, nil, cli.out, cli.err, nil); err != nil {
+	stream, _, err := cli.call("GET", "/containers/"+name+"/logs?"+v.Encode(), nil, false)
+	if err != nil {
 		return err
 	}
-	return nil
+	defer stream.Close()
+
+	if container.Config.Tty {
+		_, err = io.Copy(cli.out, stream)
+	} else {
+		_, err = utils.StdCopy(cli.out, cli.err, stream)
+	}
+	return err
 }
 
 func (cli *DockerCli) CmdAttach(args ...string) error {
@@ -1657,6 +2002,73 @@ func (cli *DockerCli) CmdAttach(args ...string) error {
 	return nil
 }
 
+func (cli *DockerCli) CmdExec(args ...string) error {
+	cmd := cli.Subcmd("exec", "[OPTIONS] CONTAINER COMMAND [ARG...]", "Run a command in a running container")
+	flStdin := cmd.Bool([]string{"i", "-interactive"}, false, "Keep stdin open even if not attached")
+	flTty := cmd.Bool([]string{"t", "-tty"}, false, "Allocate a pseudo-tty")
+	flUser := cmd.String([]string{"u", "-user"}, "", "Username or UID")
+	if err := cmd.Parse(args); err != nil {
+		return nil
+	}
+	if cmd.NArg() < 2 {
+		cmd.Usage()
+		return nil
+	}
+	name := cmd.Arg(0)
+	body, _, err := readBody(cli.call("GET", "/containers/"+name+"/json", nil, false))
+	if err != nil {
+		return err
+	}
+
+	container := &Container{}
+	if err := json.Unmarshal(body, container); err != nil {
+		return err
+	}
+
+	if !container.State.IsRunning() {
+		return fmt.Errorf("Impossible to exec in a stopped container, start it first")
+	}
+
+	var in io.ReadCloser
+
+	// The id of the exec, to get its exit code once it exits
+	id := utils.RandomString()
+	v := url.Values{}
+	v.Set("id", id)
+	for _, arg := range cmd.Args()[1:] {
+		v.Add("cmd", arg)
+	}
+	if *flUser != "" {
+		v.Set("user", *flUser)
+	}
+	if *flTty {
+		v.Set("tty", "1")
+	}
+	if *flStdin {
+		v.Set("stdin", "1")
+		in = cli.in
+	}
+	v.Set("stdout", "1")
+	v.Set("stderr", "1")
+
+	if err := cli.hijack("POST", "/containers/"+name+"/exec?"+v.Encode(), *flTty, in, cli.out, cli.err, nil); err != nil {
+		return err
+	}
+
+	stream, _, err := cli.call("POST", "/exec/"+id+"/wait", nil, false)
+	if err != nil {
+		return err
+	}
+	var out engine.Env
+	if err := out.Decode(stream); err != nil {
+		return err
+	}
+	if status := out.GetInt("StatusCode"); status != 0 {
+		return &utils.StatusError{StatusCode: status}
+	}
+	return nil
+}
+
 func (cli *DockerCli) CmdSearch(args ...string) error {
 	cmd := cli.Subcmd("search", "TERM", "Search the docker index for images")
 	noTrunc := cmd.Bool([]string{"#notrunc", "-no-trunc"}, false, "Don't truncate output")
@@ -1766,6 +2178,7 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		flDns         ListOpts
 		flVolumesFrom ListOpts
 		flLxcOpts     ListOpts
+		flLogOpts     ListOpts
 
 		flAutoRemove      = cmd.Bool([]string{"#rm", "-rm"}, false, "Automatically remove the container when it exits (incompatible with -d)")
 		flDetach          = cmd.Bool([]string{"d", "-detach"}, false, "Detached mode: Run container in the background, print new container id")
@@ -1781,6 +2194,9 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		flUser            = cmd.String([]string{"u", "-user"}, "", "Username or UID")
 		flWorkingDir      = cmd.String([]string{"w", "-workdir"}, "", "Working directory inside the container")
 		flCpuShares       = cmd.Int64([]string{"c", "-cpu-shares"}, 0, "CPU shares (relative weight)")
+		flRestartPolicy   = cmd.String([]string{"#restart", "-restart"}, "", "Restart policy to apply when the container exits (no, always, on-failure[:max-retries])")
+		flLogDriver       = cmd.String([]string{"-log-driver"}, "", "Driver for the output of the container (json-file, syslog or none), the one of the daemon by default")
+		flVolumeDriver    = cmd.String([]string{"-volume-driver"}, "", "Driver of the volumes created for the container, local by default")
 
 		// For documentation purpose
 		_ = cmd.Bool([]string{"#sig-proxy", "-sig-proxy"}, true, "Proxify all received signal to the process (even in non-tty mode)")
@@ -1788,7 +2204,7 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 	)
 
 	cmd.Var(&flAttach, []string{"a", "-attach"}, "Attach to stdin, stdout or stderr.")
-	cmd.Var(&flVolumes, []string{"v", "-volume"}, "Bind mount a volume (e.g. from the host: -v /host:/container, from docker: -v /container)")
+	cmd.Var(&flVolumes, []string{"v", "-volume"}, "Bind mount a volume (e.g. from the host: -v /host:/container, from docker: -v /container, a named volume: -v name:/container)")
 	cmd.Var(&flLinks, []string{"#link", "-link"}, "Add link to another container (name:alias)")
 	cmd.Var(&flEnv, []string{"e", "-env"}, "Set environment variables")
 
@@ -1797,6 +2213,7 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 	cmd.Var(&flDns, []string{"#dns", "-dns"}, "Set custom dns servers")
 	cmd.Var(&flVolumesFrom, []string{"#volumes-from", "-volumes-from"}, "Mount volumes from the specified container(s)")
 	cmd.Var(&flLxcOpts, []string{"#lxc-conf", "-lxc-conf"}, "Add custom lxc options -lxc-conf=\"lxc.cgroup.cpuset.cpus = 0,1\"")
+	cmd.Var(&flLogOpts, []string{"-log-opt"}, "Add an option of the log driver (e.g. --log-opt max-size=10m)")
 
 	if err := cmd.Parse(args); err != nil {
 		return nil, nil, cmd, err
@@ -1818,6 +2235,14 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		return nil, nil, cmd, ErrConflictDetachAutoRemove
 	}
 
+	restartPolicy, err := parseRestartPolicy(*flRestartPolicy)
+	if err != nil {
+		return nil, nil, cmd, err
+	}
+	if *flAutoRemove && restartPolicy.Name != "" && restartPolicy.Name != "no" {
+		return nil, nil, cmd, ErrConflictRestartAutoRemove
+	}
+
 	// If neither -d or -a are set, attach to everything by default
 	if flAttach.Len() == 0 && !*flDetach {
 		if !*flDetach {
@@ -1875,6 +2300,11 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		return nil, nil, cmd, err
 	}
 
+	logConfig, err := parseLogConfig(*flLogDriver, flLogOpts)
+	if err != nil {
+		return nil, nil, cmd, err
+	}
+
 	var (
 		domainname string
 		hostname   = *flHostname
@@ -1933,6 +2363,9 @@ func parseRun(cmd *flag.FlagSet, args []string, sysInfo *sysinfo.SysInfo) (*Conf
 		PortBindings:    portBindings,
 		Links:           flLinks.GetAll(),
 		PublishAllPorts: *flPublishAll,
+		RestartPolicy:   restartPolicy,
+		LogConfig:       logConfig,
+		VolumeDriver:    *flVolumeDriver,
 	}
 
 	if sysInfo != nil && flMemory > 0 && !sysInfo.SwapLimit {
@@ -2003,8 +2436,8 @@ func (cli *DockerCli) CmdRun(args ...string) error {
 		v.Set("fromImage", repos)
 		v.Set("tag", tag)
 
-		// Resolve the Repository name from fqn to endpoint + name
-		endpoint, _, err := registry.ResolveRepositoryName(repos)
+		// Resolve the address of the registry of the repository
+		endpoint, err := registry.RegistryAddress(repos)
 		if err != nil {
 			return err
 		}
@@ -2258,6 +2691,39 @@ func (cli *DockerCli) CmdLoad(args ...string) error {
 	return nil
 }
 
+// tlsClientConn is a TLS connection to the daemon, which keeps its tcp
+// connection to half-close it
+type tlsClientConn struct {
+	*tls.Conn
+	tcpc *net.TCPConn
+}
+
+// CloseWrite tells the daemon that the input is over, while it may still
+// send the output
+func (c *tlsClientConn) CloseWrite() error {
+	return c.tcpc.CloseWrite()
+}
+
+// dial connects to the daemon, with TLS over tcp when the client has a TLS
+// configuration
+func (cli *DockerCli) dial() (net.Conn, error) {
+	conn, err := net.Dial(cli.proto, cli.addr)
+	if err != nil || cli.tlsConfig == nil || cli.proto == "unix" {
+		return conn, err
+	}
+	tcpc, ok := conn.(*net.TCPConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("Can't use TLS over %s", cli.proto)
+	}
+	tlsc := tls.Client(tcpc, cli.tlsConfig)
+	if err := tlsc.Handshake(); err != nil {
+		tcpc.Close()
+		return nil, err
+	}
+	return &tlsClientConn{Conn: tlsc, tcpc: tcpc}, nil
+}
+
 func (cli *DockerCli) call(method, path string, data interface{}, passAuthInfo bool) (io.ReadCloser, int, error) {
 	params := bytes.NewBuffer(nil)
 	if data != nil {
@@ -2310,7 +2776,7 @@ func (cli *DockerCli) call(method, path string, data interface{}, passAuthInfo b
 	} else if method == "POST" {
 		req.Header.Set("Content-Type", "plain/text")
 	}
-	dial, err := net.Dial(cli.proto, cli.addr)
+	dial, err := cli.dial()
 	if err != nil {
 		if strings.Contains(err.Error(), "connection refused") {
 			return nil, -1, ErrConnectionRefused
@@ -2372,7 +2838,7 @@ func (cli *DockerCli) stream(method, path string, in io.Reader, out io.Writer, h
 		}
 	}
 
-	dial, err := net.Dial(cli.proto, cli.addr)
+	dial, err := cli.dial()
 	if err != nil {
 		if strings.Contains(err.Error(), "connection refused") {
 			return fmt.Errorf("Can't connect to docker daemon. Is 'docker -d' running on this host?")
@@ -2428,7 +2894,7 @@ func (cli *DockerCli) hijack(method, path string, setRawTerminal bool, in io.Rea
 	req.Header.Set("Content-Type", "plain/text")
 	req.Host = cli.addr
 
-	dial, err := net.Dial(cli.proto, cli.addr)
+	dial, err := cli.dial()
 	if err != nil {
 		if strings.Contains(err.Error(), "connection refused") {
 			return fmt.Errorf("Can't connect to docker daemon. Is 'docker -d' running on this host?")
@@ -2495,6 +2961,10 @@ func (cli *DockerCli) hijack(method, path string, setRawTerminal bool, in io.Rea
 			if err := unixc.CloseWrite(); err != nil {
 				utils.Errorf("Couldn't send EOF: %s\n", err)
 			}
+		} else if tlsc, ok := rwc.(*tlsClientConn); ok {
+			if err := tlsc.CloseWrite(); err != nil {
+				utils.Errorf("Couldn't send EOF: %s\n", err)
+			}
 		}
 		// Discard errors due to pipe interruption
 		return nil
@@ -2620,7 +3090,7 @@ func readBody(stream io.ReadCloser, statusCode int, err error) ([]byte, int, err
 	return body, statusCode, nil
 }
 
-func NewDockerCli(in io.ReadCloser, out, err io.Writer, proto, addr string) *DockerCli {
+func NewDockerCli(in io.ReadCloser, out, err io.Writer, proto, addr string, tlsConfig *tls.Config) *DockerCli {
 	var (
 		isTerminal = false
 		terminalFd uintptr
@@ -2636,9 +3106,17 @@ func NewDockerCli(in io.ReadCloser, out, err io.Writer, proto, addr string) *Doc
 	if err == nil {
 		err = out
 	}
+	// The connections are made with tls.Client, which doesn't know the
+	// name of the daemon to verify its certificate
+	if tlsConfig != nil && tlsConfig.ServerName == "" && proto != "unix" {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			tlsConfig.ServerName = host
+		}
+	}
 	return &DockerCli{
 		proto:      proto,
 		addr:       addr,
+		tlsConfig:  tlsConfig,
 		in:         in,
 		out:        out,
 		err:        err,
@@ -2650,6 +3128,7 @@ func NewDockerCli(in io.ReadCloser, out, err io.Writer, proto, addr string) *Doc
 type DockerCli struct {
 	proto      string
 	addr       string
+	tlsConfig  *tls.Config
 	configFile *auth.ConfigFile
 	in         io.ReadCloser
 	out        io.Writer
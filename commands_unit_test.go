@@ -1,6 +1,11 @@
 package docker
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 )
@@ -122,6 +127,12 @@ func TestParseRunVolumes(t *testing.T) {
 		t.Fatalf("Error parsing volume flags, `-v /containerVar` is missing from volumes. Received %v", config.Volumes)
 	}
 
+	if config, hostConfig := mustParse(t, "-v data:/containerData:ro"); hostConfig.Binds == nil || hostConfig.Binds[0] != "data:/containerData:ro" {
+		t.Fatalf("Error parsing volume flags, `-v data:/containerData:ro` should mount the volume data into /containerData. Received %v", hostConfig.Binds)
+	} else if _, exists := config.Volumes["/containerData"]; !exists {
+		t.Fatalf("Error parsing volume flags, `-v /containerData` is missing from volumes. Received %v", config.Volumes)
+	}
+
 	if config, hostConfig := mustParse(t, ""); hostConfig.Binds != nil {
 		t.Fatalf("Error parsing volume flags, without volume, nothing should be mount-binded. Received %v", hostConfig.Binds)
 	} else if len(config.Volumes) != 0 {
@@ -157,3 +168,96 @@ func TestParseRunVolumes(t *testing.T) {
 		t.Fatalf("Error parsing volume flags, `-v /tmp:/tmp:/tmp:/tmp` should fail but didn't")
 	}
 }
+
+func TestParseRunRestartPolicy(t *testing.T) {
+	if _, hostConfig := mustParse(t, ""); hostConfig.RestartPolicy.Name != "" {
+		t.Fatalf("Error parsing restart policy. No policy expected, received: %v", hostConfig.RestartPolicy)
+	}
+	if _, hostConfig := mustParse(t, "--restart=always"); hostConfig.RestartPolicy.Name != "always" {
+		t.Fatalf("Error parsing restart policy. Expected always, received: %v", hostConfig.RestartPolicy)
+	}
+	if _, hostConfig := mustParse(t, "--restart=on-failure"); hostConfig.RestartPolicy.Name != "on-failure" || hostConfig.RestartPolicy.MaximumRetryCount != 0 {
+		t.Fatalf("Error parsing restart policy. Expected on-failure without limit, received: %v", hostConfig.RestartPolicy)
+	}
+	if _, hostConfig := mustParse(t, "--restart=on-failure:5"); hostConfig.RestartPolicy.Name != "on-failure" || hostConfig.RestartPolicy.MaximumRetryCount != 5 {
+		t.Fatalf("Error parsing restart policy. Expected on-failure with 5 retries, received: %v", hostConfig.RestartPolicy)
+	}
+
+	for _, args := range []string{"--restart=sometimes", "--restart=always:3", "--restart=on-failure:x", "--restart=on-failure:-1", "--rm --restart=always"} {
+		if _, _, err := parse(t, args); err == nil {
+			t.Fatalf("Error parsing restart policy. `%s` should be an error but is not", args)
+		}
+	}
+}
+
+func TestParseRunLogConfig(t *testing.T) {
+	if _, hostConfig := mustParse(t, ""); hostConfig.LogConfig.Type != "" || hostConfig.LogConfig.Config != nil {
+		t.Fatalf("Error parsing log config. Nothing expected, received: %v", hostConfig.LogConfig)
+	}
+	_, hostConfig := mustParse(t, "--log-driver=syslog --log-opt syslog-facility=local0 --log-opt syslog-tag=a=b")
+	if hostConfig.LogConfig.Type != "syslog" {
+		t.Fatalf("Error parsing log config. Expected syslog, received: %v", hostConfig.LogConfig)
+	}
+	if hostConfig.LogConfig.Config["syslog-facility"] != "local0" || hostConfig.LogConfig.Config["syslog-tag"] != "a=b" {
+		t.Fatalf("Error parsing log config. Unexpected options: %v", hostConfig.LogConfig.Config)
+	}
+
+	for _, args := range []string{"--log-opt max-size", "--log-opt =10m", "--log-driver=none --log-opt max-size=10m"} {
+		if _, _, err := parse(t, args); err == nil {
+			t.Fatalf("Error parsing log config. `%s` should be an error but is not", args)
+		}
+	}
+}
+
+func TestDialTLSCloseWrite(t *testing.T) {
+	// Borrow the certificate of the test servers
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	server.Close()
+	cert := server.TLS.Certificates[0]
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// Answer once the client is done sending
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		in, _ := ioutil.ReadAll(c)
+		c.Write(append([]byte("got "), in...))
+	}()
+
+	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(x509Cert)
+	cli := NewDockerCli(nil, ioutil.Discard, nil, "tcp", l.Addr().String(), &tls.Config{RootCAs: rootCAs})
+	conn, err := cli.dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("input")); err != nil {
+		t.Fatal(err)
+	}
+	tlsc, ok := conn.(*tlsClientConn)
+	if !ok {
+		t.Fatalf("Expected a TLS connection, got %T", conn)
+	}
+	if err := tlsc.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "got input" {
+		t.Fatalf("Expected the daemon to answer once the input is over, got %q", out)
+	}
+}
@@ -8,8 +8,10 @@ import (
 )
 
 const (
-	defaultNetworkMtu    = 1500
-	DisableNetworkBridge = "none"
+	defaultNetworkMtu             = 1500
+	DisableNetworkBridge          = "none"
+	DefaultMaxConcurrentDownloads = 3
+	DefaultMaxConcurrentUploads   = 5
 )
 
 // FIXME: separate runtime configuration from http api configuration
@@ -25,8 +27,15 @@ type DaemonConfig struct {
 	BridgeIP                    string
 	InterContainerCommunication bool
 	GraphDriver                 string
+	GraphOptions                []string
+	ExecDriver                  string
+	LogDriver                   string
 	Mtu                         int
 	DisableNetwork              bool
+	Mirrors                     []string
+	InsecureRegistries          []string
+	MaxConcurrentDownloads      int
+	MaxConcurrentUploads        int
 }
 
 // ConfigFromJob creates and returns a new DaemonConfig object
@@ -43,15 +52,36 @@ func DaemonConfigFromJob(job *engine.Job) *DaemonConfig {
 		DefaultIp:                   net.ParseIP(job.Getenv("DefaultIp")),
 		InterContainerCommunication: job.GetenvBool("InterContainerCommunication"),
 		GraphDriver:                 job.Getenv("GraphDriver"),
+		ExecDriver:                  job.Getenv("ExecDriver"),
+		LogDriver:                   job.Getenv("LogDriver"),
+	}
+	if options := job.GetenvList("GraphOptions"); options != nil {
+		config.GraphOptions = options
 	}
 	if dns := job.GetenvList("Dns"); dns != nil {
 		config.Dns = dns
 	}
+	if mirrors := job.GetenvList("Mirrors"); mirrors != nil {
+		config.Mirrors = mirrors
+	}
+	if insecure := job.GetenvList("InsecureRegistries"); insecure != nil {
+		config.InsecureRegistries = insecure
+	}
 	if mtu := job.GetenvInt("Mtu"); mtu != 0 {
 		config.Mtu = mtu
 	} else {
 		config.Mtu = GetDefaultNetworkMtu()
 	}
+	if n := job.GetenvInt("MaxConcurrentDownloads"); n > 0 {
+		config.MaxConcurrentDownloads = n
+	} else {
+		config.MaxConcurrentDownloads = DefaultMaxConcurrentDownloads
+	}
+	if n := job.GetenvInt("MaxConcurrentUploads"); n > 0 {
+		config.MaxConcurrentUploads = n
+	} else {
+		config.MaxConcurrentUploads = DefaultMaxConcurrentUploads
+	}
 	config.DisableNetwork = config.BridgeIface == DisableNetworkBridge
 
 	return config
@@ -8,6 +8,8 @@ import (
 	"github.com/dotcloud/docker/engine"
 	"github.com/dotcloud/docker/execdriver"
 	"github.com/dotcloud/docker/graphdriver"
+	"github.com/dotcloud/docker/logger"
+	"github.com/dotcloud/docker/logger/jsonfilelog"
 	"github.com/dotcloud/docker/pkg/mount"
 	"github.com/dotcloud/docker/pkg/term"
 	"github.com/dotcloud/docker/utils"
@@ -52,6 +54,7 @@ type Container struct {
 	HostsPath      string
 	Name           string
 	Driver         string
+	RestartCount   int // Number of times the restart policy restarted the container
 
 	command   *execdriver.Command
 	stdout    *utils.WriteBroadcaster
@@ -69,7 +72,12 @@ type Container struct {
 	VolumesRW  map[string]bool
 	hostConfig *HostConfig
 
-	activeLinks map[string]*Link
+	activeLinks    map[string]*Link
+	logDriver      logger.Logger // nil with the "none" log driver
+	mountedVolumes []*Volume     // Unmounted when the container stops
+
+	stopRequested bool          // Stop or Kill was called, don't apply the restart policy
+	restartDelay  time.Duration // Delay before the next restart by the restart policy
 }
 
 // Note: the Config structure should hold only portable information about the container.
@@ -150,6 +158,22 @@ type HostConfig struct {
 	PortBindings    map[Port][]PortBinding
 	Links           []string
 	PublishAllPorts bool
+	RestartPolicy   RestartPolicy
+	LogConfig       LogConfig
+	VolumeDriver    string // Driver of the volumes created for the container
+}
+
+// RestartPolicy tells the daemon what to do when the process of a
+// container exits on its own
+type RestartPolicy struct {
+	Name              string // "no", "always" or "on-failure"
+	MaximumRetryCount int    // Only for "on-failure". 0 means no limit
+}
+
+// LogConfig selects where the output of a container is sent
+type LogConfig struct {
+	Type   string            // The log driver, the one of the daemon if empty
+	Config map[string]string // Options of the log driver
 }
 
 func ContainerHostConfigFromJob(job *engine.Job) *HostConfig {
@@ -157,9 +181,12 @@ func ContainerHostConfigFromJob(job *engine.Job) *HostConfig {
 		ContainerIDFile: job.Getenv("ContainerIDFile"),
 		Privileged:      job.GetenvBool("Privileged"),
 		PublishAllPorts: job.GetenvBool("PublishAllPorts"),
+		VolumeDriver:    job.Getenv("VolumeDriver"),
 	}
 	job.GetenvJson("LxcConf", &hostConfig.LxcConf)
 	job.GetenvJson("PortBindings", &hostConfig.PortBindings)
+	job.GetenvJson("RestartPolicy", &hostConfig.RestartPolicy)
+	job.GetenvJson("LogConfig", &hostConfig.LogConfig)
 	if Binds := job.GetenvList("Binds"); Binds != nil {
 		hostConfig.Binds = Binds
 	}
@@ -177,11 +204,12 @@ type BindMap struct {
 }
 
 var (
-	ErrContainerStart           = errors.New("The container failed to start. Unknown error")
-	ErrContainerStartTimeout    = errors.New("The container failed to start due to timed out.")
-	ErrInvalidWorikingDirectory = errors.New("The working directory is invalid. It needs to be an absolute path.")
-	ErrConflictAttachDetach     = errors.New("Conflicting options: -a and -d")
-	ErrConflictDetachAutoRemove = errors.New("Conflicting options: -rm and -d")
+	ErrContainerStart            = errors.New("The container failed to start. Unknown error")
+	ErrContainerStartTimeout     = errors.New("The container failed to start due to timed out.")
+	ErrInvalidWorikingDirectory  = errors.New("The working directory is invalid. It needs to be an absolute path.")
+	ErrConflictAttachDetach      = errors.New("Conflicting options: -a and -d")
+	ErrConflictDetachAutoRemove  = errors.New("Conflicting options: -rm and -d")
+	ErrConflictRestartAutoRemove = errors.New("Conflicting options: -rm and -restart")
 )
 
 type KeyValuePair struct {
@@ -602,6 +630,7 @@ func (container *Container) Start() (err error) {
 	if container.State.IsRunning() {
 		return fmt.Errorf("The container %s is already running.", container.ID)
 	}
+	container.stopRequested = false
 
 	defer func() {
 		if err != nil {
@@ -647,6 +676,10 @@ func (container *Container) Start() (err error) {
 		return err
 	}
 
+	if err := container.mountVolumes(); err != nil {
+		return err
+	}
+
 	if err := container.createVolumes(); err != nil {
 		return err
 	}
@@ -779,11 +812,7 @@ func (container *Container) Start() (err error) {
 
 	populateCommand(container)
 
-	// Setup logging of stdout and stderr to disk
-	if err := container.runtime.LogToDisk(container.stdout, container.logPath("json"), "stdout"); err != nil {
-		return err
-	}
-	if err := container.runtime.LogToDisk(container.stderr, container.logPath("json"), "stderr"); err != nil {
+	if err := container.startLogging(); err != nil {
 		return err
 	}
 	container.waitLock = make(chan struct{})
@@ -873,7 +902,6 @@ func (container *Container) createVolumes() error {
 	if err != nil {
 		return err
 	}
-	volumesDriver := container.runtime.volumes.driver
 	// Create the requested volumes if they don't exist
 	for volPath := range container.Config.Volumes {
 		volPath = path.Clean(volPath)
@@ -886,7 +914,8 @@ func (container *Container) createVolumes() error {
 		var isBindMount bool
 		srcRW := false
 		// If an external bind is defined for this volume, use that as a source
-		if bindMap, exists := binds[volPath]; exists {
+		bindMap, exists := binds[volPath]
+		if exists && filepath.IsAbs(bindMap.SrcPath) {
 			isBindMount = true
 			srcPath = bindMap.SrcPath
 			if strings.ToLower(bindMap.Mode) == "rw" {
@@ -897,21 +926,25 @@ func (container *Container) createVolumes() error {
 			} else {
 				volIsDir = stat.IsDir()
 			}
-			// Otherwise create an directory in $ROOT/volumes/ and use that
+			// Otherwise use the named volume of the bind, created if needed,
+			// or create an anonymous one in $ROOT/volumes/
 		} else {
-
-			// Do not pass a container as the parameter for the volume creation.
-			// The graph driver using the container's information ( Image ) to
-			// create the parent.
-			c, err := container.runtime.volumes.Create(nil, nil, "", "", nil)
+			var name string
+			if exists {
+				name = bindMap.SrcPath
+				srcRW = strings.ToLower(bindMap.Mode) == "rw"
+			} else {
+				srcRW = true // RW by default
+			}
+			volume, err := container.runtime.volumes.GetOrCreate(name, container.hostConfig.VolumeDriver)
 			if err != nil {
 				return err
 			}
-			srcPath, err = volumesDriver.Get(c.ID)
-			if err != nil {
-				return fmt.Errorf("Driver %s failed to get volume rootfs %s: %s", volumesDriver, c.ID, err)
+			if srcPath, err = container.runtime.volumes.Mount(volume); err != nil {
+				return err
 			}
-			srcRW = true // RW by default
+			container.mountedVolumes = append(container.mountedVolumes, volume)
+			container.runtime.volumes.Ref(volume, container.ID)
 		}
 
 		if p, err := filepath.EvalSymlinks(srcPath); err != nil {
@@ -988,6 +1021,35 @@ func (container *Container) createVolumes() error {
 	return nil
 }
 
+// mountVolumes mounts the volumes which the container already has, from a
+// previous start or from other containers, as the drivers may have
+// unmounted them since
+func (container *Container) mountVolumes() error {
+	for volPath, p := range container.Volumes {
+		volume := container.runtime.volumes.ByPath(p)
+		if volume == nil {
+			continue
+		}
+		mountpoint, err := container.runtime.volumes.Mount(volume)
+		if err != nil {
+			return err
+		}
+		container.mountedVolumes = append(container.mountedVolumes, volume)
+		// A driver may mount a volume somewhere else each time
+		container.Volumes[volPath] = mountpoint
+	}
+	return nil
+}
+
+func (container *Container) unmountVolumes() {
+	for _, volume := range container.mountedVolumes {
+		if err := container.runtime.volumes.Unmount(volume); err != nil {
+			utils.Errorf("%s: Error unmounting volume %s: %s", container.ID, volume.Name, err)
+		}
+	}
+	container.mountedVolumes = nil
+}
+
 func (container *Container) applyExternalVolumes() error {
 	if container.Config.VolumesFrom != "" {
 		containerSpecs := strings.Split(container.Config.VolumesFrom, ",")
@@ -1018,6 +1080,9 @@ func (container *Container) applyExternalVolumes() error {
 					return err
 				}
 				container.Volumes[volPath] = id
+				if volume := container.runtime.volumes.ByPath(id); volume != nil {
+					container.runtime.volumes.Ref(volume, container.ID)
+				}
 				if isRW, exists := c.VolumesRW[volPath]; exists {
 					container.VolumesRW[volPath] = isRW && mountRW
 				}
@@ -1252,6 +1317,10 @@ func (container *Container) monitor(callback execdriver.StartCallback) error {
 		container.stdin, container.stdinPipe = io.Pipe()
 	}
 
+	// Decide before anyone waiting for the container gets a chance to act
+	// on its exit
+	restart := container.shouldRestart(exitCode)
+
 	container.State.SetStopped(exitCode)
 
 	if container.runtime != nil && container.runtime.srv != nil {
@@ -1269,9 +1338,55 @@ func (container *Container) monitor(callback execdriver.StartCallback) error {
 	//log.Printf("%s: Failed to dump configuration to the disk: %s", container.ID, err)
 	container.ToDisk()
 
+	if restart {
+		go container.restartAfterDelay()
+	}
+
 	return err
 }
 
+// shouldRestart applies the restart policy of the container to the exit
+// of its process
+func (container *Container) shouldRestart(exitCode int) bool {
+	if container.stopRequested || container.hostConfig == nil || container.runtime == nil {
+		return false
+	}
+	policy := container.hostConfig.RestartPolicy
+	switch policy.Name {
+	case "always":
+		return true
+	case "on-failure":
+		return exitCode != 0 && (policy.MaximumRetryCount == 0 || container.RestartCount < policy.MaximumRetryCount)
+	}
+	return false
+}
+
+// restartAfterDelay starts the container again once the restart delay has
+// elapsed. The delay doubles every time the container exits soon after
+// being started, so that one which keeps crashing doesn't hog the host.
+func (container *Container) restartAfterDelay() {
+	if container.restartDelay == 0 || container.State.FinishedAt.Sub(container.State.StartedAt) > 10*time.Second {
+		container.restartDelay = 100 * time.Millisecond
+	} else if container.restartDelay *= 2; container.restartDelay > time.Minute {
+		container.restartDelay = time.Minute
+	}
+	utils.Debugf("%s: Restarting in %s", container.ID, container.restartDelay)
+	time.Sleep(container.restartDelay)
+
+	// The container might have been stopped, started or removed since
+	if container.stopRequested || container.State.IsRunning() || container.runtime.Get(container.ID) == nil {
+		return
+	}
+	container.RestartCount++
+	if err := container.Start(); err != nil {
+		utils.Errorf("%s: Failed to restart: %s", container.ID, err)
+		return
+	}
+	if container.runtime.srv != nil {
+		container.runtime.srv.LogEvent("restart", container.ID, container.runtime.repositories.ImageName(container.Image))
+	}
+}
+
 func (container *Container) cleanup() {
 	container.releaseNetwork()
 
@@ -1293,6 +1408,12 @@ func (container *Container) cleanup() {
 	if err := container.stderr.CloseWriters(); err != nil {
 		utils.Errorf("%s: Error close stderr: %s", container.ID, err)
 	}
+	if container.logDriver != nil {
+		if err := container.logDriver.Close(); err != nil {
+			utils.Errorf("%s: Error closing the log driver: %s", container.ID, err)
+		}
+		container.logDriver = nil
+	}
 
 	if container.ptyMaster != nil {
 		if err := container.ptyMaster.Close(); err != nil {
@@ -1323,6 +1444,7 @@ func (container *Container) cleanup() {
 			log.Printf("Failed to umount %v: %v", mounts[i], lastError)
 		}
 	}
+	container.unmountVolumes()
 
 	if err := container.Unmount(); err != nil {
 		log.Printf("%v: Failed to umount filesystem: %v", container.ID, err)
@@ -1340,6 +1462,7 @@ func (container *Container) kill(sig int) error {
 }
 
 func (container *Container) Kill() error {
+	container.stopRequested = true
 	if !container.State.IsRunning() {
 		return nil
 	}
@@ -1348,6 +1471,10 @@ func (container *Container) Kill() error {
 	if err := container.kill(9); err != nil {
 		return err
 	}
+	// Frozen processes only receive the signal once they are thawed
+	if err := container.unpauseAfterSignal(); err != nil {
+		return err
+	}
 
 	// 2. Wait for the process to die, in last resort, try to kill the process directly
 	if err := container.WaitTimeout(10 * time.Second); err != nil {
@@ -1365,6 +1492,7 @@ func (container *Container) Kill() error {
 }
 
 func (container *Container) Stop(seconds int) error {
+	container.stopRequested = true
 	if !container.State.IsRunning() {
 		return nil
 	}
@@ -1377,6 +1505,9 @@ func (container *Container) Stop(seconds int) error {
 			return err
 		}
 	}
+	if err := container.unpauseAfterSignal(); err != nil {
+		return err
+	}
 
 	// 2. Wait for the process to exit on its own
 	if err := container.WaitTimeout(time.Duration(seconds) * time.Second); err != nil {
@@ -1389,6 +1520,49 @@ func (container *Container) Stop(seconds int) error {
 	return nil
 }
 
+// Pause freezes every process of the container. They stay in memory and
+// resume where they left off on Unpause.
+func (container *Container) Pause() error {
+	container.Lock()
+	defer container.Unlock()
+
+	if !container.State.IsRunning() || container.command == nil {
+		return fmt.Errorf("Container %s is not running", container.ID)
+	}
+	if container.State.IsPaused() {
+		return fmt.Errorf("Container %s is already paused", container.ID)
+	}
+	if err := container.runtime.Pause(container); err != nil {
+		return err
+	}
+	container.State.SetPaused()
+	return container.ToDisk()
+}
+
+func (container *Container) Unpause() error {
+	container.Lock()
+	defer container.Unlock()
+
+	if !container.State.IsRunning() || container.command == nil {
+		return fmt.Errorf("Container %s is not running", container.ID)
+	}
+	if !container.State.IsPaused() {
+		return fmt.Errorf("Container %s is not paused", container.ID)
+	}
+	if err := container.runtime.Unpause(container); err != nil {
+		return err
+	}
+	container.State.SetUnpaused()
+	return container.ToDisk()
+}
+
+func (container *Container) unpauseAfterSignal() error {
+	if !container.State.IsPaused() {
+		return nil
+	}
+	return container.Unpause()
+}
+
 func (container *Container) Restart(seconds int) error {
 	if err := container.Stop(seconds); err != nil {
 		return err
@@ -1396,6 +1570,97 @@ func (container *Container) Restart(seconds int) error {
 	return container.Start()
 }
 
+// Exec runs cmd inside the namespaces and cgroups of the running container
+// and blocks until it exits. The streams of the process are connected to
+// stdin, stdout and stderr, any of which may be nil.
+func (container *Container) Exec(cmd []string, user string, tty bool, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	container.Lock()
+	if !container.State.IsRunning() || container.command == nil {
+		container.Unlock()
+		return -1, fmt.Errorf("Container %s is not running", container.ID)
+	}
+	if container.State.IsPaused() {
+		container.Unlock()
+		return -1, fmt.Errorf("Container %s is paused, unpause it first", container.ID)
+	}
+	container.Unlock()
+
+	if len(cmd) == 0 {
+		return -1, fmt.Errorf("No command specified")
+	}
+	if user == "" {
+		user = container.Config.User
+	}
+
+	process := &execdriver.Process{
+		User:       user,
+		Privileged: container.hostConfig.Privileged,
+		Entrypoint: cmd[0],
+		Arguments:  cmd[1:],
+		WorkingDir: container.Config.WorkingDir,
+		Tty:        tty,
+	}
+	process.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	var (
+		copyDone = make(chan struct{})
+		ptySlave *os.File
+	)
+	if tty {
+		ptyMaster, slave, err := pty.Open()
+		if err != nil {
+			return -1, err
+		}
+		defer ptyMaster.Close()
+		ptySlave = slave
+		process.Stdin = ptySlave
+		process.Stdout = ptySlave
+		process.Stderr = ptySlave
+		process.SysProcAttr.Setctty = true
+
+		if stdout == nil {
+			stdout = ioutil.Discard
+		}
+		go func() {
+			defer close(copyDone)
+			io.Copy(stdout, ptyMaster)
+		}()
+		if stdin != nil {
+			go io.Copy(ptyMaster, stdin)
+		}
+	} else {
+		process.Stdout = stdout
+		process.Stderr = stderr
+		if stdin != nil {
+			// Copy stdin ourselves rather than handing it to exec.Cmd,
+			// which would wait for it to be closed before returning
+			pipe, err := process.StdinPipe()
+			if err != nil {
+				return -1, err
+			}
+			go func() {
+				defer pipe.Close()
+				io.Copy(pipe, stdin)
+			}()
+		}
+		close(copyDone)
+	}
+
+	exitCode, err := container.runtime.Exec(container, process, func(p *execdriver.Process) {
+		// The process holds its own reference to the slave. Once it is
+		// gone, reads from the master fail and the output copy ends.
+		if ptySlave != nil {
+			ptySlave.Close()
+		}
+	})
+	if ptySlave != nil {
+		// The process might not have been started at all
+		ptySlave.Close()
+	}
+	<-copyDone
+	return exitCode, err
+}
+
 // Wait blocks until the container stops running, then returns its exit code.
 func (container *Container) Wait() int {
 	<-container.waitLock
@@ -1472,6 +1737,43 @@ func (container *Container) Unmount() error {
 	return container.runtime.Unmount(container)
 }
 
+// logDriverName returns the log driver the container uses, which is the
+// one of the daemon unless the container was created with its own
+func (container *Container) logDriverName() string {
+	if container.hostConfig != nil && container.hostConfig.LogConfig.Type != "" {
+		return container.hostConfig.LogConfig.Type
+	}
+	if container.runtime.config.LogDriver != "" {
+		return container.runtime.config.LogDriver
+	}
+	return jsonfilelog.Name
+}
+
+// startLogging sends stdout and stderr to the log driver of the container
+func (container *Container) startLogging() error {
+	name := container.logDriverName()
+	if name == logger.NoneDriver {
+		return nil
+	}
+	var config map[string]string
+	if container.hostConfig != nil {
+		config = container.hostConfig.LogConfig.Config
+	}
+	l, err := logger.New(name, logger.Context{
+		Config:        config,
+		ContainerID:   container.ID,
+		ContainerName: container.Name,
+		LogPath:       container.logPath("json"),
+	})
+	if err != nil {
+		return err
+	}
+	container.logDriver = l
+	container.stdout.AddWriter(logger.NewWriter(l, "stdout"), "")
+	container.stderr.AddWriter(logger.NewWriter(l, "stderr"), "")
+	return nil
+}
+
 func (container *Container) logPath(name string) string {
 	return path.Join(container.root, fmt.Sprintf("%s-%s.log", container.ID, name))
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"strings"
 
 	"github.com/dotcloud/docker"
@@ -19,8 +23,12 @@ var (
 	VERSION   string
 )
 
+var dockerConfDir = path.Join(os.Getenv("HOME"), ".docker")
+
 func main() {
-	if selfPath := utils.SelfPath(); selfPath == "/sbin/init" || selfPath == "/.dockerinit" {
+	// The native exec driver starts dockerinit from the host, before it
+	// pivots into the container's rootfs
+	if selfPath := utils.SelfPath(); selfPath == "/sbin/init" || strings.HasSuffix(selfPath, "/.dockerinit") {
 		// Running in init mode
 		sysinit.SysInit()
 		return
@@ -42,11 +50,29 @@ func main() {
 		flDefaultIp          = flag.String([]string{"#ip", "-ip"}, "0.0.0.0", "Default IP address to use when binding container ports")
 		flInterContainerComm = flag.Bool([]string{"#icc", "-icc"}, true, "Enable inter-container communication")
 		flGraphDriver        = flag.String([]string{"s", "-storage-driver"}, "", "Force the docker runtime to use a specific storage driver")
+		flGraphOptions       = docker.NewListOpts(nil)
 		flHosts              = docker.NewListOpts(docker.ValidateHost)
+		flMirrors            = docker.NewListOpts(docker.ValidateMirror)
+		flInsecureRegistries = docker.NewListOpts(docker.ValidateInsecureRegistry)
+		flMaxDownloads       = flag.Int([]string{"-max-concurrent-downloads"}, docker.DefaultMaxConcurrentDownloads, "Number of layers the daemon downloads at the same time")
+		flMaxUploads         = flag.Int([]string{"-max-concurrent-uploads"}, docker.DefaultMaxConcurrentUploads, "Number of layers the daemon uploads at the same time")
 		flMtu                = flag.Int([]string{"#mtu", "-mtu"}, 0, "Set the containers network MTU; if no value is provided: default to the default route MTU or 1500 if not default route is available")
+		flExecDriver         = flag.String([]string{"e", "-exec-driver"}, "lxc", "Force the docker runtime to use a specific exec driver (lxc or native)")
+		flLogDriver          = flag.String([]string{"-log-driver"}, "json-file", "Default driver for the output of containers (json-file, syslog or none)")
+		flTls                = flag.Bool([]string{"-tls"}, false, "Use TLS over tcp; implied by --tlsverify")
+		flTlsVerify          = flag.Bool([]string{"-tlsverify"}, false, "Use TLS and verify the remote (daemon: verify the client certificates, client: verify the daemon certificate)")
+		flCa                 = flag.String([]string{"-tlscacert"}, path.Join(dockerConfDir, "ca.pem"), "Trust only remotes providing a certificate signed by this CA")
+		flCert               = flag.String([]string{"-tlscert"}, path.Join(dockerConfDir, "cert.pem"), "Path to the TLS certificate file")
+		flKey                = flag.String([]string{"-tlskey"}, path.Join(dockerConfDir, "key.pem"), "Path to the TLS key file")
+		flAuthzPlugin        = flag.String([]string{"-authz-plugin"}, "", "Allow or deny each API request with this authorization plugin, a name or the path of its unix socket")
+		flRegistryAddr       = flag.String([]string{"-registry-addr"}, "", "Serve a registry on this tcp address, such as 127.0.0.1:5000")
+		flRegistryRoot       = flag.String([]string{"-registry-root"}, "", "Store the images pushed to the registry in this directory; if empty, serve the images of the daemon read-only")
 	)
 	flag.Var(&flDns, []string{"#dns", "-dns"}, "Force docker to use specific DNS servers")
+	flag.Var(&flGraphOptions, []string{"-storage-opt"}, "Set storage driver options, as driver.key=value")
 	flag.Var(&flHosts, []string{"H", "-host"}, "tcp://host:port, unix://path/to/socket, fd://* or fd://socketfd to use in daemon mode. Multiple sockets can be specified")
+	flag.Var(&flMirrors, []string{"-registry-mirror"}, "Pull the images of the index from this mirror first, such as https://mirror.local. Multiple mirrors can be specified")
+	flag.Var(&flInsecureRegistries, []string{"-insecure-registry"}, "Allow this registry, a host[:port], to be reached over http or without verifying its certificate. Multiple registries can be specified")
 
 	flag.Parse()
 
@@ -96,15 +122,35 @@ func main() {
 		job.Setenv("DefaultIp", *flDefaultIp)
 		job.SetenvBool("InterContainerCommunication", *flInterContainerComm)
 		job.Setenv("GraphDriver", *flGraphDriver)
+		job.SetenvList("GraphOptions", flGraphOptions.GetAll())
 		job.SetenvInt("Mtu", *flMtu)
+		job.Setenv("ExecDriver", *flExecDriver)
+		job.Setenv("LogDriver", *flLogDriver)
+		job.SetenvList("Mirrors", flMirrors.GetAll())
+		job.SetenvList("InsecureRegistries", flInsecureRegistries.GetAll())
+		job.SetenvInt("MaxConcurrentDownloads", *flMaxDownloads)
+		job.SetenvInt("MaxConcurrentUploads", *flMaxUploads)
 		if err := job.Run(); err != nil {
 			log.Fatal(err)
 		}
+		if *flRegistryAddr != "" {
+			job = eng.Job("serveregistry", *flRegistryAddr)
+			job.Setenv("Root", *flRegistryRoot)
+			if err := job.Run(); err != nil {
+				log.Fatal(err)
+			}
+		}
 		// Serve api
 		job = eng.Job("serveapi", flHosts.GetAll()...)
 		job.SetenvBool("Logging", true)
 		job.SetenvBool("EnableCors", *flEnableCors)
 		job.Setenv("Version", VERSION)
+		job.SetenvBool("Tls", *flTls)
+		job.SetenvBool("TlsVerify", *flTlsVerify)
+		job.Setenv("TlsCa", *flCa)
+		job.Setenv("TlsCert", *flCert)
+		job.Setenv("TlsKey", *flKey)
+		job.Setenv("AuthzPlugin", *flAuthzPlugin)
 		if err := job.Run(); err != nil {
 			log.Fatal(err)
 		}
@@ -112,8 +158,15 @@ func main() {
 		if flHosts.Len() > 1 {
 			log.Fatal("Please specify only one -H")
 		}
+		var tlsConfig *tls.Config
+		if *flTls || *flTlsVerify {
+			var err error
+			if tlsConfig, err = newClientTLSConfig(*flCert, *flKey, *flCa, *flTlsVerify); err != nil {
+				log.Fatal(err)
+			}
+		}
 		protoAddrParts := strings.SplitN(flHosts.GetAll()[0], "://", 2)
-		if err := docker.ParseCommands(protoAddrParts[0], protoAddrParts[1], flag.Args()...); err != nil {
+		if err := docker.ParseCommands(protoAddrParts[0], protoAddrParts[1], tlsConfig, flag.Args()...); err != nil {
 			if sterr, ok := err.(*utils.StatusError); ok {
 				if sterr.Status != "" {
 					log.Println(sterr.Status)
@@ -128,3 +181,33 @@ func main() {
 func showVersion() {
 	fmt.Printf("Docker version %s, build %s\n", VERSION, GITCOMMIT)
 }
+
+// newClientTLSConfig returns the TLS configuration of the client. The
+// certificate is presented to the daemon when it exists, and the certificate
+// of the daemon must be signed by ca when verify is set.
+func newClientTLSConfig(cert, key, ca string, verify bool) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if _, err := os.Stat(cert); err == nil {
+		tlsCert, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't load the X509 key pair (%s, %s): %s", cert, key, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{tlsCert}
+	}
+	if !verify {
+		tlsConfig.InsecureSkipVerify = true
+		return tlsConfig, nil
+	}
+	pem, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read the CA certificate: %s", err)
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("No certificate found in %s", ca)
+	}
+	tlsConfig.RootCAs = certPool
+	return tlsConfig, nil
+}
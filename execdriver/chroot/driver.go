@@ -3,6 +3,7 @@ package chroot
 import (
 	"fmt"
 	"github.com/dotcloud/docker/execdriver"
+	"github.com/dotcloud/docker/pkg/cgroups"
 	"github.com/dotcloud/docker/pkg/mount"
 	"os"
 	"os/exec"
@@ -99,3 +100,19 @@ func (d *driver) Name() string {
 func (d *driver) GetPidsForContainer(id string) ([]int, error) {
 	return nil, fmt.Errorf("Not supported")
 }
+
+func (d *driver) GetStatsForContainer(id string) (*cgroups.Stats, error) {
+	return nil, execdriver.ErrNotSupported
+}
+
+func (d *driver) Exec(c *execdriver.Command, p *execdriver.Process, startCallback execdriver.ProcessCallback) (int, error) {
+	return -1, execdriver.ErrNotSupported
+}
+
+func (d *driver) Pause(c *execdriver.Command) error {
+	return execdriver.ErrNotSupported
+}
+
+func (d *driver) Unpause(c *execdriver.Command) error {
+	return execdriver.ErrNotSupported
+}
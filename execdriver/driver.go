@@ -2,6 +2,7 @@ package execdriver
 
 import (
 	"errors"
+	"github.com/dotcloud/docker/pkg/cgroups"
 	"os/exec"
 )
 
@@ -10,13 +11,15 @@ var (
 	ErrWaitTimeoutReached      = errors.New("Wait timeout reached")
 	ErrDriverAlreadyRegistered = errors.New("A driver already registered this docker init function")
 	ErrDriverNotFound          = errors.New("The requested docker init has not been found")
+	ErrNotSupported            = errors.New("Operation not supported by this driver")
 )
 
 var dockerInitFcts map[string]InitFunc
 
 type (
-	StartCallback func(*Command)
-	InitFunc      func(i *InitArgs) error
+	StartCallback   func(*Command)
+	ProcessCallback func(*Process)
+	InitFunc        func(i *InitArgs) error
 )
 
 func RegisterInitFunc(name string, fct InitFunc) error {
@@ -49,6 +52,7 @@ type InitArgs struct {
 	Args       []string
 	Mtu        int
 	Driver     string
+	Root       string
 }
 
 // Driver specific information based on
@@ -60,10 +64,14 @@ type Info interface {
 type Driver interface {
 	Run(c *Command, startCallback StartCallback) (int, error) // Run executes the process and blocks until the process exits and returns the exit code
 	Kill(c *Command, sig int) error
-	Restore(c *Command) error                     // Wait and try to re-attach on an out of process command
-	Name() string                                 // Driver name
-	Info(id string) Info                          // "temporary" hack (until we move state from core to plugins)
-	GetPidsForContainer(id string) ([]int, error) // Returns a list of pids for the given container.
+	Restore(c *Command) error                                                // Wait and try to re-attach on an out of process command
+	Name() string                                                            // Driver name
+	Info(id string) Info                                                     // "temporary" hack (until we move state from core to plugins)
+	GetPidsForContainer(id string) ([]int, error)                            // Returns a list of pids for the given container.
+	GetStatsForContainer(id string) (*cgroups.Stats, error)                  // Returns the resource usage of the given container.
+	Exec(c *Command, p *Process, startCallback ProcessCallback) (int, error) // Exec runs an additional process inside the running container c and blocks until it exits
+	Pause(c *Command) error                                                  // Pause freezes every process of the container
+	Unpause(c *Command) error                                                // Unpause resumes the processes of a paused container
 }
 
 // Network settings of the container
@@ -109,3 +117,25 @@ func (c *Command) Pid() int {
 	}
 	return c.Process.Pid
 }
+
+// Process is an additional process started inside an already
+// running container
+type Process struct {
+	exec.Cmd `json:"-"`
+
+	User       string   `json:"user"`
+	Privileged bool     `json:"privileged"`
+	Entrypoint string   `json:"entrypoint"`
+	Arguments  []string `json:"arguments"`
+	WorkingDir string   `json:"working_dir"`
+	Tty        bool     `json:"tty"`
+}
+
+// Return the pid of the process
+// If the process is nil -1 will be returned
+func (p *Process) Pid() int {
+	if p.Process == nil {
+		return -1
+	}
+	return p.Process.Pid
+}
@@ -17,7 +17,13 @@ import (
 	"time"
 )
 
-const DriverName = "lxc"
+const (
+	DriverName = "lxc"
+
+	// dockerinit is started under this name by Exec, from inside
+	// an already running container
+	execInitName = DriverName + "-exec"
+)
 
 func init() {
 	execdriver.RegisterInitFunc(DriverName, func(args *execdriver.InitArgs) error {
@@ -29,28 +35,38 @@ func init() {
 			return err
 		}
 
-		if err := setupCapabilities(args); err != nil {
-			return err
-		}
+		return startProcess(args)
+	})
+	execdriver.RegisterInitFunc(execInitName, func(args *execdriver.InitArgs) error {
+		// The hostname and the network of the container are already set up
+		return startProcess(args)
+	})
+}
 
-		if err := setupWorkingDirectory(args); err != nil {
-			return err
-		}
+// startProcess drops the privileges of dockerinit and executes the process
+// of the container
+func startProcess(args *execdriver.InitArgs) error {
+	if err := setupCapabilities(args); err != nil {
+		return err
+	}
 
-		if err := changeUser(args); err != nil {
-			return err
-		}
+	if err := setupWorkingDirectory(args); err != nil {
+		return err
+	}
 
-		path, err := exec.LookPath(args.Args[0])
-		if err != nil {
-			log.Printf("Unable to locate %v", args.Args[0])
-			os.Exit(127)
-		}
-		if err := syscall.Exec(path, args.Args, os.Environ()); err != nil {
-			return fmt.Errorf("dockerinit unable to execute %s - %s", path, err)
-		}
-		panic("Unreachable")
-	})
+	if err := changeUser(args); err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(args.Args[0])
+	if err != nil {
+		log.Printf("Unable to locate %v", args.Args[0])
+		os.Exit(127)
+	}
+	if err := syscall.Exec(path, args.Args, os.Environ()); err != nil {
+		return fmt.Errorf("dockerinit unable to execute %s - %s", path, err)
+	}
+	panic("Unreachable")
 }
 
 type driver struct {
@@ -176,6 +192,56 @@ func (d *driver) Run(c *execdriver.Command, startCallback execdriver.StartCallba
 	return getExitCode(c), waitErr
 }
 
+func (d *driver) Exec(c *execdriver.Command, p *execdriver.Process, startCallback execdriver.ProcessCallback) (int, error) {
+	// lxc-attach enters the namespaces and cgroups of the container,
+	// dockerinit then drops privileges like it does for the main process
+	params := []string{
+		"lxc-attach",
+		"-n", c.ID,
+		"--",
+		c.InitPath,
+		"-driver",
+		execInitName,
+	}
+
+	if p.User != "" {
+		params = append(params, "-u", p.User)
+	}
+
+	if p.Privileged {
+		params = append(params, "-privileged")
+	}
+
+	if p.WorkingDir != "" {
+		params = append(params, "-w", p.WorkingDir)
+	}
+
+	params = append(params, "--", p.Entrypoint)
+	params = append(params, p.Arguments...)
+
+	aname, err := exec.LookPath(params[0])
+	if err != nil {
+		return -1, err
+	}
+	p.Path = aname
+	p.Args = params
+
+	if err := p.Start(); err != nil {
+		return -1, err
+	}
+
+	if startCallback != nil {
+		startCallback(p)
+	}
+
+	if err := p.Wait(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok { // Do not propagate the error if it's simply a status code != 0
+			return -1, err
+		}
+	}
+	return p.ProcessState.Sys().(syscall.WaitStatus).ExitStatus(), nil
+}
+
 /// Return the exit code of the process
 // if the process has not exited -1 will be returned
 func getExitCode(c *execdriver.Command) int {
@@ -195,13 +261,34 @@ func (d *driver) Restore(c *execdriver.Command) error {
 		if err != nil {
 			return err
 		}
-		if !strings.Contains(string(output), "RUNNING") {
+		// A paused container is still alive
+		if !strings.Contains(string(output), "RUNNING") && !strings.Contains(string(output), "FROZEN") {
 			return nil
 		}
 		time.Sleep(500 * time.Millisecond)
 	}
 }
 
+func (d *driver) Pause(c *execdriver.Command) error {
+	return d.cgroup(c.ID).Freeze()
+}
+
+func (d *driver) Unpause(c *execdriver.Command) error {
+	return d.cgroup(c.ID).Thaw()
+}
+
+// cgroup returns the cgroup lxc placed the container in
+func (d *driver) cgroup(id string) *cgroups.Cgroup {
+	cgroup := &cgroups.Cgroup{Name: id}
+	if dir, err := cgroup.Path("freezer"); err == nil {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			// With more recent lxc versions use, cgroup will be in lxc/
+			cgroup.Parent = "lxc"
+		}
+	}
+	return cgroup
+}
+
 func (d *driver) version() string {
 	version := ""
 	if output, err := exec.Command("lxc-version").CombinedOutput(); err == nil {
@@ -333,6 +420,10 @@ func (d *driver) GetPidsForContainer(id string) ([]int, error) {
 	return pids, nil
 }
 
+func (d *driver) GetStatsForContainer(id string) (*cgroups.Stats, error) {
+	return d.cgroup(id).Stats()
+}
+
 func linkLxcStart(root string) error {
 	sourcePath, err := exec.LookPath("lxc-start")
 	if err != nil {
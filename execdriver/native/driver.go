@@ -0,0 +1,396 @@
+package native
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/execdriver"
+	"github.com/dotcloud/docker/pkg/cgroups"
+	"github.com/dotcloud/docker/pkg/netlink"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	DriverName = "native"
+	Version    = "0.1"
+
+	// dockerinit is started under this name by Exec, from inside
+	// the namespaces of an already running container
+	execInitName = DriverName + "-exec"
+)
+
+func init() {
+	execdriver.RegisterInitFunc(DriverName, func(args *execdriver.InitArgs) error {
+		return initContainer(args)
+	})
+	execdriver.RegisterInitFunc(execInitName, func(args *execdriver.InitArgs) error {
+		return enterContainer(args)
+	})
+}
+
+type driver struct {
+	root string // root path for the driver to use
+}
+
+func NewDriver(root string) (*driver, error) {
+	if err := checkNamespaceSupport(); err != nil {
+		return nil, err
+	}
+	return &driver{
+		root: root,
+	}, nil
+}
+
+func (d *driver) Name() string {
+	return fmt.Sprintf("%s-%s", DriverName, Version)
+}
+
+func (d *driver) Run(c *execdriver.Command, startCallback execdriver.StartCallback) (int, error) {
+	// dockerinit is started from the host and sets up the rootfs itself,
+	// so it has to be told where the rootfs lives
+	initPath := filepath.Join(c.Rootfs, c.InitPath)
+	params := []string{
+		initPath,
+		"-driver",
+		DriverName,
+		"-root",
+		c.Rootfs,
+	}
+
+	if c.Network != nil {
+		params = append(params,
+			"-g", c.Network.Gateway,
+			"-i", fmt.Sprintf("%s/%d", c.Network.IPAddress, c.Network.IPPrefixLen),
+			"-mtu", strconv.Itoa(c.Network.Mtu),
+		)
+	}
+
+	if c.User != "" {
+		params = append(params, "-u", c.User)
+	}
+
+	if c.Privileged {
+		params = append(params, "-privileged")
+	}
+
+	if c.WorkingDir != "" {
+		params = append(params, "-w", c.WorkingDir)
+	}
+
+	params = append(params, "--", c.Entrypoint)
+	params = append(params, c.Arguments...)
+
+	c.Path = initPath
+	c.Args = params
+
+	// The child blocks on this pipe until the parent has placed it into
+	// its cgroups and handed it its network interface
+	syncReader, syncWriter, err := os.Pipe()
+	if err != nil {
+		return -1, err
+	}
+	defer syncWriter.Close()
+	c.ExtraFiles = []*os.File{syncReader}
+	setupNamespaces(c)
+
+	if err := c.Start(); err != nil {
+		syncReader.Close()
+		return -1, err
+	}
+	syncReader.Close()
+
+	cgroup := d.cgroup(c)
+	defer cgroup.Cleanup()
+
+	if err := d.setupChild(c, cgroup, syncWriter); err != nil {
+		c.Process.Kill()
+		c.Wait()
+		return -1, err
+	}
+	syncWriter.Close()
+
+	if err := ioutil.WriteFile(d.pidPath(c.ID), []byte(strconv.Itoa(c.Pid())), 0600); err != nil {
+		utils.Errorf("%s: Unable to write pid file: %s", c.ID, err)
+	}
+	defer os.Remove(d.pidPath(c.ID))
+
+	if startCallback != nil {
+		startCallback(c)
+	}
+
+	var waitErr error
+	if err := c.Wait(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok { // Do not propagate the error if it's simply a status code != 0
+			waitErr = err
+		}
+	}
+	return getExitCode(c), waitErr
+}
+
+// setupChild places the freshly started process into its cgroups and
+// moves the container end of its veth pair into the new network namespace.
+// The name of the interface is written to the sync pipe for the child to
+// pick up.
+func (d *driver) setupChild(c *execdriver.Command, cgroup *cgroups.Cgroup, syncPipe *os.File) error {
+	if err := cgroup.Apply(c.Pid()); err != nil {
+		return fmt.Errorf("Unable to apply cgroups: %s", err)
+	}
+	if c.Network == nil {
+		return nil
+	}
+	peer, err := d.setupVeth(c.Network, c.Pid())
+	if err != nil {
+		return fmt.Errorf("Unable to set up networking: %s", err)
+	}
+	_, err = syncPipe.Write([]byte(peer))
+	return err
+}
+
+func (d *driver) setupVeth(network *execdriver.Network, pid int) (string, error) {
+	name, peer, err := createVethPair()
+	if err != nil {
+		return "", err
+	}
+	host, err := net.InterfaceByName(name)
+	if err != nil {
+		return "", err
+	}
+	bridge, err := net.InterfaceByName(network.Bridge)
+	if err != nil {
+		return "", err
+	}
+	if err := netlink.NetworkSetMaster(host, bridge); err != nil {
+		return "", err
+	}
+	if err := netlink.NetworkSetMTU(host, network.Mtu); err != nil {
+		return "", err
+	}
+	if err := netlink.NetworkLinkUp(host); err != nil {
+		return "", err
+	}
+	child, err := net.InterfaceByName(peer)
+	if err != nil {
+		return "", err
+	}
+	if err := netlink.NetworkSetNsPid(child, pid); err != nil {
+		return "", err
+	}
+	return peer, nil
+}
+
+func createVethPair() (string, string, error) {
+	// Interface names are limited to 15 characters. Retry in the unlikely
+	// event of a collision with an existing interface.
+	for i := 0; i < 10; i++ {
+		var (
+			suffix = utils.RandomString()[:7]
+			name   = "veth" + suffix
+			peer   = "veth" + suffix + "c"
+		)
+		if err := netlink.NetworkCreateVethPair(name, peer); err != nil {
+			if err == syscall.EEXIST {
+				continue
+			}
+			return "", "", err
+		}
+		return name, peer, nil
+	}
+	return "", "", fmt.Errorf("Unable to find a free name for the veth pair")
+}
+
+// Return the exit code of the process
+// if the process has not exited -1 will be returned
+func getExitCode(c *execdriver.Command) int {
+	if c.ProcessState == nil {
+		return -1
+	}
+	return c.ProcessState.Sys().(syscall.WaitStatus).ExitStatus()
+}
+
+func (d *driver) Kill(c *execdriver.Command, sig int) error {
+	pid, err := d.initPid(c)
+	if err != nil {
+		return err
+	}
+	// Killing the init of the pid namespace takes every other process
+	// of the container with it
+	return syscall.Kill(pid, syscall.Signal(sig))
+}
+
+func (d *driver) Exec(c *execdriver.Command, p *execdriver.Process, startCallback execdriver.ProcessCallback) (int, error) {
+	pid, err := d.initPid(c)
+	if err != nil {
+		return -1, err
+	}
+	nsenter, err := exec.LookPath("nsenter")
+	if err != nil {
+		return -1, fmt.Errorf("nsenter is required to execute a process in a running container: %s", err)
+	}
+	params := []string{
+		nsenter,
+		"--target", strconv.Itoa(pid),
+		"--mount", "--uts", "--ipc", "--net", "--pid",
+		"--",
+		c.InitPath,
+		"-driver",
+		execInitName,
+	}
+
+	if p.User != "" {
+		params = append(params, "-u", p.User)
+	}
+
+	if p.Privileged {
+		params = append(params, "-privileged")
+	}
+
+	if p.WorkingDir != "" {
+		params = append(params, "-w", p.WorkingDir)
+	}
+
+	params = append(params, "--", p.Entrypoint)
+	params = append(params, p.Arguments...)
+
+	p.Path = nsenter
+	p.Args = params
+
+	// nsenter forks after entering the pid namespace, so we don't know the
+	// pid of the process to put into the cgroups of the container. It
+	// blocks on this socket until we have, after sending its credentials,
+	// which the kernel translates to our pid namespace.
+	syncParent, syncChild, err := newExecSyncPair()
+	if err != nil {
+		return -1, err
+	}
+	defer syncParent.Close()
+	p.ExtraFiles = []*os.File{syncChild}
+
+	if err := p.Start(); err != nil {
+		syncChild.Close()
+		return -1, err
+	}
+	syncChild.Close()
+
+	if err := d.joinCgroup(c, syncParent); err != nil {
+		p.Process.Kill()
+		p.Wait()
+		return -1, err
+	}
+	syncParent.Close()
+
+	if startCallback != nil {
+		startCallback(p)
+	}
+
+	if err := p.Wait(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok { // Do not propagate the error if it's simply a status code != 0
+			return -1, err
+		}
+	}
+	return p.ProcessState.Sys().(syscall.WaitStatus).ExitStatus(), nil
+}
+
+// joinCgroup receives the pid of the process started by Exec from the
+// sync socket and adds it to every cgroup of the container.
+func (d *driver) joinCgroup(c *execdriver.Command, syncPipe *os.File) error {
+	pid, err := recvExecPid(syncPipe)
+	if err != nil {
+		return fmt.Errorf("Unable to receive the pid of the process: %s", err)
+	}
+	for _, dir := range d.cgroup(c).Paths() {
+		if err := ioutil.WriteFile(filepath.Join(dir, "tasks"), []byte(strconv.Itoa(pid)), 0700); err != nil {
+			return fmt.Errorf("Unable to join the cgroups of the container: %s", err)
+		}
+	}
+	return nil
+}
+
+// initPid returns the pid of the first process of the container, which
+// might have been started by a previous daemon
+func (d *driver) initPid(c *execdriver.Command) (int, error) {
+	if pid := c.Pid(); pid != -1 {
+		return pid, nil
+	}
+	data, err := ioutil.ReadFile(d.pidPath(c.ID))
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(string(data))
+}
+
+func (d *driver) Pause(c *execdriver.Command) error {
+	return d.cgroup(c).Freeze()
+}
+
+func (d *driver) Unpause(c *execdriver.Command) error {
+	return d.cgroup(c).Thaw()
+}
+
+func (d *driver) Restore(c *execdriver.Command) error {
+	// We are not the parent of a process started by a previous daemon,
+	// so poll its cgroup until it is empty
+	cgroup := d.cgroup(c)
+	for {
+		pids, err := cgroup.GetPids()
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if len(pids) == 0 {
+			os.Remove(d.pidPath(c.ID))
+			return cgroup.Cleanup()
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+type info struct {
+	ID     string
+	driver *driver
+}
+
+func (i *info) IsRunning() bool {
+	pids, err := i.driver.GetPidsForContainer(i.ID)
+	return err == nil && len(pids) > 0
+}
+
+func (d *driver) Info(id string) execdriver.Info {
+	return &info{
+		ID:     id,
+		driver: d,
+	}
+}
+
+func (d *driver) GetPidsForContainer(id string) ([]int, error) {
+	return (&cgroups.Cgroup{Name: id, Parent: "docker"}).GetPids()
+}
+
+func (d *driver) GetStatsForContainer(id string) (*cgroups.Stats, error) {
+	return (&cgroups.Cgroup{Name: id, Parent: "docker"}).Stats()
+}
+
+func (d *driver) cgroup(c *execdriver.Command) *cgroups.Cgroup {
+	cgroup := &cgroups.Cgroup{
+		Name:         c.ID,
+		Parent:       "docker",
+		DeviceAccess: c.Privileged,
+	}
+	if c.Resources != nil {
+		cgroup.Memory = c.Resources.Memory
+		cgroup.MemorySwap = c.Resources.MemorySwap
+		cgroup.CpuShares = c.Resources.CpuShares
+	}
+	return cgroup
+}
+
+func (d *driver) pidPath(id string) string {
+	return filepath.Join(d.root, "containers", id, "native.pid")
+}
@@ -0,0 +1,368 @@
+// +build amd64
+
+package native
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/execdriver"
+	"github.com/dotcloud/docker/pkg/netlink"
+	"github.com/dotcloud/docker/utils"
+	"github.com/syndtr/gocapability/capability"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const namespaceFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWNET
+
+func setupNamespaces(c *execdriver.Command) {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Cloneflags = namespaceFlags
+}
+
+func checkNamespaceSupport() error {
+	for _, ns := range []string{"ipc", "mnt", "net", "pid", "uts"} {
+		if _, err := os.Stat(filepath.Join("/proc/self/ns", ns)); err != nil {
+			return fmt.Errorf("Your kernel does not support the %s namespace: %s", ns, err)
+		}
+	}
+	return nil
+}
+
+// initContainer runs as pid 1 of the new namespaces, from the host's
+// filesystem. It sets up the rootfs, the hostname and the network before
+// dropping privileges and executing the user process.
+func initContainer(args *execdriver.InitArgs) error {
+	// Wait for the parent to put us in our cgroups and hand us our
+	// network interface
+	syncPipe := os.NewFile(3, "sync")
+	data, err := ioutil.ReadAll(syncPipe)
+	if err != nil {
+		return fmt.Errorf("Unable to read from the sync pipe: %s", err)
+	}
+	syncPipe.Close()
+
+	if err := setupRootfs(args.Root, args.Privileged); err != nil {
+		return err
+	}
+
+	if err := setupHostname(args); err != nil {
+		return err
+	}
+
+	if err := setupNetworking(args, string(data)); err != nil {
+		return err
+	}
+
+	return execInContainer(args)
+}
+
+// enterContainer runs inside the namespaces of a running container, where
+// nsenter started it for Exec. It waits for the parent to put it into the
+// cgroups of the container before executing the user process.
+func enterContainer(args *execdriver.InitArgs) error {
+	syncPipe := os.NewFile(3, "sync")
+	ucred := &syscall.Ucred{
+		Pid: int32(os.Getpid()),
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+	if err := syscall.Sendmsg(int(syncPipe.Fd()), []byte{0}, syscall.UnixCredentials(ucred), nil, 0); err != nil {
+		return fmt.Errorf("Unable to send credentials to the parent: %s", err)
+	}
+	if _, err := ioutil.ReadAll(syncPipe); err != nil {
+		return fmt.Errorf("Unable to read from the sync pipe: %s", err)
+	}
+	syncPipe.Close()
+
+	return execInContainer(args)
+}
+
+// newExecSyncPair returns the two ends of the socket Exec uses to learn
+// the pid of the process it started. The parent end accepts credentials.
+func newExecSyncPair() (*os.File, *os.File, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	if err := syscall.SetsockoptInt(fds[0], syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1); err != nil {
+		syscall.Close(fds[0])
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+	return os.NewFile(uintptr(fds[0]), "sync"), os.NewFile(uintptr(fds[1]), "sync"), nil
+}
+
+// recvExecPid waits for the credentials sent by enterContainer and returns
+// the pid of the sender, as seen from our pid namespace.
+func recvExecPid(syncPipe *os.File) (int, error) {
+	var (
+		buf = make([]byte, 1)
+		oob = make([]byte, syscall.CmsgSpace(syscall.SizeofUcred))
+	)
+	n, oobn, _, _, err := syscall.Recvmsg(int(syncPipe.Fd()), buf, oob, 0)
+	if err != nil {
+		return -1, err
+	}
+	if n == 0 {
+		return -1, fmt.Errorf("the process exited before sending its credentials")
+	}
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil {
+		return -1, err
+	}
+	if len(msgs) != 1 {
+		return -1, fmt.Errorf("expected 1 control message, got %d", len(msgs))
+	}
+	ucred, err := syscall.ParseUnixCredentials(&msgs[0])
+	if err != nil {
+		return -1, err
+	}
+	return int(ucred.Pid), nil
+}
+
+// execInContainer drops privileges and executes the user process. It is
+// also used on its own to start additional processes in a running
+// container, in which case it is already inside its namespaces.
+func execInContainer(args *execdriver.InitArgs) error {
+	if err := setupCapabilities(args); err != nil {
+		return err
+	}
+
+	if err := setupWorkingDirectory(args); err != nil {
+		return err
+	}
+
+	if err := changeUser(args); err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(args.Args[0])
+	if err != nil {
+		log.Printf("Unable to locate %v", args.Args[0])
+		os.Exit(127)
+	}
+	if err := syscall.Exec(path, args.Args, os.Environ()); err != nil {
+		return fmt.Errorf("dockerinit unable to execute %s - %s", path, err)
+	}
+	panic("Unreachable")
+}
+
+// setupRootfs mounts the special filesystems inside the container's
+// rootfs and makes it the new root with pivot_root.
+func setupRootfs(rootfs string, privileged bool) error {
+	// Make sure none of our mounts propagate back to the host
+	if err := syscall.Mount("", "/", "", syscall.MS_SLAVE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("Unable to make / a slave mount: %s", err)
+	}
+	// pivot_root needs the new root to be a mount point
+	if err := syscall.Mount(rootfs, rootfs, "bind", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("Unable to bind mount %s: %s", rootfs, err)
+	}
+
+	sysFlags := syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC
+	if !privileged {
+		sysFlags |= syscall.MS_RDONLY
+	}
+	for _, m := range []struct {
+		source, target, fstype string
+		flags                  int
+		data                   string
+	}{
+		{"proc", "/proc", "proc", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, ""},
+		{"sysfs", "/sys", "sysfs", sysFlags, ""},
+		{"devpts", "/dev/pts", "devpts", syscall.MS_NOSUID | syscall.MS_NOEXEC, "newinstance,ptmxmode=0666"},
+		{"shm", "/dev/shm", "tmpfs", syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC, "size=65536k"},
+	} {
+		target := filepath.Join(rootfs, m.target)
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return err
+		}
+		if err := syscall.Mount(m.source, target, m.fstype, uintptr(m.flags), m.data); err != nil {
+			return fmt.Errorf("Unable to mount %s on %s: %s", m.source, target, err)
+		}
+	}
+
+	// Use the ptmx of our private devpts instance
+	ptmx := filepath.Join(rootfs, "/dev/ptmx")
+	if err := os.Remove(ptmx); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Symlink("pts/ptmx", ptmx); err != nil {
+		return err
+	}
+
+	return pivotRoot(rootfs)
+}
+
+func pivotRoot(rootfs string) error {
+	pivotDir, err := ioutil.TempDir(rootfs, ".pivot_root")
+	if err != nil {
+		return err
+	}
+	if err := syscall.PivotRoot(rootfs, pivotDir); err != nil {
+		return fmt.Errorf("pivot_root %s: %s", rootfs, err)
+	}
+	if err := syscall.Chdir("/"); err != nil {
+		return err
+	}
+	// The old root is now mounted under the pivot directory
+	pivotDir = filepath.Join("/", filepath.Base(pivotDir))
+	if err := syscall.Unmount(pivotDir, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("Unable to unmount the old root: %s", err)
+	}
+	return os.Remove(pivotDir)
+}
+
+func setupHostname(args *execdriver.InitArgs) error {
+	hostname := getEnv(args, "HOSTNAME")
+	if hostname == "" {
+		return nil
+	}
+	return syscall.Sethostname([]byte(hostname))
+}
+
+// Setup networking
+func setupNetworking(args *execdriver.InitArgs, veth string) error {
+	// loopback
+	iface, err := net.InterfaceByName("lo")
+	if err != nil {
+		return fmt.Errorf("Unable to set up networking: %v", err)
+	}
+	if err := netlink.NetworkLinkUp(iface); err != nil {
+		return fmt.Errorf("Unable to set up networking: %v", err)
+	}
+
+	if veth == "" || args.Ip == "" {
+		return nil
+	}
+
+	// eth0
+	iface, err = net.InterfaceByName(veth)
+	if err != nil {
+		return fmt.Errorf("Unable to set up networking: %v", err)
+	}
+	if err := netlink.NetworkChangeName(iface, "eth0"); err != nil {
+		return fmt.Errorf("Unable to set up networking: %v", err)
+	}
+	if iface, err = net.InterfaceByName("eth0"); err != nil {
+		return fmt.Errorf("Unable to set up networking: %v", err)
+	}
+	ip, ipNet, err := net.ParseCIDR(args.Ip)
+	if err != nil {
+		return fmt.Errorf("Unable to set up networking: %v", err)
+	}
+	if err := netlink.NetworkLinkAddIp(iface, ip, ipNet); err != nil {
+		return fmt.Errorf("Unable to set up networking: %v", err)
+	}
+	if err := netlink.NetworkSetMTU(iface, args.Mtu); err != nil {
+		return fmt.Errorf("Unable to set MTU: %v", err)
+	}
+	if err := netlink.NetworkLinkUp(iface); err != nil {
+		return fmt.Errorf("Unable to set up networking: %v", err)
+	}
+
+	if args.Gateway != "" {
+		gw := net.ParseIP(args.Gateway)
+		if gw == nil {
+			return fmt.Errorf("Unable to set up networking, %s is not a valid gateway IP", args.Gateway)
+		}
+
+		if err := netlink.AddDefaultGw(gw); err != nil {
+			return fmt.Errorf("Unable to set up networking: %v", err)
+		}
+	}
+	return nil
+}
+
+// Setup working directory
+func setupWorkingDirectory(args *execdriver.InitArgs) error {
+	if args.WorkDir == "" {
+		return nil
+	}
+	if err := syscall.Chdir(args.WorkDir); err != nil {
+		return fmt.Errorf("Unable to change dir to %v: %v", args.WorkDir, err)
+	}
+	return nil
+}
+
+// Takes care of dropping privileges to the desired user
+func changeUser(args *execdriver.InitArgs) error {
+	if args.User == "" {
+		return nil
+	}
+	userent, err := utils.UserLookup(args.User)
+	if err != nil {
+		return fmt.Errorf("Unable to find user %v: %v", args.User, err)
+	}
+
+	uid, err := strconv.Atoi(userent.Uid)
+	if err != nil {
+		return fmt.Errorf("Invalid uid: %v", userent.Uid)
+	}
+	gid, err := strconv.Atoi(userent.Gid)
+	if err != nil {
+		return fmt.Errorf("Invalid gid: %v", userent.Gid)
+	}
+
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid failed: %v", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid failed: %v", err)
+	}
+
+	return nil
+}
+
+func setupCapabilities(args *execdriver.InitArgs) error {
+	if args.Privileged {
+		return nil
+	}
+
+	drop := []capability.Cap{
+		capability.CAP_SETPCAP,
+		capability.CAP_SYS_MODULE,
+		capability.CAP_SYS_RAWIO,
+		capability.CAP_SYS_PACCT,
+		capability.CAP_SYS_ADMIN,
+		capability.CAP_SYS_NICE,
+		capability.CAP_SYS_RESOURCE,
+		capability.CAP_SYS_TIME,
+		capability.CAP_SYS_TTY_CONFIG,
+		capability.CAP_MKNOD,
+		capability.CAP_AUDIT_WRITE,
+		capability.CAP_AUDIT_CONTROL,
+		capability.CAP_MAC_OVERRIDE,
+		capability.CAP_MAC_ADMIN,
+	}
+
+	c, err := capability.NewPid(os.Getpid())
+	if err != nil {
+		return err
+	}
+
+	c.Unset(capability.CAPS|capability.BOUNDS, drop...)
+
+	return c.Apply(capability.CAPS | capability.BOUNDS)
+}
+
+func getEnv(args *execdriver.InitArgs, key string) string {
+	for _, kv := range args.Env {
+		parts := strings.SplitN(kv, "=", 2)
+		if parts[0] == key && len(parts) == 2 {
+			return parts[1]
+		}
+	}
+	return ""
+}
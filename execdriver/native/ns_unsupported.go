@@ -0,0 +1,35 @@
+// +build !linux !amd64
+
+package native
+
+import (
+	"github.com/dotcloud/docker/execdriver"
+	"os"
+)
+
+func setupNamespaces(c *execdriver.Command) {
+}
+
+func checkNamespaceSupport() error {
+	return execdriver.ErrNotSupported
+}
+
+func initContainer(args *execdriver.InitArgs) error {
+	return execdriver.ErrNotSupported
+}
+
+func execInContainer(args *execdriver.InitArgs) error {
+	return execdriver.ErrNotSupported
+}
+
+func enterContainer(args *execdriver.InitArgs) error {
+	return execdriver.ErrNotSupported
+}
+
+func newExecSyncPair() (*os.File, *os.File, error) {
+	return nil, nil, execdriver.ErrNotSupported
+}
+
+func recvExecPid(syncPipe *os.File) (int, error) {
+	return -1, execdriver.ErrNotSupported
+}
@@ -0,0 +1,241 @@
+package docker
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/engine"
+	"github.com/dotcloud/docker/graphdriver"
+	"github.com/dotcloud/docker/utils"
+	"strings"
+	"time"
+)
+
+// GarbageCollect removes what takes space without being used: the stopped
+// containers older than ContainerAge when it is set, the untagged images
+// which are the parent of no image or container, the anonymous volumes of
+// no container and the layers of the storage driver which belong to nothing.
+// With DryRun, it only lists them.
+func (srv *Server) GarbageCollect(job *engine.Job) engine.Status {
+	if len(job.Args) != 0 {
+		return job.Errorf("Usage: %s", job.Name)
+	}
+	var (
+		dryRun  = job.GetenvBool("DryRun")
+		removed = engine.NewTable("", 0)
+		gone    = make(map[string]bool) // containers removed, or which would be
+	)
+
+	report := func(kind, id string, size int64) {
+		out := &engine.Env{}
+		out.Set("Type", kind)
+		out.Set("ID", id)
+		out.SetInt64("Size", size)
+		removed.Add(out)
+	}
+
+	if age := job.Getenv("ContainerAge"); age != "" {
+		maxAge, err := time.ParseDuration(age)
+		if err != nil {
+			return job.Errorf("Invalid container age %s: %s", age, err)
+		}
+		for _, container := range srv.runtime.List() {
+			if container.State.IsRunning() {
+				continue
+			}
+			last := container.State.FinishedAt
+			if last.IsZero() {
+				last = container.Created
+			}
+			if time.Since(last) < maxAge {
+				continue
+			}
+			size, _ := container.GetSize()
+			if !dryRun {
+				if err := srv.runtime.Destroy(container); err != nil {
+					return job.Errorf("Cannot destroy container %s: %s", container.ID, err)
+				}
+				srv.LogEvent("destroy", container.ID, srv.runtime.repositories.ImageName(container.Image))
+			}
+			gone[container.ID] = true
+			report("container", container.ID, size)
+		}
+	}
+
+	var containers []*Container
+	for _, container := range srv.runtime.List() {
+		if !gone[container.ID] {
+			containers = append(containers, container)
+		}
+	}
+
+	images, err := srv.danglingImages(containers)
+	if err != nil {
+		return job.Error(err)
+	}
+	for _, img := range images {
+		if !dryRun {
+			if err := srv.runtime.graph.Delete(img.ID); err != nil {
+				return job.Errorf("Cannot delete image %s: %s", img.ID, err)
+			}
+			srv.LogEvent("delete", img.ID, "")
+		}
+		report("image", img.ID, img.Size)
+	}
+
+	// The named volumes are kept until they are removed with docker volume rm
+	usedVolumes := make(map[string]bool)
+	for _, container := range containers {
+		for _, p := range container.Volumes {
+			if volume := srv.runtime.volumes.ByPath(p); volume != nil {
+				usedVolumes[volume.Name] = true
+			}
+		}
+	}
+	for _, volume := range srv.runtime.volumes.List() {
+		if !volume.Anonymous || usedVolumes[volume.Name] {
+			continue
+		}
+		// The volumes of the plugins are not stored on the host
+		var size int64
+		if volume.Driver == defaultVolumeDriver {
+			size = layerSize(srv.runtime.volumesDriver, volume.ID)
+		}
+		if !dryRun {
+			if err := srv.runtime.volumes.Delete(volume.Name); err != nil {
+				return job.Errorf("Cannot delete volume %s: %s", volume.Name, err)
+			}
+		}
+		report("volume", volume.ID, size)
+	}
+
+	// The volumes and the images share a driver when it is vfs
+	drivers := []graphdriver.Driver{srv.runtime.driver}
+	if srv.runtime.volumesDriver.String() != srv.runtime.driver.String() {
+		drivers = append(drivers, srv.runtime.volumesDriver)
+	}
+	for _, driver := range drivers {
+		lister, ok := driver.(graphdriver.Lister)
+		if !ok {
+			continue
+		}
+		if err := srv.removeLayers(driver, lister, dryRun, report); err != nil {
+			return job.Error(err)
+		}
+	}
+
+	if _, err := removed.WriteListTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+// danglingImages returns the untagged images which are used by none of
+// containers, and whose children are all dangling too, children first
+func (srv *Server) danglingImages(containers []*Container) ([]*Image, error) {
+	images, err := srv.runtime.graph.Map()
+	if err != nil {
+		return nil, err
+	}
+	byParent, err := srv.runtime.graph.ByParent()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		tagged   = srv.runtime.repositories.ByID()
+		used     = make(map[string]bool)
+		dangling = make(map[string]bool)
+		result   []*Image
+		visit    func(img *Image) bool
+	)
+	for _, container := range containers {
+		used[container.Image] = true
+	}
+
+	visit = func(img *Image) bool {
+		if d, visited := dangling[img.ID]; visited {
+			return d
+		}
+		d := len(tagged[img.ID]) == 0 && !used[img.ID]
+		for _, child := range byParent[img.ID] {
+			// Visit all children, to collect them even if img is kept
+			if !visit(child) {
+				d = false
+			}
+		}
+		dangling[img.ID] = d
+		if d {
+			result = append(result, img)
+		}
+		return d
+	}
+	for _, img := range images {
+		visit(img)
+	}
+	return result, nil
+}
+
+// removeLayers removes the layers of driver which belong to nothing. The
+// images and the containers get their layers before they are registered,
+// so their layers are reserved in the graph while they are created, and
+// the volumes in their store.
+func (srv *Server) removeLayers(driver graphdriver.Driver, lister graphdriver.Lister, dryRun bool, report func(kind, id string, size int64)) error {
+	ids, err := lister.List()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := srv.removeLayer(driver, id, dryRun, report); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeLayer removes the layer id of driver if it belongs to nothing. The
+// lock of the layers is only held meanwhile, for a long pull not to stall
+// the garbage collector.
+func (srv *Server) removeLayer(driver graphdriver.Driver, id string, dryRun bool, report func(kind, id string, size int64)) error {
+	graph := srv.runtime.graph
+	graph.layersLock.Lock()
+	defer graph.layersLock.Unlock()
+
+	if graph.creatingLayers[id] > 0 || srv.layerOwned(id) {
+		return nil
+	}
+	size := layerSize(driver, id)
+	if !dryRun {
+		if err := driver.Remove(id); err != nil {
+			return fmt.Errorf("Cannot remove layer %s: %s", id, err)
+		}
+	}
+	report("layer", id, size)
+	return nil
+}
+
+// layerOwned returns whether a layer of a driver belongs to an image, a
+// container or a volume
+func (srv *Server) layerOwned(id string) bool {
+	return srv.runtime.graph.Exists(id) ||
+		srv.runtime.volumes.Exists(id) ||
+		srv.runtime.Get(strings.TrimSuffix(id, "-init")) != nil
+}
+
+func layerSize(driver graphdriver.Driver, id string) int64 {
+	if differ, ok := driver.(graphdriver.Differ); ok {
+		size, err := differ.DiffSize(id)
+		if err != nil {
+			utils.Debugf("Error computing the size of %s: %s", id, err)
+		}
+		return size
+	}
+	dir, err := driver.Get(id)
+	if err != nil {
+		utils.Debugf("Error getting layer %s: %s", id, err)
+		return 0
+	}
+	defer driver.Put(id)
+	size, err := utils.TreeSize(dir)
+	if err != nil {
+		utils.Debugf("Error computing the size of %s: %s", dir, err)
+	}
+	return size
+}
@@ -12,6 +12,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -21,6 +22,11 @@ type Graph struct {
 	Root    string
 	idIndex *utils.TruncIndex
 	driver  graphdriver.Driver
+	// The layers of the driver which are being created and have no owner
+	// yet. The garbage collector holds the lock while it checks whether
+	// a layer has an owner and removes it.
+	layersLock     sync.Mutex
+	creatingLayers map[string]int
 }
 
 // NewGraph instantiates a new graph at the given root path in the filesystem.
@@ -36,9 +42,10 @@ func NewGraph(root string, driver graphdriver.Driver) (*Graph, error) {
 	}
 
 	graph := &Graph{
-		Root:    abspath,
-		idIndex: utils.NewTruncIndex(),
-		driver:  driver,
+		Root:           abspath,
+		idIndex:        utils.NewTruncIndex(),
+		driver:         driver,
+		creatingLayers: make(map[string]int),
 	}
 	if err := graph.restore(); err != nil {
 		return nil, err
@@ -150,6 +157,7 @@ func (graph *Graph) Create(layerData archive.Archive, container *Container, comm
 // Register imports a pre-existing image into the graph.
 // FIXME: pass img as first argument
 func (graph *Graph) Register(jsonData []byte, layerData archive.Archive, img *Image) (err error) {
+	defer graph.reserveLayers(img.ID)()
 	defer func() {
 		// If any error occurs, remove the new dir from the driver.
 		// Don't check for errors since the dir might not have been created.
@@ -207,6 +215,26 @@ func (graph *Graph) Register(jsonData []byte, layerData archive.Archive, img *Im
 	return nil
 }
 
+// reserveLayers keeps the garbage collector away from the layers ids,
+// which are about to be created, until the returned function is called
+func (graph *Graph) reserveLayers(ids ...string) func() {
+	graph.layersLock.Lock()
+	for _, id := range ids {
+		graph.creatingLayers[id]++
+	}
+	graph.layersLock.Unlock()
+
+	return func() {
+		graph.layersLock.Lock()
+		for _, id := range ids {
+			if graph.creatingLayers[id]--; graph.creatingLayers[id] == 0 {
+				delete(graph.creatingLayers, id)
+			}
+		}
+		graph.layersLock.Unlock()
+	}
+}
+
 // TempLayerArchive creates a temporary archive of the given image's filesystem layer.
 //   The archive is stored on disk and will be automatically deleted as soon as has been read.
 //   If output is not nil, a human-readable progress bar will be written to it.
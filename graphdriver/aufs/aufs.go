@@ -46,7 +46,7 @@ type Driver struct {
 
 // New returns a new AUFS driver.
 // An error is returned if AUFS is not supported.
-func Init(root string) (graphdriver.Driver, error) {
+func Init(root string, options []string) (graphdriver.Driver, error) {
 	// Try to load the aufs kernel module
 	if err := supportsAufs(); err != nil {
 		return nil, err
@@ -340,6 +340,10 @@ func (a *Driver) mounted(id string) (bool, error) {
 	return mountpk.Mounted(target)
 }
 
+func (a *Driver) List() ([]string, error) {
+	return loadIds(path.Join(a.rootPath(), "layers"))
+}
+
 // During cleanup aufs needs to unmount all mountpoints
 func (a *Driver) Cleanup() error {
 	ids, err := loadIds(path.Join(a.rootPath(), "layers"))
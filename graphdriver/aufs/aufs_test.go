@@ -20,7 +20,7 @@ func newDriver(t *testing.T) *Driver {
 		t.Fatal(err)
 	}
 
-	d, err := Init(tmp)
+	d, err := Init(tmp, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -32,7 +32,7 @@ func TestNewDriver(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	d, err := Init(tmp)
+	d, err := Init(tmp, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -74,10 +74,10 @@ func TestNewDriverFromExistingDir(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if _, err := Init(tmp); err != nil {
+	if _, err := Init(tmp, nil); err != nil {
 		t.Fatal(err)
 	}
-	if _, err := Init(tmp); err != nil {
+	if _, err := Init(tmp, nil); err != nil {
 		t.Fatal(err)
 	}
 	os.RemoveAll(tmp)
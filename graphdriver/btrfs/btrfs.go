@@ -11,18 +11,25 @@ import "C"
 
 import (
 	"fmt"
+	"github.com/dotcloud/docker/archive"
 	"github.com/dotcloud/docker/graphdriver"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"syscall"
 	"unsafe"
 )
 
+// From ctree.h
+const qgroupLimitMaxExcl = 1 << 1
+
 func init() {
 	graphdriver.Register("btrfs", Init)
 }
 
-func Init(home string) (graphdriver.Driver, error) {
+func Init(home string, options []string) (graphdriver.Driver, error) {
 	rootdir := path.Dir(home)
 
 	var buf syscall.Statfs_t
@@ -34,13 +41,41 @@ func Init(home string) (graphdriver.Driver, error) {
 		return nil, fmt.Errorf("%s is not a btrfs filesystem", rootdir)
 	}
 
-	return &Driver{
+	d := &Driver{
 		home: home,
-	}, nil
+	}
+
+	opts, err := graphdriver.DriverOptions("btrfs", options)
+	if err != nil {
+		return nil, err
+	}
+	for key, val := range opts {
+		switch key {
+		case "quota":
+			size, err := utils.RAMInBytes(val)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid btrfs.quota %s: %s", val, err)
+			}
+			d.quota = uint64(size)
+		default:
+			return nil, fmt.Errorf("Unknown option btrfs.%s", key)
+		}
+	}
+
+	if d.quota > 0 {
+		if err := os.MkdirAll(home, 0700); err != nil {
+			return nil, err
+		}
+		if err := quotaEnable(home); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
 }
 
 type Driver struct {
-	home string
+	home  string
+	quota uint64 // Size limit of the containers, 0 for none
 }
 
 func (d *Driver) String() string {
@@ -48,7 +83,12 @@ func (d *Driver) String() string {
 }
 
 func (d *Driver) Status() [][2]string {
-	return nil
+	if d.quota == 0 {
+		return nil
+	}
+	return [][2]string{
+		{"Container Quota", utils.HumanSize(int64(d.quota))},
+	}
 }
 
 func (d *Driver) Cleanup() error {
@@ -147,6 +187,65 @@ func subvolDelete(path, name string) error {
 	return nil
 }
 
+func quotaEnable(path string) error {
+	dir, err := openDir(path)
+	if err != nil {
+		return err
+	}
+	defer closeDir(dir)
+
+	var args C.struct_btrfs_ioctl_quota_ctl_args
+	args.cmd = C.BTRFS_QUOTA_CTL_ENABLE
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_QUOTA_CTL,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 && errno != syscall.EEXIST {
+		return fmt.Errorf("Failed to enable btrfs quotas: %v", errno.Error())
+	}
+	return nil
+}
+
+// subvolLimit limits the size of the data which is only in the subvolume
+// path, so that the data shared with its parent isn't accounted
+func subvolLimit(path string, size uint64) error {
+	dir, err := openDir(path)
+	if err != nil {
+		return err
+	}
+	defer closeDir(dir)
+
+	// The qgroup 0 is the one of the subvolume of the fd
+	var args C.struct_btrfs_ioctl_qgroup_limit_args
+	args.lim.flags = qgroupLimitMaxExcl
+	args.lim.max_exclusive = C.__u64(size)
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_QGROUP_LIMIT,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to limit btrfs subvolume size: %v", errno.Error())
+	}
+	return nil
+}
+
+// qgroupDestroy removes the qgroup left by a deleted subvolume
+func qgroupDestroy(path string, id uint64) error {
+	dir, err := openDir(path)
+	if err != nil {
+		return err
+	}
+	defer closeDir(dir)
+
+	var args C.struct_btrfs_ioctl_qgroup_create_args
+	args.qgroupid = C.__u64(id)
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, getDirFd(dir), C.BTRFS_IOC_QGROUP_CREATE,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 {
+		return fmt.Errorf("Failed to destroy btrfs qgroup: %v", errno.Error())
+	}
+	return nil
+}
+
 func (d *Driver) subvolumesDir() string {
 	return path.Join(d.home, "subvolumes")
 }
@@ -172,6 +271,14 @@ func (d *Driver) Create(id string, parent string) error {
 		if err := subvolSnapshot(parentDir, subvolumes, id); err != nil {
 			return err
 		}
+		// The runtime creates the layer of a container on top of its
+		// init layer, images are not limited
+		if d.quota > 0 && strings.HasSuffix(parent, "-init") {
+			if err := subvolLimit(d.subvolumesDirId(id), d.quota); err != nil {
+				subvolDelete(subvolumes, id)
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -181,9 +288,20 @@ func (d *Driver) Remove(id string) error {
 	if _, err := os.Stat(dir); err != nil {
 		return err
 	}
+	subvol, err := openDir(dir)
+	if err != nil {
+		return err
+	}
+	qgroup, err := subvolId(getDirFd(subvol))
+	closeDir(subvol)
+	if err != nil {
+		return err
+	}
 	if err := subvolDelete(d.subvolumesDir(), id); err != nil {
 		return err
 	}
+	// Fails when quotas are disabled, and there is no qgroup then
+	qgroupDestroy(d.subvolumesDir(), qgroup)
 	return os.RemoveAll(dir)
 }
 
@@ -206,8 +324,47 @@ func (d *Driver) Put(id string) {
 	// so this doesn't need to do anything.
 }
 
+func (d *Driver) List() ([]string, error) {
+	files, err := ioutil.ReadDir(d.subvolumesDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		ids = append(ids, f.Name())
+	}
+	return ids, nil
+}
+
 func (d *Driver) Exists(id string) bool {
 	dir := d.subvolumesDirId(id)
 	_, err := os.Stat(dir)
 	return err == nil
 }
+
+func (d *Driver) Diff(id string) (archive.Archive, error) {
+	changes, err := d.changes(id)
+	if err != nil {
+		return nil, err
+	}
+	return archive.ExportChanges(d.subvolumesDirId(id), changes)
+}
+
+func (d *Driver) ApplyDiff(id string, diff archive.Archive) error {
+	return archive.ApplyLayer(d.subvolumesDirId(id), diff)
+}
+
+func (d *Driver) DiffSize(id string) (int64, error) {
+	changes, err := d.changes(id)
+	if err != nil {
+		return -1, err
+	}
+	return archive.ChangesSize(d.subvolumesDirId(id), changes), nil
+}
+
+func (d *Driver) Changes(id string) ([]archive.Change, error) {
+	return d.changes(id)
+}
@@ -0,0 +1,340 @@
+// +build linux,amd64
+
+package btrfs
+
+/*
+#include <btrfs/ioctl.h>
+*/
+import "C"
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/dotcloud/docker/archive"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"unsafe"
+)
+
+// treeSearch calls fn for every item of a tree between the keys min and
+// max. Keys are compared as a whole, so items with a type out of
+// [minType, maxType] are skipped here.
+func treeSearch(fd uintptr, key searchKey, fn func(item searchItem) error) error {
+	var args C.struct_btrfs_ioctl_search_args
+	keyBuf := (*[searchKeySize]byte)(unsafe.Pointer(&args.key))
+	next := key
+	for {
+		next.encode(keyBuf[:])
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, C.BTRFS_IOC_TREE_SEARCH,
+			uintptr(unsafe.Pointer(&args)))
+		if errno != 0 {
+			return fmt.Errorf("Failed to search btrfs tree: %v", errno.Error())
+		}
+		n := int(args.key.nr_items)
+		if n == 0 {
+			return nil
+		}
+
+		buf := C.GoBytes(unsafe.Pointer(&args.buf[0]), C.int(len(args.buf)))
+		items, err := decodeSearchItems(buf, n)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if item.typ < key.minType || item.typ > key.maxType {
+				continue
+			}
+			if err := fn(item); err != nil {
+				return err
+			}
+		}
+
+		// Resume right after the last item
+		if !next.next(items[n-1]) {
+			return nil
+		}
+	}
+}
+
+// inoLookup returns the id of tree, resolved when 0, and the path of the
+// directory objectid in it, which ends with a / unless it is the root
+func inoLookup(fd uintptr, tree, objectid uint64) (uint64, string, error) {
+	var args C.struct_btrfs_ioctl_ino_lookup_args
+	args.treeid = C.__u64(tree)
+	args.objectid = C.__u64(objectid)
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, C.BTRFS_IOC_INO_LOOKUP,
+		uintptr(unsafe.Pointer(&args)))
+	if errno != 0 {
+		return 0, "", fmt.Errorf("Failed to lookup btrfs inode: %v", errno.Error())
+	}
+	return uint64(args.treeid), C.GoString(&args.name[0]), nil
+}
+
+func subvolId(fd uintptr) (uint64, error) {
+	id, _, err := inoLookup(fd, 0, firstFreeObjectid)
+	return id, err
+}
+
+func subvolInfoOf(fd uintptr, id uint64) (*subvolInfo, error) {
+	var info *subvolInfo
+	key := searchKey{
+		tree:        rootTreeObjectid,
+		minObjectid: id,
+		maxObjectid: id,
+		minType:     rootItemKey,
+		maxType:     rootItemKey,
+		maxOffset:   math.MaxUint64,
+	}
+	err := treeSearch(fd, key, func(item searchItem) error {
+		if info != nil {
+			return nil
+		}
+		var err error
+		info, err = parseRootItem(id, item.data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("Btrfs subvolume %d not found", id)
+	}
+	return info, nil
+}
+
+// subvolByUuid returns the id of the subvolume with uuid, from the uuid
+// tree of the filesystem
+func subvolByUuid(fd uintptr, uuid []byte) (uint64, error) {
+	var (
+		id               uint64
+		objectid, offset = uuidKey(uuid)
+		key              = searchKey{
+			tree:        uuidTreeObjectid,
+			minObjectid: objectid,
+			maxObjectid: objectid,
+			minType:     uuidKeySubvol,
+			maxType:     uuidKeySubvol,
+			minOffset:   offset,
+			maxOffset:   offset,
+		}
+	)
+	err := treeSearch(fd, key, func(item searchItem) error {
+		if id == 0 && len(item.data) >= 8 {
+			id = binary.LittleEndian.Uint64(item.data)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	if id == 0 {
+		return 0, fmt.Errorf("Parent btrfs subvolume not found")
+	}
+	return id, nil
+}
+
+// subvolName returns the name of the subvolume id in its parent directory
+func subvolName(fd uintptr, id uint64) (string, error) {
+	var (
+		name string
+		key  = searchKey{
+			tree:        rootTreeObjectid,
+			minObjectid: id,
+			maxObjectid: id,
+			minType:     rootBackrefKey,
+			maxType:     rootBackrefKey,
+			maxOffset:   math.MaxUint64,
+		}
+	)
+	err := treeSearch(fd, key, func(item searchItem) error {
+		if name == "" {
+			name = parseRootRef(item.data)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", fmt.Errorf("Btrfs subvolume %d has no name", id)
+	}
+	return name, nil
+}
+
+// inodePaths returns the paths of the hard links of an inode in tree
+func inodePaths(fd uintptr, tree, ino uint64) ([]string, error) {
+	if ino == firstFreeObjectid {
+		return []string{"/"}, nil
+	}
+
+	var (
+		paths []string
+		key   = searchKey{
+			tree:        tree,
+			minObjectid: ino,
+			maxObjectid: ino,
+			minType:     inodeRefKey,
+			maxType:     inodeRefKey,
+			maxOffset:   math.MaxUint64,
+		}
+	)
+	err := treeSearch(fd, key, func(item searchItem) error {
+		// The offset of the key is the inode of the directory
+		_, dir, err := inoLookup(fd, tree, item.offset)
+		if err != nil {
+			return err
+		}
+		// An item holds all the links of the inode in the directory
+		paths = append(paths, parseInodeRefs(dir, item.data)...)
+		return nil
+	})
+	return paths, err
+}
+
+// parentDir returns the subvolume which a layer is a snapshot of, or an
+// empty string for a layer without parent
+func (d *Driver) parentDir(fd uintptr, info *subvolInfo) (string, error) {
+	if string(info.parentUuid) == string(make([]byte, 16)) {
+		return "", nil
+	}
+	id, err := subvolByUuid(fd, info.parentUuid)
+	if err != nil {
+		return "", err
+	}
+	name, err := subvolName(fd, id)
+	if err != nil {
+		return "", err
+	}
+	return d.subvolumesDirId(name), nil
+}
+
+// changes lists the inodes of a layer written after the snapshot of its
+// parent was taken. Only the tree blocks written since are searched, so
+// the cost depends on the size of the changes rather than of the layer.
+func (d *Driver) changes(id string) ([]archive.Change, error) {
+	dir := d.subvolumesDirId(id)
+	subvol, err := openDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer closeDir(subvol)
+	fd := getDirFd(subvol)
+
+	tree, err := subvolId(fd)
+	if err != nil {
+		return nil, err
+	}
+	info, err := subvolInfoOf(fd, tree)
+	if err != nil {
+		return nil, err
+	}
+	parentDir, err := d.parentDir(fd, info)
+	if err != nil {
+		return nil, err
+	}
+	if parentDir == "" {
+		return addedChanges(dir)
+	}
+
+	var paths []string
+	key := searchKey{
+		tree:        tree,
+		minObjectid: firstFreeObjectid,
+		maxObjectid: math.MaxUint64,
+		minType:     inodeItemKey,
+		maxType:     inodeItemKey,
+		maxOffset:   math.MaxUint64,
+		minTransid:  info.otransid + 1,
+	}
+	err = treeSearch(fd, key, func(item searchItem) error {
+		if binary.LittleEndian.Uint64(item.data[inodeTransidOffset:]) <= info.otransid {
+			return nil
+		}
+		// An inode deleted while still open has no path
+		p, err := inodePaths(fd, tree, item.objectid)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []archive.Change
+	for _, name := range paths {
+		fi, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			// Removed since the search
+			continue
+		}
+		parentFi, err := os.Lstat(filepath.Join(parentDir, name))
+		if err != nil {
+			parentFi = nil
+		}
+
+		if name != "/" {
+			switch {
+			case parentFi == nil:
+				changes = append(changes, archive.Change{Path: name, Kind: archive.ChangeAdd})
+			case !sameInode(fi, parentFi):
+				changes = append(changes, archive.Change{Path: name, Kind: archive.ChangeModify})
+			}
+		}
+
+		// Removing an entry modifies its directory
+		if fi.IsDir() && parentFi != nil && parentFi.IsDir() {
+			entries, err := ioutil.ReadDir(filepath.Join(parentDir, name))
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				p := filepath.Join(name, entry.Name())
+				if _, err := os.Lstat(filepath.Join(dir, p)); os.IsNotExist(err) {
+					changes = append(changes, archive.Change{Path: p, Kind: archive.ChangeDelete})
+				}
+			}
+		}
+	}
+	sort.Sort(changesByPath(changes))
+	return changes, nil
+}
+
+// sameInode returns whether an inode of a snapshot wasn't modified since
+// it was taken: updating the access time doesn't change the ctime, which
+// any other modification sets to the current time
+func sameInode(a, b os.FileInfo) bool {
+	sa, sb := a.Sys().(*syscall.Stat_t), b.Sys().(*syscall.Stat_t)
+	return sa.Ctim == sb.Ctim && sa.Mtim == sb.Mtim && sa.Size == sb.Size && sa.Mode == sb.Mode
+}
+
+// addedChanges lists everything in a layer without parent as added
+func addedChanges(dir string) ([]archive.Change, error) {
+	var changes []archive.Change
+	err := filepath.Walk(dir, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if name != "." {
+			changes = append(changes, archive.Change{Path: filepath.Join("/", name), Kind: archive.ChangeAdd})
+		}
+		return nil
+	})
+	return changes, err
+}
+
+type changesByPath []archive.Change
+
+func (c changesByPath) Len() int           { return len(c) }
+func (c changesByPath) Less(i, j int) bool { return c[i].Path < c[j].Path }
+func (c changesByPath) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
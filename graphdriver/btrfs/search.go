@@ -0,0 +1,176 @@
+// +build linux,amd64
+
+package btrfs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Objectids and key types of ctree.h, which is not installed with the
+// headers of btrfs
+const (
+	rootTreeObjectid  = 1
+	uuidTreeObjectid  = 9
+	firstFreeObjectid = 256 // also the root directory of a subvolume
+
+	inodeItemKey   = 1
+	inodeRefKey    = 12
+	rootItemKey    = 132
+	rootBackrefKey = 144
+	uuidKeySubvol  = 251
+)
+
+// Layout of the arguments and of the items of the tree searches. The keys
+// and the items are encoded here rather than with cgo, so that they can be
+// tested without btrfs.
+const (
+	// struct btrfs_ioctl_search_key
+	searchKeySize     = 104
+	searchNrItemsOffs = 64
+	searchMaxItems    = 4096
+
+	// struct btrfs_ioctl_search_header
+	searchHeaderSize = 32
+
+	inodeTransidOffset = 8
+
+	rootGenerationOffset   = 160
+	rootGenerationV2Offset = 239
+	rootParentUuidOffset   = 263
+	rootOtransidOffset     = 303
+
+	inodeRefSize = 10
+	rootRefSize  = 18
+)
+
+type searchKey struct {
+	tree                     uint64 // 0 is the subvolume of the fd
+	minObjectid, maxObjectid uint64
+	minType, maxType         uint32
+	minOffset, maxOffset     uint64
+	minTransid               uint64 // skip the tree blocks older than this
+}
+
+type searchItem struct {
+	objectid uint64
+	typ      uint32
+	offset   uint64
+	data     []byte
+}
+
+// encode writes key as a struct btrfs_ioctl_search_key, asking for as many
+// items as fit in the buffer of the search
+func (key *searchKey) encode(buf []byte) {
+	le := binary.LittleEndian
+	le.PutUint64(buf[0:], key.tree)
+	le.PutUint64(buf[8:], key.minObjectid)
+	le.PutUint64(buf[16:], key.maxObjectid)
+	le.PutUint64(buf[24:], key.minOffset)
+	le.PutUint64(buf[32:], key.maxOffset)
+	le.PutUint64(buf[40:], key.minTransid)
+	le.PutUint64(buf[48:], math.MaxUint64)
+	le.PutUint32(buf[56:], key.minType)
+	le.PutUint32(buf[60:], key.maxType)
+	le.PutUint32(buf[searchNrItemsOffs:], searchMaxItems)
+	for i := searchNrItemsOffs + 4; i < searchKeySize; i++ {
+		buf[i] = 0
+	}
+}
+
+// next moves the start of key right after item, and returns false when
+// item was the last key to search
+func (key *searchKey) next(item searchItem) bool {
+	switch {
+	case item.offset < math.MaxUint64:
+		key.minObjectid, key.minType, key.minOffset = item.objectid, item.typ, item.offset+1
+	case item.typ < math.MaxUint8:
+		key.minObjectid, key.minType, key.minOffset = item.objectid, item.typ+1, 0
+	case item.objectid < key.maxObjectid:
+		key.minObjectid, key.minType, key.minOffset = item.objectid+1, 0, 0
+	default:
+		return false
+	}
+	return true
+}
+
+// decodeSearchItems returns the n items read into buf by a search. The
+// data of the items points into buf.
+func decodeSearchItems(buf []byte, n int) ([]searchItem, error) {
+	items := make([]searchItem, 0, n)
+	for i, off := 0, 0; i < n; i++ {
+		if off+searchHeaderSize > len(buf) {
+			return nil, fmt.Errorf("Truncated btrfs search header %d", i)
+		}
+		header := buf[off : off+searchHeaderSize]
+		length := int(binary.LittleEndian.Uint32(header[28:]))
+		off += searchHeaderSize
+		if off+length > len(buf) {
+			return nil, fmt.Errorf("Truncated btrfs search item %d", i)
+		}
+		items = append(items, searchItem{
+			objectid: binary.LittleEndian.Uint64(header[8:]),
+			offset:   binary.LittleEndian.Uint64(header[16:]),
+			typ:      binary.LittleEndian.Uint32(header[24:]),
+			data:     buf[off : off+length],
+		})
+		off += length
+	}
+	return items, nil
+}
+
+type subvolInfo struct {
+	// Transaction in which the subvolume was created. The inodes of the
+	// snapshot of a parent have a transid lower or equal to it.
+	otransid   uint64
+	parentUuid []byte
+}
+
+// parseRootItem reads the info of the subvolume id from its root item
+func parseRootItem(id uint64, data []byte) (*subvolInfo, error) {
+	// Root items written by kernels older than 3.6 don't have the
+	// transids and uuids, their generation_v2 doesn't match
+	if len(data) < rootOtransidOffset+8 ||
+		binary.LittleEndian.Uint64(data[rootGenerationOffset:]) != binary.LittleEndian.Uint64(data[rootGenerationV2Offset:]) {
+		return nil, fmt.Errorf("The btrfs subvolume %d was created by a kernel older than 3.6", id)
+	}
+	return &subvolInfo{
+		otransid:   binary.LittleEndian.Uint64(data[rootOtransidOffset:]),
+		parentUuid: append([]byte{}, data[rootParentUuidOffset:rootParentUuidOffset+16]...),
+	}, nil
+}
+
+// uuidKey returns the objectid and the offset of the key of uuid in the
+// uuid tree
+func uuidKey(uuid []byte) (uint64, uint64) {
+	return binary.LittleEndian.Uint64(uuid[:8]), binary.LittleEndian.Uint64(uuid[8:])
+}
+
+// parseRootRef returns the name of a subvolume in its parent directory,
+// from its root backref, or "" if the item is invalid
+func parseRootRef(data []byte) string {
+	if len(data) < rootRefSize {
+		return ""
+	}
+	length := int(binary.LittleEndian.Uint16(data[16:]))
+	if rootRefSize+length > len(data) {
+		return ""
+	}
+	return string(data[rootRefSize : rootRefSize+length])
+}
+
+// parseInodeRefs returns the paths of the links of an inode in the
+// directory dir, from its inode ref item
+func parseInodeRefs(dir string, data []byte) []string {
+	var paths []string
+	for len(data) >= inodeRefSize {
+		length := int(binary.LittleEndian.Uint16(data[8:]))
+		if inodeRefSize+length > len(data) {
+			break
+		}
+		paths = append(paths, "/"+dir+string(data[inodeRefSize:inodeRefSize+length]))
+		data = data[inodeRefSize+length:]
+	}
+	return paths
+}
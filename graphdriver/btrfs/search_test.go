@@ -0,0 +1,209 @@
+// +build linux,amd64
+
+package btrfs
+
+import (
+	"encoding/binary"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// searchHeader encodes a struct btrfs_ioctl_search_header followed by data
+func searchHeader(objectid uint64, typ uint32, offset uint64, data []byte) []byte {
+	buf := make([]byte, searchHeaderSize+len(data))
+	binary.LittleEndian.PutUint64(buf[0:], 42) // transid
+	binary.LittleEndian.PutUint64(buf[8:], objectid)
+	binary.LittleEndian.PutUint64(buf[16:], offset)
+	binary.LittleEndian.PutUint32(buf[24:], typ)
+	binary.LittleEndian.PutUint32(buf[28:], uint32(len(data)))
+	copy(buf[searchHeaderSize:], data)
+	return buf
+}
+
+func TestSearchKeyEncode(t *testing.T) {
+	key := searchKey{
+		tree:        5,
+		minObjectid: firstFreeObjectid,
+		maxObjectid: math.MaxUint64,
+		minType:     inodeItemKey,
+		maxType:     inodeRefKey,
+		minOffset:   7,
+		maxOffset:   math.MaxUint64,
+		minTransid:  100,
+	}
+	buf := make([]byte, searchKeySize)
+	for i := range buf {
+		buf[i] = 0xff
+	}
+	key.encode(buf)
+
+	le := binary.LittleEndian
+	for _, field := range []struct {
+		offset   int
+		expected uint64
+	}{
+		{0, 5},                 // tree_id
+		{8, firstFreeObjectid}, // min_objectid
+		{16, math.MaxUint64},   // max_objectid
+		{24, 7},                // min_offset
+		{32, math.MaxUint64},   // max_offset
+		{40, 100},              // min_transid
+		{48, math.MaxUint64},   // max_transid
+	} {
+		if value := le.Uint64(buf[field.offset:]); value != field.expected {
+			t.Errorf("Expected %d at offset %d, got %d", field.expected, field.offset, value)
+		}
+	}
+	if value := le.Uint32(buf[56:]); value != inodeItemKey {
+		t.Errorf("Expected min_type %d, got %d", inodeItemKey, value)
+	}
+	if value := le.Uint32(buf[60:]); value != inodeRefKey {
+		t.Errorf("Expected max_type %d, got %d", inodeRefKey, value)
+	}
+	if value := le.Uint32(buf[searchNrItemsOffs:]); value != searchMaxItems {
+		t.Errorf("Expected nr_items %d, got %d", searchMaxItems, value)
+	}
+	for i := searchNrItemsOffs + 4; i < searchKeySize; i++ {
+		if buf[i] != 0 {
+			t.Fatalf("Expected the unused fields to be zeroed, got %x at offset %d", buf[i], i)
+		}
+	}
+}
+
+func TestSearchKeyNext(t *testing.T) {
+	key := searchKey{maxObjectid: 300}
+
+	if !key.next(searchItem{objectid: 257, typ: inodeRefKey, offset: 10}) {
+		t.Fatal("Expected more keys after an offset")
+	}
+	if key.minObjectid != 257 || key.minType != inodeRefKey || key.minOffset != 11 {
+		t.Fatalf("Expected the next offset, got %d %d %d", key.minObjectid, key.minType, key.minOffset)
+	}
+
+	if !key.next(searchItem{objectid: 257, typ: inodeRefKey, offset: math.MaxUint64}) {
+		t.Fatal("Expected more keys after the last offset")
+	}
+	if key.minObjectid != 257 || key.minType != inodeRefKey+1 || key.minOffset != 0 {
+		t.Fatalf("Expected the next type, got %d %d %d", key.minObjectid, key.minType, key.minOffset)
+	}
+
+	if !key.next(searchItem{objectid: 257, typ: math.MaxUint8, offset: math.MaxUint64}) {
+		t.Fatal("Expected more keys after the last type")
+	}
+	if key.minObjectid != 258 || key.minType != 0 || key.minOffset != 0 {
+		t.Fatalf("Expected the next objectid, got %d %d %d", key.minObjectid, key.minType, key.minOffset)
+	}
+
+	if key.next(searchItem{objectid: 300, typ: math.MaxUint8, offset: math.MaxUint64}) {
+		t.Fatal("Expected no more keys after the last key")
+	}
+}
+
+func TestDecodeSearchItems(t *testing.T) {
+	var buf []byte
+	buf = append(buf, searchHeader(256, inodeItemKey, 0, []byte("inode"))...)
+	buf = append(buf, searchHeader(257, inodeRefKey, 256, nil)...)
+	buf = append(buf, searchHeader(258, rootItemKey, 3, []byte("root"))...)
+	// The rest of the buffer of the search is garbage
+	buf = append(buf, make([]byte, 64)...)
+
+	items, err := decodeSearchItems(buf, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []searchItem{
+		{objectid: 256, typ: inodeItemKey, offset: 0, data: []byte("inode")},
+		{objectid: 257, typ: inodeRefKey, offset: 256, data: []byte{}},
+		{objectid: 258, typ: rootItemKey, offset: 3, data: []byte("root")},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Fatalf("Expected %v, got %v", expected, items)
+	}
+
+	if _, err := decodeSearchItems(buf[:searchHeaderSize+2], 1); err == nil {
+		t.Fatal("Expected an error for a truncated item")
+	}
+	if _, err := decodeSearchItems(buf[:searchHeaderSize+5+10], 2); err == nil {
+		t.Fatal("Expected an error for a truncated header")
+	}
+}
+
+func TestParseRootItem(t *testing.T) {
+	data := make([]byte, rootOtransidOffset+8+32)
+	binary.LittleEndian.PutUint64(data[rootGenerationOffset:], 12)
+	binary.LittleEndian.PutUint64(data[rootGenerationV2Offset:], 12)
+	for i := 0; i < 16; i++ {
+		data[rootParentUuidOffset+i] = byte(i + 1)
+	}
+	binary.LittleEndian.PutUint64(data[rootOtransidOffset:], 10)
+
+	info, err := parseRootItem(258, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.otransid != 10 {
+		t.Fatalf("Expected otransid 10, got %d", info.otransid)
+	}
+	if expected := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}; !reflect.DeepEqual(info.parentUuid, expected) {
+		t.Fatalf("Expected parent uuid %v, got %v", expected, info.parentUuid)
+	}
+	// The info doesn't point into the buffer of the search
+	data[rootParentUuidOffset] = 0
+	if info.parentUuid[0] != 1 {
+		t.Fatal("Expected the parent uuid to be copied")
+	}
+
+	// Written by a kernel older than 3.6
+	binary.LittleEndian.PutUint64(data[rootGenerationV2Offset:], 0)
+	if _, err := parseRootItem(258, data); err == nil {
+		t.Fatal("Expected an error for a root item without generation_v2")
+	}
+	if _, err := parseRootItem(258, data[:rootGenerationV2Offset]); err == nil {
+		t.Fatal("Expected an error for a short root item")
+	}
+}
+
+func TestUuidKey(t *testing.T) {
+	uuid := []byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0x80}
+	objectid, offset := uuidKey(uuid)
+	if objectid != 1 || offset != 0x8000000000000002 {
+		t.Fatalf("Expected the key 1 0x8000000000000002, got %d %#x", objectid, offset)
+	}
+}
+
+func TestParseRootRef(t *testing.T) {
+	data := make([]byte, rootRefSize)
+	binary.LittleEndian.PutUint16(data[16:], 3)
+	data = append(data, "foo"...)
+	if name := parseRootRef(data); name != "foo" {
+		t.Fatalf("Expected foo, got %s", name)
+	}
+	if name := parseRootRef(data[:rootRefSize+2]); name != "" {
+		t.Fatalf("Expected no name for a truncated ref, got %s", name)
+	}
+	if name := parseRootRef(data[:10]); name != "" {
+		t.Fatalf("Expected no name for a short ref, got %s", name)
+	}
+}
+
+func TestParseInodeRefs(t *testing.T) {
+	var data []byte
+	for _, name := range []string{"foo", "bar.txt"} {
+		ref := make([]byte, inodeRefSize)
+		binary.LittleEndian.PutUint16(ref[8:], uint16(len(name)))
+		data = append(data, ref...)
+		data = append(data, name...)
+	}
+
+	if paths, expected := parseInodeRefs("dir/", data), []string{"/dir/foo", "/dir/bar.txt"}; !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected %v, got %v", expected, paths)
+	}
+	if paths, expected := parseInodeRefs("", data), []string{"/foo", "/bar.txt"}; !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected %v, got %v", expected, paths)
+	}
+	// A truncated link is ignored
+	if paths, expected := parseInodeRefs("", data[:len(data)-1]), []string{"/foo"}; !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("Expected %v, got %v", expected, paths)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/dotcloud/docker/graphdriver"
 	"github.com/dotcloud/docker/utils"
 	"io"
 	"io/ioutil"
@@ -43,6 +44,9 @@ type DevInfo struct {
 
 type MetaData struct {
 	Devices map[string]*DevInfo `json:devices`
+	// Filesystem of the base device, the snapshots share it. Empty for
+	// the sets created before it was configurable, which use ext4.
+	Filesystem string `json:"filesystem,omitempty"`
 }
 
 type DeviceSet struct {
@@ -54,6 +58,21 @@ type DeviceSet struct {
 	NewTransactionId uint64
 	nextFreeDevice   int
 	sawBusy          bool
+
+	// Options
+	dataLoopbackSize     int64
+	metaDataLoopbackSize int64
+	baseFsSize           uint64
+	filesystem           string // of a new base device, ext4 when empty
+	dataDevice           string // block device for the data of the pool
+	metadataDevice       string // block device for the metadata of the pool
+	thinPoolDevice       string // existing thin pool, such as an LVM one
+	doBlkDiscard         bool
+	fstrimInterval       time.Duration
+
+	// Bytes of the pool freed by deletes and trims since the start
+	reclaimed uint64
+	stopTrim  chan struct{}
 }
 
 type DiskUsage struct {
@@ -68,6 +87,7 @@ type Status struct {
 	Data             DiskUsage
 	Metadata         DiskUsage
 	SectorSize       uint64
+	Reclaimed        uint64
 }
 
 type DevStatus struct {
@@ -112,6 +132,9 @@ func (devices *DeviceSet) jsonFile() string {
 }
 
 func (devices *DeviceSet) getPoolName() string {
+	if devices.thinPoolDevice != "" {
+		return devices.thinPoolDevice
+	}
 	return devices.devicePrefix + "-pool"
 }
 
@@ -243,9 +266,15 @@ func (devices *DeviceSet) activateDeviceIfNeeded(hash string) error {
 func (devices *DeviceSet) createFilesystem(info *DevInfo) error {
 	devname := info.DevName()
 
-	err := execRun("mkfs.ext4", "-E", "discard,lazy_itable_init=0,lazy_journal_init=0", devname)
-	if err != nil {
-		err = execRun("mkfs.ext4", "-E", "discard,lazy_itable_init=0", devname)
+	var err error
+	switch devices.Filesystem {
+	case "xfs":
+		err = execRun("mkfs.xfs", devname)
+	default:
+		err = execRun("mkfs.ext4", "-E", "discard,lazy_itable_init=0,lazy_journal_init=0", devname)
+		if err != nil {
+			err = execRun("mkfs.ext4", "-E", "discard,lazy_itable_init=0", devname)
+		}
 	}
 	if err != nil {
 		utils.Debugf("\n--->Err: %s\n", err)
@@ -297,6 +326,12 @@ func (devices *DeviceSet) loadMetaData() error {
 			delete(devices.Devices, hash)
 		}
 	}
+
+	// A base device without filesystem in the metadata was created with
+	// ext4. Without base device, the filesystem is the one of the options.
+	if devices.Filesystem == "" && devices.Devices[""] != nil {
+		devices.Filesystem = "ext4"
+	}
 	return nil
 }
 
@@ -324,8 +359,12 @@ func (devices *DeviceSet) setupBaseImage() error {
 		return err
 	}
 
-	utils.Debugf("Registering base device (id %v) with FS size %v", id, DefaultBaseFsSize)
-	info, err := devices.registerDevice(id, "", DefaultBaseFsSize)
+	utils.Debugf("Registering base device (id %v) with FS size %v", id, devices.baseFsSize)
+	devices.Filesystem = devices.filesystem
+	if devices.Filesystem == "" {
+		devices.Filesystem = "ext4"
+	}
+	info, err := devices.registerDevice(id, "", devices.baseFsSize)
 	if err != nil {
 		_ = deleteDevice(devices.getPoolDevName(), id)
 		utils.Debugf("\n--->Err: %s\n", err)
@@ -389,6 +428,10 @@ func minor(device uint64) uint64 {
 }
 
 func (devices *DeviceSet) ResizePool(size int64) error {
+	if devices.dataDevice != "" || devices.thinPoolDevice != "" {
+		return fmt.Errorf("Only the pools on loopback files can be resized")
+	}
+
 	dirname := devices.loopbackDir()
 	datafilename := path.Join(dirname, "data")
 	metadatafilename := path.Join(dirname, "metadata")
@@ -457,30 +500,41 @@ func (devices *DeviceSet) ResizePool(size int64) error {
 func (devices *DeviceSet) initDevmapper(doInit bool) error {
 	logInit(devices)
 
-	// Make sure the sparse images exist in <root>/devicemapper/data and
-	// <root>/devicemapper/metadata
+	if err := osMkdirAll(devices.loopbackDir(), 0700); err != nil && !osIsExist(err) {
+		return err
+	}
 
-	hasData := devices.hasImage("data")
-	hasMetadata := devices.hasImage("metadata")
+	// The metadata of the pool is new when its loopback files are created,
+	// the block devices and the thin pools given as options may be reused
+	createdLoopback := false
+	var data, metadata string
+	if devices.dataDevice == "" && devices.thinPoolDevice == "" {
+		// Make sure the sparse images exist in <root>/devicemapper/data and
+		// <root>/devicemapper/metadata
 
-	if !doInit && !hasData {
-		return errors.New("Loopback data file not found")
-	}
+		hasData := devices.hasImage("data")
+		hasMetadata := devices.hasImage("metadata")
 
-	if !doInit && !hasMetadata {
-		return errors.New("Loopback metadata file not found")
-	}
+		if !doInit && !hasData {
+			return errors.New("Loopback data file not found")
+		}
 
-	createdLoopback := !hasData || !hasMetadata
-	data, err := devices.ensureImage("data", DefaultDataLoopbackSize)
-	if err != nil {
-		utils.Debugf("Error device ensureImage (data): %s\n", err)
-		return err
-	}
-	metadata, err := devices.ensureImage("metadata", DefaultMetaDataLoopbackSize)
-	if err != nil {
-		utils.Debugf("Error device ensureImage (metadata): %s\n", err)
-		return err
+		if !doInit && !hasMetadata {
+			return errors.New("Loopback metadata file not found")
+		}
+
+		createdLoopback = !hasData || !hasMetadata
+		var err error
+		data, err = devices.ensureImage("data", devices.dataLoopbackSize)
+		if err != nil {
+			utils.Debugf("Error device ensureImage (data): %s\n", err)
+			return err
+		}
+		metadata, err = devices.ensureImage("metadata", devices.metaDataLoopbackSize)
+		if err != nil {
+			utils.Debugf("Error device ensureImage (metadata): %s\n", err)
+			return err
+		}
 	}
 
 	// Set the device prefix from the device id and inode of the docker root dir
@@ -513,21 +567,33 @@ func (devices *DeviceSet) initDevmapper(doInit bool) error {
 	setCloseOnExec("/dev/mapper/control")
 
 	// If the pool doesn't exist, create it
+	if info.Exists == 0 && devices.thinPoolDevice != "" {
+		return fmt.Errorf("Thin pool %s not found", devices.thinPoolDevice)
+	}
 	if info.Exists == 0 {
 		utils.Debugf("Pool doesn't exist. Creating it.")
 
-		dataFile, err := attachLoopDevice(data)
-		if err != nil {
-			utils.Debugf("\n--->Err: %s\n", err)
-			return err
+		var dataFile, metadataFile *osFile
+		if devices.dataDevice != "" {
+			if dataFile, err = osOpenFile(devices.dataDevice, osORdWr, 0); err != nil {
+				return fmt.Errorf("Error opening data device %s: %s", devices.dataDevice, err)
+			}
+			if metadataFile, err = osOpenFile(devices.metadataDevice, osORdWr, 0); err != nil {
+				dataFile.Close()
+				return fmt.Errorf("Error opening metadata device %s: %s", devices.metadataDevice, err)
+			}
+		} else {
+			if dataFile, err = attachLoopDevice(data); err != nil {
+				utils.Debugf("\n--->Err: %s\n", err)
+				return err
+			}
+			if metadataFile, err = attachLoopDevice(metadata); err != nil {
+				dataFile.Close()
+				utils.Debugf("\n--->Err: %s\n", err)
+				return err
+			}
 		}
 		defer dataFile.Close()
-
-		metadataFile, err := attachLoopDevice(metadata)
-		if err != nil {
-			utils.Debugf("\n--->Err: %s\n", err)
-			return err
-		}
 		defer metadataFile.Close()
 
 		if err := createPool(devices.getPoolName(), dataFile, metadataFile); err != nil {
@@ -545,6 +611,10 @@ func (devices *DeviceSet) initDevmapper(doInit bool) error {
 		}
 	}
 
+	if devices.filesystem != "" && devices.Filesystem != "" && devices.Filesystem != devices.filesystem {
+		return fmt.Errorf("The base device has a %s filesystem, remove all the images to use %s", devices.Filesystem, devices.filesystem)
+	}
+
 	// Setup the base image
 	if doInit {
 		if err := devices.setupBaseImage(); err != nil {
@@ -590,12 +660,17 @@ func (devices *DeviceSet) deleteDevice(hash string) error {
 		return fmt.Errorf("hash %s doesn't exists", hash)
 	}
 
+	usedBefore, _ := devices.dataUsed()
+
 	// This is a workaround for the kernel not discarding block so
 	// on the thin pool when we remove a thinp device, so we do it
-	// manually
-	if err := devices.activateDeviceIfNeeded(hash); err == nil {
-		if err := BlockDeviceDiscard(info.DevName()); err != nil {
-			utils.Debugf("Error discarding block on device: %s (ignoring)\n", err)
+	// manually. The discards go down to the loopback file, which
+	// deallocates the blocks.
+	if devices.doBlkDiscard {
+		if err := devices.activateDeviceIfNeeded(hash); err == nil {
+			if err := BlockDeviceDiscard(info.DevName()); err != nil {
+				utils.Debugf("Error discarding block on device: %s (ignoring)\n", err)
+			}
 		}
 	}
 
@@ -622,6 +697,7 @@ func (devices *DeviceSet) deleteDevice(hash string) error {
 
 	devices.allocateTransactionId()
 	delete(devices.Devices, info.Hash)
+	devices.addReclaimed(usedBefore)
 
 	if err := devices.saveMetadata(); err != nil {
 		devices.Devices[info.Hash] = info
@@ -769,6 +845,10 @@ func (devices *DeviceSet) Shutdown() error {
 	defer devices.Unlock()
 
 	utils.Debugf("[deviceset %s] shutdown()", devices.devicePrefix)
+	if devices.stopTrim != nil {
+		close(devices.stopTrim)
+		devices.stopTrim = nil
+	}
 	utils.Debugf("[devmapper] Shutting down DeviceSet: %s", devices.root)
 	defer utils.Debugf("[deviceset %s] shutdown END", devices.devicePrefix)
 
@@ -789,8 +869,9 @@ func (devices *DeviceSet) Shutdown() error {
 		}
 	}
 
+	// A thin pool given as option is managed by its owner
 	pool := devices.getPoolDevName()
-	if devinfo, err := getInfo(pool); err == nil && devinfo.Exists != 0 {
+	if devinfo, err := getInfo(pool); err == nil && devinfo.Exists != 0 && devices.thinPoolDevice == "" {
 		if err := devices.deactivateDevice("pool"); err != nil {
 			utils.Debugf("Shutdown deactivate %s , error: %s\n", pool, err)
 		}
@@ -828,9 +909,15 @@ func (devices *DeviceSet) MountDevice(hash, path string) error {
 
 	var flags uintptr = sysMsMgcVal
 
-	err := sysMount(info.DevName(), path, "ext4", flags, "discard")
+	// The snapshots of an xfs filesystem have the same uuid
+	options := ""
+	if devices.Filesystem == "xfs" {
+		options = "nouuid,"
+	}
+
+	err := sysMount(info.DevName(), path, devices.Filesystem, flags, options+"discard")
 	if err != nil && err == sysEInval {
-		err = sysMount(info.DevName(), path, "ext4", flags, "")
+		err = sysMount(info.DevName(), path, devices.Filesystem, flags, strings.TrimSuffix(options, ","))
 	}
 	if err != nil {
 		return fmt.Errorf("Error mounting '%s' on '%s': %s", info.DevName(), path, err)
@@ -1006,6 +1093,70 @@ func (devices *DeviceSet) poolStatus() (totalSizeInSectors, transactionId, dataU
 	return
 }
 
+// dataUsed returns the bytes allocated to the data of the pool: those of
+// the sparse loopback file, or the used blocks of the data device
+func (devices *DeviceSet) dataUsed() (uint64, error) {
+	if devices.dataDevice == "" && devices.thinPoolDevice == "" {
+		st, err := osStat(path.Join(devices.loopbackDir(), "data"))
+		if err != nil {
+			return 0, err
+		}
+		return uint64(toSysStatT(st.Sys()).Blocks) * 512, nil
+	}
+
+	totalSizeInSectors, _, dataUsed, dataTotal, _, _, err := devices.poolStatus()
+	if err != nil {
+		return 0, err
+	}
+	if dataTotal == 0 {
+		return 0, fmt.Errorf("Empty data device")
+	}
+	return dataUsed * (totalSizeInSectors / dataTotal) * 512, nil
+}
+
+// addReclaimed accounts the space freed in the pool since it used
+// usedBefore bytes
+func (devices *DeviceSet) addReclaimed(usedBefore uint64) {
+	if used, err := devices.dataUsed(); err == nil && used < usedBefore {
+		devices.reclaimed += usedBefore - used
+	}
+}
+
+// trimMounted discards the unused blocks of the mounted filesystems, so
+// that the blocks of the files deleted in containers go back to the pool
+func (devices *DeviceSet) trimMounted() {
+	devices.Lock()
+	defer devices.Unlock()
+
+	usedBefore, _ := devices.dataUsed()
+	for _, info := range devices.Devices {
+		if info.mountCount == 0 {
+			continue
+		}
+		f, err := osOpen(info.mountPath)
+		if err != nil {
+			utils.Debugf("Error opening %s to trim: %s\n", info.mountPath, err)
+			continue
+		}
+		if err := ioctlFiTrim(f.Fd()); err != nil {
+			utils.Debugf("Error trimming %s: %s\n", info.mountPath, err)
+		}
+		f.Close()
+	}
+	devices.addReclaimed(usedBefore)
+}
+
+func (devices *DeviceSet) trimLoop(interval time.Duration, stop chan struct{}) {
+	for {
+		select {
+		case <-time.After(interval):
+			devices.trimMounted()
+		case <-stop:
+			return
+		}
+	}
+}
+
 func (devices *DeviceSet) Status() *Status {
 	devices.Lock()
 	defer devices.Unlock()
@@ -1013,8 +1164,14 @@ func (devices *DeviceSet) Status() *Status {
 	status := &Status{}
 
 	status.PoolName = devices.getPoolName()
-	status.DataLoopback = path.Join(devices.loopbackDir(), "data")
-	status.MetadataLoopback = path.Join(devices.loopbackDir(), "metadata")
+	switch {
+	case devices.dataDevice != "":
+		status.DataLoopback = devices.dataDevice
+		status.MetadataLoopback = devices.metadataDevice
+	case devices.thinPoolDevice == "":
+		status.DataLoopback = path.Join(devices.loopbackDir(), "data")
+		status.MetadataLoopback = path.Join(devices.loopbackDir(), "metadata")
+	}
 
 	totalSizeInSectors, _, dataUsed, dataTotal, metadataUsed, metadataTotal, err := devices.poolStatus()
 	if err == nil {
@@ -1030,21 +1187,88 @@ func (devices *DeviceSet) Status() *Status {
 
 		status.SectorSize = blockSizeInSectors * 512
 	}
+	status.Reclaimed = devices.reclaimed
 
 	return status
 }
 
-func NewDeviceSet(root string, doInit bool) (*DeviceSet, error) {
+func NewDeviceSet(root string, doInit bool, options []string) (*DeviceSet, error) {
 	SetDevDir("/dev")
 
 	devices := &DeviceSet{
-		root:     root,
-		MetaData: MetaData{Devices: make(map[string]*DevInfo)},
+		root:                 root,
+		MetaData:             MetaData{Devices: make(map[string]*DevInfo)},
+		dataLoopbackSize:     DefaultDataLoopbackSize,
+		metaDataLoopbackSize: DefaultMetaDataLoopbackSize,
+		baseFsSize:           DefaultBaseFsSize,
+		doBlkDiscard:         true,
+	}
+	if err := devices.parseOptions(options); err != nil {
+		return nil, err
 	}
 
 	if err := devices.initDevmapper(doInit); err != nil {
 		return nil, err
 	}
 
+	if doInit && devices.fstrimInterval > 0 {
+		devices.stopTrim = make(chan struct{})
+		go devices.trimLoop(devices.fstrimInterval, devices.stopTrim)
+	}
+
 	return devices, nil
 }
+
+// parseOptions reads the dm.* storage options of the daemon
+func (devices *DeviceSet) parseOptions(options []string) error {
+	opts, err := graphdriver.DriverOptions("dm", options)
+	if err != nil {
+		return err
+	}
+	for key, val := range opts {
+		switch key {
+		case "basesize", "loopdatasize", "loopmetadatasize":
+			size, err := utils.RAMInBytes(val)
+			if err != nil {
+				return fmt.Errorf("Invalid dm.%s %s: %s", key, val, err)
+			}
+			switch key {
+			case "basesize":
+				devices.baseFsSize = uint64(size)
+			case "loopdatasize":
+				devices.dataLoopbackSize = size
+			case "loopmetadatasize":
+				devices.metaDataLoopbackSize = size
+			}
+		case "fs":
+			if val != "ext4" && val != "xfs" {
+				return fmt.Errorf("Unsupported filesystem %s, expected ext4 or xfs", val)
+			}
+			devices.filesystem = val
+		case "datadev":
+			devices.dataDevice = val
+		case "metadatadev":
+			devices.metadataDevice = val
+		case "thinpooldev":
+			devices.thinPoolDevice = strings.TrimPrefix(val, "/dev/mapper/")
+		case "blkdiscard":
+			if devices.doBlkDiscard, err = strconv.ParseBool(val); err != nil {
+				return fmt.Errorf("Invalid dm.blkdiscard %s: %s", val, err)
+			}
+		case "fstriminterval":
+			if devices.fstrimInterval, err = time.ParseDuration(val); err != nil {
+				return fmt.Errorf("Invalid dm.fstriminterval %s: %s", val, err)
+			}
+		default:
+			return fmt.Errorf("Unknown option dm.%s", key)
+		}
+	}
+
+	if (devices.dataDevice == "") != (devices.metadataDevice == "") {
+		return fmt.Errorf("dm.datadev and dm.metadatadev must be given together")
+	}
+	if devices.dataDevice != "" && devices.thinPoolDevice != "" {
+		return fmt.Errorf("dm.thinpooldev can't be used with dm.datadev")
+	}
+	return nil
+}
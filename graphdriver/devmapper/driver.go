@@ -24,8 +24,8 @@ type Driver struct {
 	home string
 }
 
-var Init = func(home string) (graphdriver.Driver, error) {
-	deviceSet, err := NewDeviceSet(home, true)
+var Init = func(home string, options []string) (graphdriver.Driver, error) {
+	deviceSet, err := NewDeviceSet(home, true, options)
 	if err != nil {
 		return nil, err
 	}
@@ -45,13 +45,17 @@ func (d *Driver) Status() [][2]string {
 
 	status := [][2]string{
 		{"Pool Name", s.PoolName},
-		{"Data file", s.DataLoopback},
-		{"Metadata file", s.MetadataLoopback},
+	}
+	if s.DataLoopback != "" {
+		status = append(status, [2]string{"Data file", s.DataLoopback}, [2]string{"Metadata file", s.MetadataLoopback})
+	}
+	status = append(status, [][2]string{
 		{"Data Space Used", fmt.Sprintf("%.1f Mb", float64(s.Data.Used)/(1024*1024))},
 		{"Data Space Total", fmt.Sprintf("%.1f Mb", float64(s.Data.Total)/(1024*1024))},
 		{"Metadata Space Used", fmt.Sprintf("%.1f Mb", float64(s.Metadata.Used)/(1024*1024))},
 		{"Metadata Space Total", fmt.Sprintf("%.1f Mb", float64(s.Metadata.Total)/(1024*1024))},
-	}
+		{"Data Space Reclaimed", fmt.Sprintf("%.1f Mb", float64(s.Reclaimed)/(1024*1024))},
+	}...)
 	return status
 }
 
@@ -121,6 +125,17 @@ func (d *Driver) mount(id, mountPoint string) error {
 	return d.DeviceSet.MountDevice(id, mountPoint)
 }
 
+// List returns the layers of the driver, without the base device
+func (d *Driver) List() ([]string, error) {
+	var ids []string
+	for _, hash := range d.DeviceSet.List() {
+		if hash != "" {
+			ids = append(ids, hash)
+		}
+	}
+	return ids, nil
+}
+
 func (d *Driver) Exists(id string) bool {
 	return d.Devices[id] != nil
 }
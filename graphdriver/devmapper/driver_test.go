@@ -120,7 +120,7 @@ func mkTestDirectory(t *testing.T) string {
 
 func newDriver(t *testing.T) *Driver {
 	home := mkTestDirectory(t)
-	d, err := Init(home)
+	d, err := Init(home, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -284,7 +284,7 @@ func TestInit(t *testing.T) {
 			}
 			return nil
 		}
-		driver, err := Init(home)
+		driver, err := Init(home, nil)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -320,9 +320,9 @@ func TestInit(t *testing.T) {
 	taskMessages.Assert(t, "create_thin 0", "set_transaction_id 0 1")
 }
 
-func fakeInit() func(home string) (graphdriver.Driver, error) {
+func fakeInit() func(home string, options []string) (graphdriver.Driver, error) {
 	oldInit := Init
-	Init = func(home string) (graphdriver.Driver, error) {
+	Init = func(home string, options []string) (graphdriver.Driver, error) {
 		return &Driver{
 			home: home,
 		}, nil
@@ -330,7 +330,7 @@ func fakeInit() func(home string) (graphdriver.Driver, error) {
 	return oldInit
 }
 
-func restoreInit(init func(home string) (graphdriver.Driver, error)) {
+func restoreInit(init func(home string, options []string) (graphdriver.Driver, error)) {
 	Init = init
 }
 
@@ -773,7 +773,7 @@ func TestInitCleanedDriver(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	driver, err := Init(d.home)
+	driver, err := Init(d.home, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -877,3 +877,110 @@ func assertMap(t *testing.T, m map[string]bool, keys ...string) {
 		t.Fatalf("Unexpected keys: %v", m)
 	}
 }
+
+func TestParseOptions(t *testing.T) {
+	devices := &DeviceSet{}
+	options := []string{
+		"dm.basesize=20G",
+		"dm.fs=xfs",
+		"dm.thinpooldev=/dev/mapper/vg-docker--pool",
+		"btrfs.quota=1G",
+	}
+	if err := devices.parseOptions(options); err != nil {
+		t.Fatal(err)
+	}
+	if devices.baseFsSize != 20*1024*1024*1024 {
+		t.Fatalf("Expected a base size of 20G, got %d", devices.baseFsSize)
+	}
+	if devices.filesystem != "xfs" {
+		t.Fatalf("Expected xfs, got %s", devices.filesystem)
+	}
+	if devices.getPoolName() != "vg-docker--pool" {
+		t.Fatalf("Expected the pool vg-docker--pool, got %s", devices.getPoolName())
+	}
+
+	for _, invalid := range [][]string{
+		{"dm.fs=btrfs"},
+		{"dm.unknown=1"},
+		{"dm.basesize=big"},
+		{"dm.datadev=/dev/sdb"},
+		{"dm.datadev=/dev/sdb", "dm.metadatadev=/dev/sdc", "dm.thinpooldev=pool"},
+	} {
+		if err := (&DeviceSet{}).parseOptions(invalid); err == nil {
+			t.Fatalf("Expected an error for %v", invalid)
+		}
+	}
+}
+
+func TestInitThinPoolFilesystem(t *testing.T) {
+	denyAllDevmapper()
+	denyAllSyscall()
+	defer denyAllSyscall()
+	defer denyAllDevmapper()
+
+	calls := make(Set)
+	mockAllDevmapper(calls)
+	// The thin pool exists, and keeps the transaction id set by the driver
+	var transactionId uint64
+	DmTaskGetInfo = func(task *CDmTask, info *Info) int {
+		info.Exists = 1
+		return 1
+	}
+	DmTaskSetMessage = func(task *CDmTask, message string) int {
+		var oldId uint64
+		fmt.Sscanf(message, "set_transaction_id %d %d", &oldId, &transactionId)
+		return 1
+	}
+	DmGetNextTarget = func(task *CDmTask, next uintptr, start, length *uint64, target, params *string) uintptr {
+		*target, *params = "thin-pool", fmt.Sprintf("%d 0/1024 0/1024 - rw", transactionId)
+		return 0
+	}
+	var mkfs []string
+	execRun = func(name string, args ...string) error {
+		mkfs = append(mkfs, name)
+		return nil
+	}
+	options := []string{"dm.thinpooldev=/dev/mapper/vg-docker--pool", "dm.fs=xfs"}
+	home := mkTestDirectory(t)
+	defer osRemoveAll(home)
+
+	func() {
+		// A fresh setup creates the base device with the filesystem of
+		// the options
+		d, err := Init(home, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if devices := d.(*Driver).DeviceSet; devices.Filesystem != "xfs" {
+			t.Fatalf("Expected an xfs base device, got %s", devices.Filesystem)
+		}
+		if len(mkfs) != 1 || mkfs[0] != "mkfs.xfs" {
+			t.Fatalf("Expected mkfs.xfs to be executed, got %v", mkfs)
+		}
+
+		// The filesystem is kept in the metadata
+		mkfs = nil
+		d, err = Init(home, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if devices := d.(*Driver).DeviceSet; devices.Filesystem != "xfs" {
+			t.Fatalf("Expected an xfs base device, got %s", devices.Filesystem)
+		}
+		if len(mkfs) != 0 {
+			t.Fatalf("Expected the base device to be reused, got %v", mkfs)
+		}
+
+		// A base device created before the filesystem was configurable
+		// is ext4
+		jsonData := []byte(`{"Devices":{"":{"device_id":0,"size":10737418240,"transaction_id":1,"initialized":true}}}`)
+		if err := ioutil.WriteFile(path.Join(home, "devicemapper", "json"), jsonData, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Init(home, options); err == nil {
+			t.Fatal("Expected an error for the xfs option with an ext4 base device")
+		}
+	}()
+
+	runtime.GC()
+}
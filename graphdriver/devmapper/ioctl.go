@@ -59,6 +59,17 @@ func ioctlBlkGetSize64(fd uintptr) (int64, error) {
 	return size, nil
 }
 
+// ioctlFiTrim discards the unused blocks of the filesystem of fd
+func ioctlFiTrim(fd uintptr) error {
+	// struct fstrim_range: start, len and minlen
+	r := [3]uint64{0, ^uint64(0), 0}
+
+	if _, _, err := sysSyscall(sysSysIoctl, fd, FiTrim, uintptr(unsafe.Pointer(&r[0]))); err != 0 {
+		return err
+	}
+	return nil
+}
+
 func ioctlBlkDiscard(fd uintptr, offset, length uint64) error {
 	var r [2]uint64
 	r[0] = offset
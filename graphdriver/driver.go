@@ -6,9 +6,10 @@ import (
 	"github.com/dotcloud/docker/utils"
 	"os"
 	"path"
+	"strings"
 )
 
-type InitFunc func(root string) (Driver, error)
+type InitFunc func(root string, options []string) (Driver, error)
 
 type Driver interface {
 	String() string
@@ -32,6 +33,12 @@ type Differ interface {
 	DiffSize(id string) (bytes int64, err error)
 }
 
+// Lister is implemented by the drivers which can list their layers, to
+// find the layers which belong to no image or container
+type Lister interface {
+	List() ([]string, error)
+}
+
 var (
 	DefaultDriver string
 	// All registred drivers
@@ -39,10 +46,12 @@ var (
 	// Slice of drivers that should be used in an order
 	priority = []string{
 		"aufs",
+		// only when the root of docker is on zfs or btrfs
+		"zfs",
+		"btrfs",
+		"overlay",
 		"devicemapper",
 		"vfs",
-		// experimental, has to be enabled manually for now
-		"btrfs",
 	}
 )
 
@@ -59,23 +68,54 @@ func Register(name string, initFunc InitFunc) error {
 	return nil
 }
 
-func GetDriver(name, home string) (Driver, error) {
+// DriverOptions returns the options of the driver name, given as
+// name.key=value, in a map of key to value. Options of other drivers
+// are ignored.
+func DriverOptions(name string, options []string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, option := range options {
+		parts := strings.SplitN(option, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid storage option %s, expected driver.key=value", option)
+		}
+		if !strings.HasPrefix(parts[0], name+".") {
+			continue
+		}
+		m[strings.TrimPrefix(parts[0], name+".")] = parts[1]
+	}
+	return m, nil
+}
+
+func GetDriver(name, home string, options []string) (Driver, error) {
 	if initFunc, exists := drivers[name]; exists {
-		return initFunc(path.Join(home, name))
+		return initFunc(path.Join(home, name), options)
 	}
 	return nil, fmt.Errorf("No such driver: %s", name)
 }
 
-func New(root string) (driver Driver, err error) {
+func New(root string, options []string) (driver Driver, err error) {
 	for _, name := range []string{os.Getenv("DOCKER_DRIVER"), DefaultDriver} {
 		if name != "" {
-			return GetDriver(name, root)
+			return GetDriver(name, root, options)
 		}
 	}
 
+	// Keep the driver which already stores images in root, so that a
+	// driver added before it in the priority list doesn't hide them
+	for _, name := range priority {
+		if _, err := os.Stat(path.Join(root, name)); err != nil {
+			continue
+		}
+		if driver, err = GetDriver(name, root, options); err != nil {
+			utils.Debugf("Error loading driver %s: %s", name, err)
+			continue
+		}
+		return driver, nil
+	}
+
 	// Check for priority drivers first
 	for _, name := range priority {
-		if driver, err = GetDriver(name, root); err != nil {
+		if driver, err = GetDriver(name, root, options); err != nil {
 			utils.Debugf("Error loading driver %s: %s", name, err)
 			continue
 		}
@@ -84,7 +124,7 @@ func New(root string) (driver Driver, err error) {
 
 	// Check all registered drivers if no priority driver is found
 	for _, initFunc := range drivers {
-		if driver, err = initFunc(root); err != nil {
+		if driver, err = initFunc(root, options); err != nil {
 			continue
 		}
 		return driver, nil
@@ -0,0 +1,213 @@
+package overlay
+
+import (
+	"github.com/dotcloud/docker/archive"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Names of the aufs metadata in a layer
+const (
+	whiteoutPrefix       = ".wh."
+	whiteoutMetaPrefix   = whiteoutPrefix + whiteoutPrefix
+	whiteoutOpaqueMarker = whiteoutMetaPrefix + ".opq"
+)
+
+// changes walks the diff dir of id. A whiteout is a deleted file. An
+// opaque directory deletes every entry of the parent layers it hides, as
+// there are no opaque directories in the changes of ExportChanges.
+func (d *Driver) changes(id string) ([]archive.Change, error) {
+	diffDir := filepath.Join(d.dir(id), "diff")
+	layers, err := d.lowerPaths(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		changes []archive.Change
+		opaque  = make(map[string]bool)
+	)
+	err = filepath.Walk(diffDir, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Rebase path
+		name, err := filepath.Rel(diffDir, p)
+		if err != nil {
+			return err
+		}
+		name = filepath.Join("/", name)
+
+		// Skip root
+		if name == "/" {
+			return nil
+		}
+
+		if isWhiteout(f) {
+			changes = append(changes, archive.Change{Path: name, Kind: archive.ChangeDelete})
+			return nil
+		}
+
+		// Nothing of the parent layers is visible under an opaque directory
+		var lower os.FileInfo
+		if !opaque[filepath.Dir(name)] {
+			lower = lowerStat(layers, name)
+		}
+
+		change := archive.Change{Path: name, Kind: archive.ChangeAdd}
+		if lower != nil {
+			change.Kind = archive.ChangeModify
+		}
+
+		if !f.IsDir() {
+			changes = append(changes, change)
+			return nil
+		}
+
+		isOpaqueDir := opaque[filepath.Dir(name)] || isOpaque(p)
+		if isOpaqueDir {
+			opaque[name] = true
+		}
+
+		// If you modify /foo/bar/baz, then /foo and /foo/bar are copied
+		// up without being modified
+		if lower == nil || !lower.IsDir() || !sameDir(f, lower) {
+			changes = append(changes, change)
+		}
+
+		if isOpaqueDir && lower != nil && lower.IsDir() {
+			for _, entry := range lowerEntries(layers, name) {
+				if _, err := os.Lstat(filepath.Join(p, entry)); os.IsNotExist(err) {
+					changes = append(changes, archive.Change{Path: filepath.Join(name, entry), Kind: archive.ChangeDelete})
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return changes, nil
+}
+
+func sameDir(a, b os.FileInfo) bool {
+	return a.Mode() == b.Mode() && a.ModTime().Equal(b.ModTime())
+}
+
+// lowerStat returns the file info of name in the nearest of layers it
+// exists in, or nil when it doesn't exist there, was deleted or is hidden
+// by an opaque directory.
+func lowerStat(layers []string, name string) os.FileInfo {
+	var parts []string
+	if name != "/" {
+		parts = strings.Split(name[1:], "/")
+	}
+	for _, layer := range layers {
+		var (
+			p      = layer
+			fi     os.FileInfo
+			hidden bool // an opaque parent hides the following layers
+		)
+		for i, part := range parts {
+			var err error
+			p = filepath.Join(p, part)
+			if fi, err = os.Lstat(p); err != nil {
+				fi = nil
+				break
+			}
+			if isWhiteout(fi) {
+				return nil
+			}
+			if i < len(parts)-1 && fi.IsDir() && isOpaque(p) {
+				hidden = true
+			}
+		}
+		if fi != nil {
+			return fi
+		}
+		if hidden {
+			return nil
+		}
+	}
+	return nil
+}
+
+// lowerEntries returns the names in the directory dir of the parent
+// layers, as seen through overlay
+func lowerEntries(layers []string, dir string) []string {
+	var (
+		names []string
+		seen  = make(map[string]bool)
+	)
+	for _, layer := range layers {
+		p := filepath.Join(layer, dir)
+		files, err := ioutil.ReadDir(p)
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if seen[f.Name()] {
+				continue
+			}
+			seen[f.Name()] = true
+			if !isWhiteout(f) {
+				names = append(names, f.Name())
+			}
+		}
+		if isOpaque(p) {
+			break
+		}
+	}
+	return names
+}
+
+// convertWhiteouts replaces the .wh. entries of an unpacked layer in the
+// aufs format by whiteouts, and its opaque markers by opaque directories
+func convertWhiteouts(dir string) error {
+	var markers []string
+	err := filepath.Walk(dir, func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(f.Name(), whiteoutPrefix) {
+			markers = append(markers, p)
+			if f.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range markers {
+		var (
+			parent = filepath.Dir(p)
+			base   = filepath.Base(p)
+		)
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		switch {
+		case base == whiteoutOpaqueMarker:
+			if err := setOpaque(parent); err != nil {
+				return err
+			}
+		case strings.HasPrefix(base, whiteoutMetaPrefix):
+			// Other aufs metadata, such as hard links, are useless here
+		default:
+			original := filepath.Join(parent, base[len(whiteoutPrefix):])
+			if err := os.RemoveAll(original); err != nil {
+				return err
+			}
+			if err := createWhiteout(original); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,363 @@
+/*
+
+overlay driver directory structure
+
+.
+├── l             // Short links to the diff dirs, used in the mount options
+│   ├── A -> ../1/diff
+│   └── B -> ../2/diff
+├── 1             // A layer without parent, used as is
+│   ├── diff      // Content of the layer
+│   └── link      // Name of the short link of the layer
+└── 2             // A layer with parents, mounted with overlay
+    ├── diff      // Content of the layer, the upper dir of the mount
+    ├── link
+    ├── lower     // Short links of all the parents, nearest first
+    ├── merged    // Mount point
+    └── work      // Work dir of the mount
+
+*/
+
+package overlay
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/graphdriver"
+	mountpk "github.com/dotcloud/docker/pkg/mount"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+)
+
+const (
+	linkDir = "l"
+	// Short enough for the mount options of the deepest image to fit in a
+	// page, which is the limit of the kernel
+	linkLength = 26
+)
+
+func init() {
+	graphdriver.Register("overlay", Init)
+}
+
+type Driver struct {
+	home       string
+	sync.Mutex // Protects concurrent modification to active
+	active     map[string]int
+}
+
+// Init returns a new overlay driver.
+// An error is returned if overlay is not supported.
+func Init(home string, options []string) (graphdriver.Driver, error) {
+	if err := supportsOverlay(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path.Join(home, linkDir), 0700); err != nil {
+		return nil, err
+	}
+	return &Driver{
+		home:   home,
+		active: make(map[string]int),
+	}, nil
+}
+
+// Return a nil error if the kernel supports overlay
+func supportsOverlay() error {
+	// overlay might be built as a module which is not loaded yet
+	exec.Command("modprobe", "overlay").Run()
+
+	f, err := os.Open("/proc/filesystems")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.HasSuffix(s.Text(), "\toverlay") {
+			return nil
+		}
+	}
+	return fmt.Errorf("overlay was not found in /proc/filesystems")
+}
+
+func (d *Driver) String() string {
+	return "overlay"
+}
+
+func (d *Driver) Status() [][2]string {
+	ids, _ := d.ids()
+	return [][2]string{
+		{"Root Dir", d.home},
+		{"Dirs", fmt.Sprintf("%d", len(ids))},
+	}
+}
+
+func (d *Driver) dir(id string) string {
+	return path.Join(d.home, id)
+}
+
+func (d *Driver) Exists(id string) bool {
+	_, err := os.Stat(d.dir(id))
+	return err == nil
+}
+
+func (d *Driver) Create(id, parent string) (err error) {
+	dir := d.dir(id)
+	if err := os.Mkdir(dir, 0700); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			d.Remove(id)
+		}
+	}()
+
+	if err := os.Mkdir(path.Join(dir, "diff"), 0755); err != nil {
+		return err
+	}
+	link := utils.RandomString()[:linkLength]
+	if err := os.Symlink(path.Join("..", id, "diff"), path.Join(d.home, linkDir, link)); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "link"), []byte(link), 0644); err != nil {
+		return err
+	}
+
+	if parent == "" {
+		return nil
+	}
+
+	lower, err := d.lowerFor(parent)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "lower"), []byte(strings.Join(lower, ":")), 0644); err != nil {
+		return err
+	}
+	for _, p := range []string{"work", "merged"} {
+		if err := os.Mkdir(path.Join(dir, p), 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lowerFor returns the lower dirs of a child of parent, relative to the
+// home of the driver
+func (d *Driver) lowerFor(parent string) ([]string, error) {
+	link, err := ioutil.ReadFile(path.Join(d.dir(parent), "link"))
+	if err != nil {
+		return nil, err
+	}
+	lower := []string{path.Join(linkDir, string(link))}
+
+	parentLower, err := d.lower(parent)
+	if err != nil {
+		return nil, err
+	}
+	return append(lower, parentLower...), nil
+}
+
+// lower returns the lower dirs of id relative to the home of the driver,
+// nearest first. It is empty for a layer without parent.
+func (d *Driver) lower(id string) ([]string, error) {
+	data, err := ioutil.ReadFile(path.Join(d.dir(id), "lower"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return strings.Split(string(data), ":"), nil
+}
+
+// lowerPaths returns the absolute paths of the diff dirs of the parents
+// of id, nearest first
+func (d *Driver) lowerPaths(id string) ([]string, error) {
+	lower, err := d.lower(id)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(lower))
+	for i, l := range lower {
+		if paths[i], err = os.Readlink(path.Join(d.home, l)); err != nil {
+			return nil, err
+		}
+		paths[i] = path.Join(d.home, linkDir, paths[i])
+	}
+	return paths, nil
+}
+
+func (d *Driver) Remove(id string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.active[id] != 0 {
+		utils.Errorf("Warning: removing active id %s\n", id)
+		delete(d.active, id)
+	}
+
+	dir := d.dir(id)
+	if err := d.unmount(id); err != nil {
+		return err
+	}
+	if link, err := ioutil.ReadFile(path.Join(dir, "link")); err == nil {
+		if err := os.Remove(path.Join(d.home, linkDir, string(link))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	// Move the dir out of the way first, so that docker doesn't find it
+	// anymore while the whole tree is removed
+	tmpDir := path.Join(d.home, fmt.Sprintf("%s-removing", id))
+	if err := os.Rename(dir, tmpDir); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.RemoveAll(tmpDir)
+}
+
+// Get returns the diff dir of a layer without parent, and mounts the
+// layers with parents
+func (d *Driver) Get(id string) (string, error) {
+	dir := d.dir(id)
+	lower, err := d.lower(id)
+	if err != nil {
+		return "", err
+	}
+	if len(lower) == 0 {
+		if _, err := os.Stat(dir); err != nil {
+			return "", err
+		}
+		return path.Join(dir, "diff"), nil
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	mergedDir := path.Join(dir, "merged")
+	count := d.active[id]
+	if count == 0 {
+		if mounted, err := mountpk.Mounted(mergedDir); err != nil {
+			return "", err
+		} else if !mounted {
+			absLower := make([]string, len(lower))
+			for i, l := range lower {
+				absLower[i] = path.Join(d.home, l)
+			}
+			options := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+				strings.Join(absLower, ":"), path.Join(dir, "diff"), path.Join(dir, "work"))
+			if len(options) < os.Getpagesize() {
+				err = mount("overlay", mergedDir, "overlay", options)
+			} else {
+				// Relative to the home of the driver, see linkLength
+				options = fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s",
+					strings.Join(lower, ":"), path.Join(id, "diff"), path.Join(id, "work"))
+				err = mountFrom(d.home, "overlay", mergedDir, "overlay", options)
+			}
+			if err != nil {
+				return "", fmt.Errorf("Error mounting overlay for %s: %s", id, err)
+			}
+		}
+	}
+	d.active[id] = count + 1
+
+	return mergedDir, nil
+}
+
+func (d *Driver) Put(id string) {
+	d.Lock()
+	defer d.Unlock()
+
+	count, exists := d.active[id]
+	if !exists {
+		// Layers without parent are not mounted
+		return
+	}
+	if count > 1 {
+		d.active[id] = count - 1
+		return
+	}
+	if err := d.unmount(id); err != nil {
+		utils.Errorf("Unmounting %s: %s", utils.TruncateID(id), err)
+	}
+	delete(d.active, id)
+}
+
+func (d *Driver) unmount(id string) error {
+	mergedDir := path.Join(d.dir(id), "merged")
+	if mounted, err := mountpk.Mounted(mergedDir); err != nil || !mounted {
+		return err
+	}
+	return unmount(mergedDir)
+}
+
+// During cleanup overlay needs to unmount all mountpoints
+func (d *Driver) Cleanup() error {
+	ids, err := d.ids()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := d.unmount(id); err != nil {
+			utils.Errorf("Unmounting %s: %s", utils.TruncateID(id), err)
+		}
+	}
+	return nil
+}
+
+func (d *Driver) List() ([]string, error) {
+	return d.ids()
+}
+
+func (d *Driver) ids() ([]string, error) {
+	files, err := ioutil.ReadDir(d.home)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, f := range files {
+		if f.IsDir() && f.Name() != linkDir && !strings.HasSuffix(f.Name(), "-removing") {
+			ids = append(ids, f.Name())
+		}
+	}
+	return ids, nil
+}
+
+// Diff returns the content of the layer in the aufs format: the whiteouts
+// of overlay are exported as .wh. entries, and the opaque directories as
+// the .wh. entries of everything they hide.
+func (d *Driver) Diff(id string) (archive.Archive, error) {
+	changes, err := d.changes(id)
+	if err != nil {
+		return nil, err
+	}
+	return archive.ExportChanges(path.Join(d.dir(id), "diff"), changes)
+}
+
+// ApplyDiff unpacks a layer in the aufs format and converts its .wh.
+// entries to the whiteouts and opaque directories of overlay
+func (d *Driver) ApplyDiff(id string, diff archive.Archive) error {
+	diffDir := path.Join(d.dir(id), "diff")
+	if err := archive.Untar(diff, diffDir, nil); err != nil {
+		return err
+	}
+	return convertWhiteouts(diffDir)
+}
+
+func (d *Driver) DiffSize(id string) (int64, error) {
+	return utils.TreeSize(path.Join(d.dir(id), "diff"))
+}
+
+func (d *Driver) Changes(id string) ([]archive.Change, error) {
+	return d.changes(id)
+}
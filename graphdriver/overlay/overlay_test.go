@@ -0,0 +1,256 @@
+package overlay
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"github.com/dotcloud/docker/archive"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"testing"
+)
+
+func newDriver(t *testing.T) *Driver {
+	home, err := ioutil.TempDir("", "overlay-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Diffs don't need overlay to be mounted, don't require it
+	if err := os.MkdirAll(path.Join(home, linkDir), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return &Driver{home: home, active: make(map[string]int)}
+}
+
+// Build an uncompressed layer. Names ending with / are directories.
+func layer(t *testing.T, names ...string) archive.Archive {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, name := range names {
+		hdr := &tar.Header{Name: name, Mode: 0644, Typeflag: tar.TypeReg}
+		if name[len(name)-1] == '/' {
+			hdr.Mode = 0755
+			hdr.Typeflag = tar.TypeDir
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func setupLayers(t *testing.T, d *Driver) {
+	if err := d.Create("base", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ApplyDiff("base", layer(t, "a", "keep", "dir/", "dir/b", "dir/c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("child", "base"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ApplyDiff("child", layer(t, ".wh.a", "dir/", "dir/.wh..wh..opq", "dir/d")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyDiffConvertsWhiteouts(t *testing.T) {
+	d := newDriver(t)
+	defer os.RemoveAll(d.home)
+	setupLayers(t, d)
+
+	diffDir := path.Join(d.dir("child"), "diff")
+	fi, err := os.Lstat(path.Join(diffDir, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isWhiteout(fi) {
+		t.Fatalf("Expected a to be a whiteout, got %s", fi.Mode())
+	}
+	if !isOpaque(path.Join(diffDir, "dir")) {
+		t.Fatalf("Expected dir to be opaque")
+	}
+	for _, name := range []string{".wh.a", "dir/.wh..wh..opq"} {
+		if _, err := os.Lstat(path.Join(diffDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s to be removed", name)
+		}
+	}
+}
+
+func TestChanges(t *testing.T) {
+	d := newDriver(t)
+	defer os.RemoveAll(d.home)
+	setupLayers(t, d)
+
+	changes, err := d.Changes("child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for _, c := range changes {
+		out = append(out, c.String())
+	}
+	sort.Strings(out)
+	expected := []string{"A /dir/d", "C /dir", "D /a", "D /dir/b", "D /dir/c"}
+	if len(out) != len(expected) {
+		t.Fatalf("Expected changes %v, got %v", expected, out)
+	}
+	for i := range out {
+		if out[i] != expected[i] {
+			t.Fatalf("Expected changes %v, got %v", expected, out)
+		}
+	}
+}
+
+func TestDiffAppliesAsLayer(t *testing.T) {
+	d := newDriver(t)
+	defer os.RemoveAll(d.home)
+	setupLayers(t, d)
+
+	diff, err := d.Diff("child")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(bytes.NewReader(content))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeChar {
+			t.Fatalf("Whiteout %s exported as a device", hdr.Name)
+		}
+	}
+
+	// The layer has the same effect on another driver
+	dest, err := ioutil.TempDir("", "overlay-test-apply")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+	if err := archive.ApplyLayer(dest, layer(t, "a", "keep", "dir/", "dir/b", "dir/c")); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.ApplyLayer(dest, bytes.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+	for name, exists := range map[string]bool{
+		"a":     false,
+		"keep":  true,
+		"dir/b": false,
+		"dir/c": false,
+		"dir/d": true,
+	} {
+		if _, err := os.Lstat(path.Join(dest, name)); (err == nil) != exists {
+			t.Fatalf("Expected %s to exist: %t, got %v", name, exists, err)
+		}
+	}
+}
+
+func TestMount(t *testing.T) {
+	home, err := ioutil.TempDir("", "overlay-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	driver, err := Init(home, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := driver.(*Driver)
+	defer d.Cleanup()
+	setupLayers(t, d)
+
+	if err := d.Create("container", "child"); err != nil {
+		t.Fatal(err)
+	}
+	mnt, err := d.Get("container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, exists := range map[string]bool{
+		"a":     false,
+		"keep":  true,
+		"dir/b": false,
+		"dir/d": true,
+	} {
+		if _, err := os.Lstat(path.Join(mnt, name)); (err == nil) != exists {
+			d.Put("container")
+			t.Fatalf("Expected %s to exist: %t, got %v", name, exists, err)
+		}
+	}
+	if err := os.Remove(path.Join(mnt, "keep")); err != nil {
+		d.Put("container")
+		t.Fatal(err)
+	}
+	d.Put("container")
+
+	changes, err := d.Changes("container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].String() != "D /keep" {
+		t.Fatalf("Expected only /keep to be deleted, got %v", changes)
+	}
+}
+
+func TestMountKeepsWorkingDirectory(t *testing.T) {
+	home, err := ioutil.TempDir("", "overlay-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(home)
+	driver, err := Init(home, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := driver.(*Driver)
+	defer d.Cleanup()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The options of the deepest layers don't fit in a page with absolute
+	// paths, they are mounted with relative ones
+	parent := ""
+	for i := 0; i < 100; i++ {
+		id := fmt.Sprintf("layer%d", i)
+		if err := d.Create(id, parent); err != nil {
+			t.Fatal(err)
+		}
+		parent = id
+	}
+	if err := d.ApplyDiff("layer0", layer(t, "base")); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"layer1", "layer99"} {
+		mnt, err := d.Get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = os.Lstat(path.Join(mnt, "base"))
+		d.Put(id)
+		if err != nil {
+			t.Fatalf("Expected the file of the base layer in %s: %s", id, err)
+		}
+		if dir, err := os.Getwd(); err != nil {
+			t.Fatal(err)
+		} else if dir != wd {
+			t.Fatalf("Expected the working directory to stay %s after mounting %s, got %s", wd, id, dir)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// +build amd64
+
+package overlay
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const opaqueXattr = "trusted.overlay.opaque"
+
+func mount(device, target, mType, options string) error {
+	return syscall.Mount(device, target, mType, 0, options)
+}
+
+// mountFrom mounts with dir as the working directory, for the mount
+// options to be relative to it. The working directory of the daemon is
+// shared by all its threads, the mount is done by mount(8) run in dir.
+func mountFrom(dir, device, target, mType, options string) error {
+	cmd := exec.Command("mount", "-t", mType, "-o", options, device, target)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %s", err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+func unmount(target string) error {
+	return syscall.Unmount(target, 0)
+}
+
+// Whiteouts are character devices with 0/0 as device number
+func isWhiteout(fi os.FileInfo) bool {
+	if fi.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && stat.Rdev == 0
+}
+
+func createWhiteout(path string) error {
+	return syscall.Mknod(path, syscall.S_IFCHR, 0)
+}
+
+func isOpaque(dir string) bool {
+	value := make([]byte, 1)
+	n, err := syscall.Getxattr(dir, opaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+func setOpaque(dir string) error {
+	return syscall.Setxattr(dir, opaqueXattr, []byte("y"), 0)
+}
@@ -0,0 +1,38 @@
+// +build !linux !amd64
+
+package overlay
+
+import (
+	"errors"
+	"os"
+)
+
+var errNotSupported = errors.New("overlay is only supported on linux")
+
+func mount(device, target, mType, options string) error {
+	return errNotSupported
+}
+
+func mountFrom(dir, device, target, mType, options string) error {
+	return errNotSupported
+}
+
+func unmount(target string) error {
+	return errNotSupported
+}
+
+func isWhiteout(fi os.FileInfo) bool {
+	return false
+}
+
+func createWhiteout(path string) error {
+	return errNotSupported
+}
+
+func isOpaque(dir string) bool {
+	return false
+}
+
+func setOpaque(dir string) error {
+	return errNotSupported
+}
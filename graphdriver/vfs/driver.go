@@ -3,6 +3,7 @@ package vfs
 import (
 	"fmt"
 	"github.com/dotcloud/docker/graphdriver"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
@@ -12,7 +13,7 @@ func init() {
 	graphdriver.Register("vfs", Init)
 }
 
-func Init(home string) (graphdriver.Driver, error) {
+func Init(home string, options []string) (graphdriver.Driver, error) {
 	d := &Driver{
 		home: home,
 	}
@@ -89,6 +90,21 @@ func (d *Driver) Put(id string) {
 	// to clean up, so we don't need anything here
 }
 
+func (d *Driver) List() ([]string, error) {
+	files, err := ioutil.ReadDir(path.Join(d.home, "dir"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		ids = append(ids, f.Name())
+	}
+	return ids, nil
+}
+
 func (d *Driver) Exists(id string) bool {
 	_, err := os.Stat(d.dir(id))
 	return err == nil
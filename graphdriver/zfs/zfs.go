@@ -0,0 +1,492 @@
+// +build linux,amd64
+
+/*
+
+zfs driver layout
+
+Every layer is a dataset under the dataset docker's root is stored in:
+
+  <parent dataset>/<id>                  a layer without parent
+  <parent dataset>/<parent id>@<id>      snapshot of the parent a child is cloned from
+  <parent dataset>/<id>                  the clone of the snapshot
+
+The datasets have a legacy mountpoint and are mounted by Get under
+<home>/graph/<id>.
+
+*/
+
+package zfs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/graphdriver"
+	mountpk "github.com/dotcloud/docker/pkg/mount"
+	"github.com/dotcloud/docker/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+const zfsSuperMagic = 0x2fc12fc1
+
+func init() {
+	graphdriver.Register("zfs", Init)
+}
+
+type Driver struct {
+	home       string
+	dataset    string // Dataset the layers are created under
+	sync.Mutex        // Protects concurrent modification to active
+	active     map[string]int
+}
+
+func Init(home string, options []string) (graphdriver.Driver, error) {
+	rootdir := path.Dir(home)
+
+	var buf syscall.Statfs_t
+	if err := syscall.Statfs(rootdir, &buf); err != nil {
+		return nil, err
+	}
+	if buf.Type != zfsSuperMagic {
+		return nil, fmt.Errorf("%s is not a zfs filesystem", rootdir)
+	}
+	if _, err := exec.LookPath("zfs"); err != nil {
+		return nil, fmt.Errorf("zfs is required by the zfs driver: %s", err)
+	}
+
+	mounts, err := mountpk.GetMounts()
+	if err != nil {
+		return nil, err
+	}
+	dataset, err := findDataset(mounts, rootdir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(path.Join(home, "graph"), 0700); err != nil {
+		return nil, err
+	}
+	return &Driver{
+		home:    home,
+		dataset: dataset,
+		active:  make(map[string]int),
+	}, nil
+}
+
+// findDataset returns the zfs dataset which dir is stored in
+func findDataset(mounts []*mountpk.MountInfo, dir string) (string, error) {
+	var found *mountpk.MountInfo
+	for _, m := range mounts {
+		if m.Fstype != "zfs" {
+			continue
+		}
+		if m.Mountpoint != "/" && dir != m.Mountpoint && !strings.HasPrefix(dir, m.Mountpoint+"/") {
+			continue
+		}
+		// The deepest mount hides the others
+		if found == nil || len(m.Mountpoint) >= len(found.Mountpoint) {
+			found = m
+		}
+	}
+	if found == nil {
+		return "", fmt.Errorf("No zfs dataset is mounted on %s", dir)
+	}
+	return found.Source, nil
+}
+
+// zfs runs the zfs command and returns its output
+func zfs(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("zfs", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("zfs %s failed: %s (%s)", strings.Join(args, " "), strings.TrimSpace(stderr.String()), err)
+	}
+	return stdout.String(), nil
+}
+
+func (d *Driver) String() string {
+	return "zfs"
+}
+
+func (d *Driver) Status() [][2]string {
+	pool := strings.SplitN(d.dataset, "/", 2)[0]
+	status := [][2]string{
+		{"Zpool", pool},
+	}
+	if out, err := exec.Command("zpool", "list", "-H", "-p", "-o", "health,size,allocated,free", pool).Output(); err == nil {
+		if fields := strings.Fields(string(out)); len(fields) == 4 {
+			status = append(status,
+				[2]string{"Zpool Health", fields[0]},
+				[2]string{"Zpool Size", humanSize(fields[1])},
+				[2]string{"Zpool Allocated", humanSize(fields[2])},
+				[2]string{"Zpool Free", humanSize(fields[3])})
+		}
+	}
+	status = append(status, [2]string{"Parent Dataset", d.dataset})
+	if out, err := zfs("get", "-H", "-p", "-o", "value", "used,available", d.dataset); err == nil {
+		if fields := strings.Fields(out); len(fields) == 2 {
+			status = append(status,
+				[2]string{"Space Used By Parent", humanSize(fields[0])},
+				[2]string{"Space Available", humanSize(fields[1])})
+		}
+	}
+	ids, _ := ioutil.ReadDir(path.Join(d.home, "graph"))
+	return append(status, [2]string{"Dirs", fmt.Sprintf("%d", len(ids))})
+}
+
+func humanSize(bytes string) string {
+	size, err := strconv.ParseInt(bytes, 10, 64)
+	if err != nil {
+		return bytes
+	}
+	return utils.HumanSize(size)
+}
+
+func (d *Driver) datasetName(id string) string {
+	return d.dataset + "/" + id
+}
+
+func (d *Driver) mountPath(id string) string {
+	return path.Join(d.home, "graph", id)
+}
+
+func (d *Driver) Exists(id string) bool {
+	_, err := os.Stat(d.mountPath(id))
+	return err == nil
+}
+
+func (d *Driver) Create(id, parent string) error {
+	dataset := d.datasetName(id)
+	if parent == "" {
+		if _, err := zfs("create", "-o", "mountpoint=legacy", dataset); err != nil {
+			return err
+		}
+	} else {
+		// Every child gets its own snapshot, so that it can be destroyed
+		// along with the child
+		snapshot := d.datasetName(parent) + "@" + id
+		if _, err := zfs("snapshot", snapshot); err != nil {
+			return err
+		}
+		if _, err := zfs("clone", "-o", "mountpoint=legacy", snapshot, dataset); err != nil {
+			zfs("destroy", snapshot)
+			return err
+		}
+	}
+	if err := os.MkdirAll(d.mountPath(id), 0755); err != nil {
+		d.destroy(id)
+		return err
+	}
+	return nil
+}
+
+func (d *Driver) Remove(id string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	if d.active[id] != 0 {
+		utils.Errorf("Warning: removing active id %s\n", id)
+		delete(d.active, id)
+	}
+	if err := d.unmount(id); err != nil {
+		return err
+	}
+	if err := d.destroy(id); err != nil {
+		return err
+	}
+	return os.RemoveAll(d.mountPath(id))
+}
+
+// destroy removes the dataset of id and the snapshot it was cloned from
+func (d *Driver) destroy(id string) error {
+	dataset := d.datasetName(id)
+	origin, err := zfs("get", "-H", "-o", "value", "origin", dataset)
+	if err != nil {
+		return err
+	}
+	if _, err := zfs("destroy", "-r", dataset); err != nil {
+		return err
+	}
+	if origin = strings.TrimSpace(origin); origin != "-" {
+		// Deferred, in case the snapshot is still cloned elsewhere
+		if _, err := zfs("destroy", "-d", origin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Driver) Get(id string) (string, error) {
+	mountPath := d.mountPath(id)
+	if _, err := os.Stat(mountPath); err != nil {
+		return "", err
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	count := d.active[id]
+	if count == 0 {
+		if mounted, err := mountpk.Mounted(mountPath); err != nil {
+			return "", err
+		} else if !mounted {
+			if err := syscall.Mount(d.datasetName(id), mountPath, "zfs", 0, ""); err != nil {
+				return "", fmt.Errorf("Error mounting %s: %s", d.datasetName(id), err)
+			}
+		}
+	}
+	d.active[id] = count + 1
+
+	return mountPath, nil
+}
+
+func (d *Driver) Put(id string) {
+	d.Lock()
+	defer d.Unlock()
+
+	if count := d.active[id]; count > 1 {
+		d.active[id] = count - 1
+		return
+	}
+	if err := d.unmount(id); err != nil {
+		utils.Errorf("Unmounting %s: %s", utils.TruncateID(id), err)
+	}
+	delete(d.active, id)
+}
+
+func (d *Driver) unmount(id string) error {
+	mountPath := d.mountPath(id)
+	if mounted, err := mountpk.Mounted(mountPath); err != nil || !mounted {
+		return err
+	}
+	return syscall.Unmount(mountPath, 0)
+}
+
+func (d *Driver) List() ([]string, error) {
+	files, err := ioutil.ReadDir(path.Join(d.home, "graph"))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		ids = append(ids, f.Name())
+	}
+	return ids, nil
+}
+
+// During cleanup zfs needs to unmount all datasets
+func (d *Driver) Cleanup() error {
+	files, err := ioutil.ReadDir(path.Join(d.home, "graph"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := d.unmount(f.Name()); err != nil {
+			utils.Errorf("Unmounting %s: %s", utils.TruncateID(f.Name()), err)
+		}
+	}
+	return nil
+}
+
+// Changes asks zfs for the differences between the dataset of id and the
+// snapshot of its parent it was cloned from
+func (d *Driver) Changes(id string) ([]archive.Change, error) {
+	mountPath, err := d.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Put(id)
+	return d.changes(id, mountPath)
+}
+
+// changes must be called with the dataset of id mounted on mountPath
+func (d *Driver) changes(id, mountPath string) ([]archive.Change, error) {
+	origin, err := zfs("get", "-H", "-o", "value", "origin", d.datasetName(id))
+	if err != nil {
+		return nil, err
+	}
+	if origin = strings.TrimSpace(origin); origin == "-" {
+		// Everything was added to a layer without parent
+		return walkAdded(mountPath, "/", nil)
+	}
+
+	// The paths are reported under the mountpoint of the dataset
+	out, err := zfs("diff", "-FH", origin, d.datasetName(id))
+	if err != nil {
+		return nil, err
+	}
+	changes, renamedDirs, err := parseDiff(strings.NewReader(out), mountPath)
+	if err != nil {
+		return nil, err
+	}
+	// zfs only reports the renamed directory, not its content
+	for _, dir := range renamedDirs {
+		if changes, err = walkAdded(mountPath, dir, changes); err != nil {
+			return nil, err
+		}
+	}
+	sort.Sort(changesByPath(changes))
+	return changes, nil
+}
+
+// parseDiff parses the output of zfs diff -FH:
+// CHANGE TYPE PATH [NEW PATH]
+// A rename is reported as the deletion of the old path and the addition
+// of the new one. The renamed directories are returned, as their content
+// is not listed.
+func parseDiff(r io.Reader, mountPath string) ([]archive.Change, []string, error) {
+	var (
+		changes     []archive.Change
+		renamedDirs []string
+		s           = bufio.NewScanner(r)
+	)
+	for s.Scan() {
+		fields := strings.Split(s.Text(), "\t")
+		if len(fields) < 3 || (fields[0] == "R" && len(fields) < 4) {
+			return nil, nil, fmt.Errorf("Invalid zfs diff line: %s", s.Text())
+		}
+		var paths []string
+		for _, p := range fields[2:] {
+			rel, err := filepath.Rel(mountPath, unescape(p))
+			if err != nil || strings.HasPrefix(rel, "..") {
+				return nil, nil, fmt.Errorf("Invalid path in zfs diff: %s", p)
+			}
+			paths = append(paths, filepath.Join("/", rel))
+		}
+		if paths[0] == "/" {
+			continue
+		}
+		switch fields[0] {
+		case "+":
+			changes = append(changes, archive.Change{Path: paths[0], Kind: archive.ChangeAdd})
+		case "-":
+			changes = append(changes, archive.Change{Path: paths[0], Kind: archive.ChangeDelete})
+		case "M":
+			changes = append(changes, archive.Change{Path: paths[0], Kind: archive.ChangeModify})
+		case "R":
+			changes = append(changes,
+				archive.Change{Path: paths[0], Kind: archive.ChangeDelete},
+				archive.Change{Path: paths[1], Kind: archive.ChangeAdd})
+			if fields[1] == "/" {
+				renamedDirs = append(renamedDirs, paths[1])
+			}
+		default:
+			return nil, nil, fmt.Errorf("Unknown change in zfs diff: %s", s.Text())
+		}
+	}
+	return changes, renamedDirs, s.Err()
+}
+
+// unescape decodes the \ooo escapes zfs uses for spaces and non
+// printable characters
+func unescape(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var out []byte
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				out = append(out, byte(c))
+				i += 3
+				continue
+			}
+		}
+		out = append(out, s[i])
+	}
+	return string(out)
+}
+
+// walkAdded appends every entry under dir, relative to root, as added
+func walkAdded(root, dir string, changes []archive.Change) ([]archive.Change, error) {
+	err := filepath.Walk(filepath.Join(root, dir), func(p string, f os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.Join("/", rel); rel != "/" && rel != dir {
+			changes = append(changes, archive.Change{Path: rel, Kind: archive.ChangeAdd})
+		}
+		return nil
+	})
+	return changes, err
+}
+
+type changesByPath []archive.Change
+
+func (c changesByPath) Len() int           { return len(c) }
+func (c changesByPath) Less(i, j int) bool { return c[i].Path < c[j].Path }
+func (c changesByPath) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// Diff exports the changes of the layer, keeping it mounted until the
+// archive has been read
+func (d *Driver) Diff(id string) (archive.Archive, error) {
+	mountPath, err := d.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	changes, err := d.changes(id, mountPath)
+	if err != nil {
+		d.Put(id)
+		return nil, err
+	}
+	a, err := archive.ExportChanges(mountPath, changes)
+	if err != nil {
+		d.Put(id)
+		return nil, err
+	}
+	return &putOnEOF{Reader: a, put: func() { d.Put(id) }}, nil
+}
+
+type putOnEOF struct {
+	io.Reader
+	done int32
+	put  func()
+}
+
+func (r *putOnEOF) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF && atomic.CompareAndSwapInt32(&r.done, 0, 1) {
+		r.put()
+	}
+	return n, err
+}
+
+func (d *Driver) ApplyDiff(id string, diff archive.Archive) error {
+	mountPath, err := d.Get(id)
+	if err != nil {
+		return err
+	}
+	defer d.Put(id)
+	return archive.ApplyLayer(mountPath, diff)
+}
+
+func (d *Driver) DiffSize(id string) (int64, error) {
+	mountPath, err := d.Get(id)
+	if err != nil {
+		return -1, err
+	}
+	defer d.Put(id)
+	changes, err := d.changes(id, mountPath)
+	if err != nil {
+		return -1, err
+	}
+	return archive.ChangesSize(mountPath, changes), nil
+}
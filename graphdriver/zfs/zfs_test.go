@@ -0,0 +1,81 @@
+// +build linux,amd64
+
+package zfs
+
+import (
+	mountpk "github.com/dotcloud/docker/pkg/mount"
+	"strings"
+	"testing"
+)
+
+func TestFindDataset(t *testing.T) {
+	mounts := []*mountpk.MountInfo{
+		{Mountpoint: "/", Fstype: "zfs", Source: "rpool/ROOT"},
+		{Mountpoint: "/var/lib", Fstype: "ext4", Source: "/dev/sda1"},
+		{Mountpoint: "/var/lib/docker", Fstype: "zfs", Source: "tank/docker"},
+		{Mountpoint: "/var/lib/dockerish", Fstype: "zfs", Source: "tank/other"},
+	}
+	for dir, expected := range map[string]string{
+		"/var/lib/docker":     "tank/docker",
+		"/var/lib/docker/zfs": "tank/docker",
+		"/var/lib/docker2":    "rpool/ROOT",
+		"/home":               "rpool/ROOT",
+	} {
+		dataset, err := findDataset(mounts, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dataset != expected {
+			t.Fatalf("Expected %s for %s, got %s", expected, dir, dataset)
+		}
+	}
+
+	if _, err := findDataset(mounts[1:2], "/var/lib/docker"); err == nil {
+		t.Fatalf("Expected an error without zfs mount")
+	}
+}
+
+func TestParseDiff(t *testing.T) {
+	out := strings.Join([]string{
+		"M\t/\t/mnt/id/",
+		"M\t/\t/mnt/id/etc",
+		"+\tF\t/mnt/id/etc/new\\040file",
+		"-\tF\t/mnt/id/etc/old",
+		"R\t/\t/mnt/id/a\t/mnt/id/b",
+	}, "\n")
+	changes, renamedDirs, err := parseDiff(strings.NewReader(out), "/mnt/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"C /etc", "A /etc/new file", "D /etc/old", "D /a", "A /b"}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %v", len(expected), changes)
+	}
+	for i, c := range changes {
+		if c.String() != expected[i] {
+			t.Fatalf("Expected %q, got %q", expected[i], c.String())
+		}
+	}
+	if len(renamedDirs) != 1 || renamedDirs[0] != "/b" {
+		t.Fatalf("Expected /b to be a renamed directory, got %v", renamedDirs)
+	}
+
+	for _, invalid := range []string{"M\t/", "R\tF\t/mnt/id/a", "?\tF\t/mnt/id/a", "+\tF\t/elsewhere/a"} {
+		if _, _, err := parseDiff(strings.NewReader(invalid), "/mnt/id"); err == nil {
+			t.Fatalf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestUnescape(t *testing.T) {
+	for escaped, expected := range map[string]string{
+		"plain":          "plain",
+		"with\\040space": "with space",
+		"back\\134slash": "back\\slash",
+		"trailing\\04":   "trailing\\04",
+	} {
+		if out := unescape(escaped); out != expected {
+			t.Fatalf("Expected %q for %q, got %q", expected, escaped, out)
+		}
+	}
+}
@@ -296,6 +296,20 @@ func (img *Image) Depth() (int, error) {
 	return count, nil
 }
 
+// LayerDigest returns the tarsum of the files of a layer, compressed or not,
+// and of imgJSON if it isn't nil
+func LayerDigest(layer io.Reader, imgJSON []byte) (string, error) {
+	decompressed, err := archive.DecompressStream(layer)
+	if err != nil {
+		return "", err
+	}
+	tarsum := &utils.TarSum{Reader: decompressed, DisableCompression: true}
+	if _, err := io.Copy(ioutil.Discard, tarsum); err != nil {
+		return "", err
+	}
+	return tarsum.Sum(imgJSON), nil
+}
+
 // Build an Image object from raw json data
 func NewImgJSON(src []byte) (*Image, error) {
 	ret := &Image{}
@@ -0,0 +1,58 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func testLayer(t *testing.T, content string) []byte {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "file", Mode: 0600, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLayerDigest(t *testing.T) {
+	layer := testLayer(t, "hello world")
+	digest, err := LayerDigest(bytes.NewReader(layer), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The digest doesn't depend on the compression of the layer
+	buf := bytes.NewBuffer(nil)
+	gw := gzip.NewWriter(buf)
+	if _, err := gw.Write(layer); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if compressed, err := LayerDigest(buf, nil); err != nil {
+		t.Fatal(err)
+	} else if compressed != digest {
+		t.Fatalf("Expected the digest of the compressed layer to be %s, not %s", digest, compressed)
+	}
+
+	if other, err := LayerDigest(bytes.NewReader(testLayer(t, "hello world!")), nil); err != nil {
+		t.Fatal(err)
+	} else if other == digest {
+		t.Fatal("Expected different layers to have different digests")
+	}
+
+	if withJSON, err := LayerDigest(bytes.NewReader(layer), []byte("{}")); err != nil {
+		t.Fatal(err)
+	} else if withJSON == digest {
+		t.Fatal("Expected the digest to cover the JSON of the image")
+	}
+}
@@ -24,7 +24,7 @@ func TestLogin(t *testing.T) {
 		Email:         "noise+unittester@docker.com",
 		ServerAddress: "https://indexstaging-docker.dotcloud.com/v1/",
 	}
-	status, err := auth.Login(authConfig, nil)
+	status, err := auth.Login(authConfig, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -47,7 +47,7 @@ func TestCreateAccount(t *testing.T) {
 		Email:         fmt.Sprintf("docker-ut+%s@example.com", token),
 		ServerAddress: "https://indexstaging-docker.dotcloud.com/v1/",
 	}
-	status, err := auth.Login(authConfig, nil)
+	status, err := auth.Login(authConfig, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -59,7 +59,7 @@ func TestCreateAccount(t *testing.T) {
 		t.Fatalf("Expected status: \"%s\", found \"%s\" instead.", expectedStatus, status)
 	}
 
-	status, err = auth.Login(authConfig, nil)
+	status, err = auth.Login(authConfig, nil, nil, nil)
 	if err == nil {
 		t.Fatalf("Expected error but found nil instead")
 	}
@@ -592,6 +592,51 @@ func TestKill(t *testing.T) {
 	}
 }
 
+func TestKillPaused(t *testing.T) {
+	runtime := mkRuntime(t)
+	defer nuke(runtime)
+	container, _, err := runtime.Create(&docker.Config{
+		Image: GetTestImage(runtime).ID,
+		Cmd:   []string{"sleep", "10"},
+	},
+		"",
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Destroy(container)
+
+	if err := container.Pause(); err == nil {
+		t.Fatalf("Pausing a stopped container should fail")
+	}
+	if err := container.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Give some time to lxc to spawn the process
+	container.WaitTimeout(500 * time.Millisecond)
+
+	if err := container.Pause(); err != nil {
+		t.Fatal(err)
+	}
+	if !container.State.IsPaused() {
+		t.Errorf("Container should be paused")
+	}
+	if err := container.Pause(); err == nil {
+		t.Errorf("Pausing a paused container should fail")
+	}
+	if err := container.Kill(); err != nil {
+		t.Fatal(err)
+	}
+	container.Wait()
+	if container.State.IsRunning() {
+		t.Errorf("Container shouldn't be running")
+	}
+	if container.State.IsPaused() {
+		t.Errorf("Container shouldn't be paused")
+	}
+}
+
 func TestExitCode(t *testing.T) {
 	runtime := mkRuntime(t)
 	defer nuke(runtime)
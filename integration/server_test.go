@@ -3,6 +3,7 @@ package docker
 import (
 	"github.com/dotcloud/docker"
 	"github.com/dotcloud/docker/engine"
+	"github.com/dotcloud/docker/graphdriver"
 	"strings"
 	"testing"
 	"time"
@@ -709,3 +710,175 @@ func TestDeleteTagWithExistingContainers(t *testing.T) {
 		t.Fatalf("Expected %s got %s", unitTestImageID, untag)
 	}
 }
+
+func TestGarbageCollect(t *testing.T) {
+	eng := NewTestEngine(t)
+	runtime := mkRuntimeFromEngine(eng, t)
+	defer nuke(runtime)
+
+	config, _, _, err := docker.ParseRun([]string{unitTestImageID, "echo", "test"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerID := createTestContainer(eng, config, t)
+
+	// An untagged image used by no container
+	job := eng.Job("commit", containerID)
+	var imageID string
+	job.Stdout.AddString(&imageID)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An anonymous volume used by no container, and a named one
+	var anonymous string
+	job = eng.Job("volume_create")
+	job.Stdout.AddString(&anonymous)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("volume_create", "kept").Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A layer of the driver which belongs to nothing
+	driver := runtime.Graph().Driver()
+	_, listable := driver.(graphdriver.Lister)
+	orphan := docker.GenerateID()
+	if err := driver.Create(orphan, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	gc := func(dryRun bool) map[string]string {
+		job := eng.Job("gc")
+		job.SetenvBool("DryRun", dryRun)
+		job.Setenv("ContainerAge", "0s")
+		outs, err := job.Stdout.AddListTable()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := job.Run(); err != nil {
+			t.Fatal(err)
+		}
+		removed := make(map[string]string)
+		for _, out := range outs.Data {
+			removed[out.Get("ID")] = out.Get("Type")
+		}
+		return removed
+	}
+
+	removed := gc(true)
+	if removed[containerID] != "container" {
+		t.Fatalf("Expected container %s to be collected, got %v", containerID, removed)
+	}
+	if removed[imageID] != "image" {
+		t.Fatalf("Expected image %s to be collected, got %v", imageID, removed)
+	}
+	if _, exists := removed[unitTestImageID]; exists {
+		t.Fatalf("Tagged image %s should not be collected", unitTestImageID)
+	}
+	if removed[anonymous] != "volume" {
+		t.Fatalf("Expected volume %s to be collected, got %v", anonymous, removed)
+	}
+	if _, exists := removed["kept"]; exists {
+		t.Fatal("Named volume kept should not be collected")
+	}
+	if listable && removed[orphan] != "layer" {
+		t.Fatalf("Expected layer %s to be collected, got %v", orphan, removed)
+	}
+	containerAssertExists(eng, containerID, t)
+	if !runtime.Graph().Exists(imageID) {
+		t.Fatalf("Image %s should not be removed on a dry run", imageID)
+	}
+	if !driver.Exists(orphan) {
+		t.Fatalf("Layer %s should not be removed on a dry run", orphan)
+	}
+
+	gc(false)
+	containerAssertNotExists(eng, containerID, t)
+	if runtime.Graph().Exists(imageID) {
+		t.Fatalf("Image %s should be removed", imageID)
+	}
+	if !runtime.Graph().Exists(unitTestImageID) {
+		t.Fatalf("Image %s should not be removed", unitTestImageID)
+	}
+	if listable && driver.Exists(orphan) {
+		t.Fatalf("Layer %s should be removed", orphan)
+	}
+
+	volumes := eng.Job("volumes")
+	outs, err := volumes.Stdout.AddListTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := volumes.Run(); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, out := range outs.Data {
+		switch out.Get("Name") {
+		case anonymous:
+			t.Fatalf("Volume %s should be removed", anonymous)
+		case "kept":
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal("Volume kept should not be removed")
+	}
+}
+
+func TestVolumeCreateInspectRm(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer nuke(mkRuntimeFromEngine(eng, t))
+
+	var name string
+	job := eng.Job("volume_create", "data")
+	job.Stdout.AddString(&name)
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if name != "data" {
+		t.Fatalf("Expected volume data, got %s", name)
+	}
+	if err := eng.Job("volume_create", "data").Run(); err == nil {
+		t.Fatal("Expected an error creating a volume with the name of another")
+	}
+
+	job = eng.Job("volumes")
+	outs, err := job.Stdout.AddListTable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, out := range outs.Data {
+		if out.Get("Name") == "data" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("Expected the volume data, got %v", outs.Data)
+	}
+
+	job = eng.Job("volume_inspect", "data")
+	volume, err := job.Stdout.AddEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if volume.Get("Path") == "" {
+		t.Fatal("Expected the path of the volume")
+	}
+
+	if err := eng.Job("volume_delete", "data").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if err := eng.Job("volume_inspect", "data").Run(); err == nil {
+		t.Fatal("Expected an error inspecting a removed volume")
+	}
+}
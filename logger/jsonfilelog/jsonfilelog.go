@@ -0,0 +1,138 @@
+package jsonfilelog
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dotcloud/docker/logger"
+	"github.com/dotcloud/docker/utils"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const Name = "json-file"
+
+func init() {
+	logger.Register(Name, New)
+}
+
+// JSONFileLogger writes the output of a container to a file, one JSON
+// object per line, in the format read back by `docker logs`. When the
+// file reaches max-size it is rotated: <log> is renamed to <log>.1,
+// <log>.1 to <log>.2 and so on, keeping at most max-file files.
+type JSONFileLogger struct {
+	sync.Mutex
+	f        *os.File
+	path     string
+	size     int64 // current size of f
+	maxSize  int64 // 0 means no rotation
+	maxFiles int
+}
+
+func New(ctx logger.Context) (logger.Logger, error) {
+	l := &JSONFileLogger{
+		path:     ctx.LogPath,
+		maxFiles: 1,
+	}
+	for key, value := range ctx.Config {
+		switch key {
+		case "max-size":
+			size, err := utils.RAMInBytes(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid max-size for the %s log driver: %s", Name, value)
+			}
+			l.maxSize = size
+		case "max-file":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("Invalid max-file for the %s log driver: %s", Name, value)
+			}
+			l.maxFiles = n
+		default:
+			return nil, fmt.Errorf("Unknown option for the %s log driver: %s", Name, key)
+		}
+	}
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.f = f
+	l.size = fi.Size()
+	return l, nil
+}
+
+func (l *JSONFileLogger) Name() string {
+	return Name
+}
+
+func (l *JSONFileLogger) Log(msg *logger.Message) error {
+	b, err := json.Marshal(&utils.JSONLog{Log: string(msg.Line) + "\n", Stream: msg.Source, Created: msg.Timestamp})
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.f == nil {
+		return fmt.Errorf("The log file %s is closed", l.path)
+	}
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(b)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(b)
+	l.size += int64(n)
+	return err
+}
+
+// rotate must be called with the lock held
+func (l *JSONFileLogger) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	l.f = nil
+
+	if l.maxFiles > 1 {
+		for i := l.maxFiles - 1; i > 1; i-- {
+			if err := os.Rename(rotatedPath(l.path, i-1), rotatedPath(l.path, i)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(l.path, rotatedPath(l.path, 1)); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	l.f = f
+	l.size = 0
+	return nil
+}
+
+func (l *JSONFileLogger) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f = nil
+	return err
+}
+
+func rotatedPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
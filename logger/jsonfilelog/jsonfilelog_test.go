@@ -0,0 +1,118 @@
+package jsonfilelog
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/dotcloud/docker/logger"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func readLines(t *testing.T, filename string) []string {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var l utils.JSONLog
+		if err := json.Unmarshal(s.Bytes(), &l); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, l.Log)
+	}
+	return lines
+}
+
+func TestLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonfilelog-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "container-json.log")
+	l, err := New(logger.Context{LogPath: filename})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Log(&logger.Message{Line: []byte("hello"), Source: "stderr", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log utils.JSONLog
+	if err := json.Unmarshal(content, &log); err != nil {
+		t.Fatal(err)
+	}
+	if log.Log != "hello\n" || log.Stream != "stderr" {
+		t.Fatalf("Unexpected log entry: %s", content)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jsonfilelog-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := path.Join(dir, "container-json.log")
+	// Each entry is 72 bytes long, so that every file holds 2 of them
+	timestamp := time.Date(2014, 3, 1, 10, 20, 30, 123456789, time.UTC)
+	l, err := New(logger.Context{
+		Config:  map[string]string{"max-size": "150", "max-file": "3"},
+		LogPath: filename,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"1", "2", "3", "4", "5", "6", "7"} {
+		if err := l.Log(&logger.Message{Line: []byte(line), Source: "stdout", Timestamp: timestamp}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l.Close()
+
+	for filename, expected := range map[string][]string{
+		filename:        {"7\n"},
+		filename + ".1": {"5\n", "6\n"},
+		filename + ".2": {"3\n", "4\n"},
+	} {
+		lines := readLines(t, filename)
+		if len(lines) != len(expected) {
+			t.Fatalf("Expected %d lines in %s, got %d", len(expected), filename, len(lines))
+		}
+		for i := range lines {
+			if lines[i] != expected[i] {
+				t.Fatalf("Expected %q in %s, got %q", expected[i], filename, lines[i])
+			}
+		}
+	}
+	if _, err := os.Stat(filename + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("Expected at most 3 log files")
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, config := range []map[string]string{
+		{"max-size": "lots"},
+		{"max-file": "0"},
+		{"unknown": "1"},
+	} {
+		if _, err := New(logger.Context{Config: config, LogPath: "/nonexistent"}); err == nil {
+			t.Fatalf("Expected an error for %v", config)
+		}
+	}
+}
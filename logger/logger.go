@@ -0,0 +1,126 @@
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+	"sync"
+	"time"
+)
+
+// Message is a line written by a container to one of its streams
+type Message struct {
+	Line      []byte // without the trailing newline
+	Source    string // "stdout" or "stderr"
+	Timestamp time.Time
+}
+
+// Logger stores the output of a container. It is used by the stdout and
+// stderr of a container at the same time and has to be safe for
+// concurrent use.
+type Logger interface {
+	Name() string
+	Log(msg *Message) error
+	Close() error
+}
+
+// Context describes the container a logger is created for
+type Context struct {
+	Config        map[string]string // Options of the driver, given with --log-opt
+	ContainerID   string
+	ContainerName string
+	LogPath       string // Where a driver storing the logs on disk writes them
+}
+
+type InitFunc func(ctx Context) (Logger, error)
+
+// The driver which doesn't store the output of containers at all. It is
+// not backed by a Logger.
+const NoneDriver = "none"
+
+var (
+	// All registered drivers
+	drivers = make(map[string]InitFunc)
+)
+
+func Register(name string, initFunc InitFunc) error {
+	if _, exists := drivers[name]; exists {
+		return fmt.Errorf("Name already registered %s", name)
+	}
+	drivers[name] = initFunc
+
+	return nil
+}
+
+// Exists returns whether name can be used as the log driver of a container
+func Exists(name string) bool {
+	if name == NoneDriver {
+		return true
+	}
+	_, exists := drivers[name]
+	return exists
+}
+
+func New(name string, ctx Context) (Logger, error) {
+	initFunc, exists := drivers[name]
+	if !exists {
+		return nil, fmt.Errorf("No such log driver: %s", name)
+	}
+	return initFunc(ctx)
+}
+
+// NewWriter returns a writer which sends each line written to it to l as
+// a message from source. A last incomplete line is sent on Close. Closing
+// the writer doesn't close l, which is shared by both streams.
+func NewWriter(l Logger, source string) *Writer {
+	return &Writer{logger: l, source: source}
+}
+
+type Writer struct {
+	sync.Mutex
+	logger Logger
+	source string
+	buf    []byte // incomplete line
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := w.buf[:i]
+		w.buf = w.buf[i+1:]
+		// Returning the error would make the broadcaster drop the writer
+		// and lose every following line, e.g. when a syslog server is
+		// restarting
+		if err := w.log(line); err != nil {
+			utils.Errorf("Error logging to %s: %s", w.logger.Name(), err)
+		}
+	}
+	return len(p), nil
+}
+
+func (w *Writer) Close() error {
+	w.Lock()
+	defer w.Unlock()
+
+	if len(w.buf) == 0 {
+		return nil
+	}
+	line := w.buf
+	w.buf = nil
+	return w.log(line)
+}
+
+func (w *Writer) log(line []byte) error {
+	return w.logger.Log(&Message{
+		Line:      append([]byte(nil), line...),
+		Source:    w.source,
+		Timestamp: time.Now().UTC(),
+	})
+}
@@ -0,0 +1,52 @@
+package logger
+
+import (
+	"sync"
+	"testing"
+)
+
+type recorder struct {
+	sync.Mutex
+	messages []*Message
+}
+
+func (r *recorder) Name() string { return "recorder" }
+
+func (r *recorder) Log(msg *Message) error {
+	r.Lock()
+	r.messages = append(r.messages, msg)
+	r.Unlock()
+	return nil
+}
+
+func (r *recorder) Close() error { return nil }
+
+func TestWriterSplitsLines(t *testing.T) {
+	r := &recorder{}
+	w := NewWriter(r, "stdout")
+
+	for _, s := range []string{"hello\nwor", "ld\n", "\n", "partial"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(r.messages) != 3 {
+		t.Fatalf("Expected 3 messages before Close, got %d", len(r.messages))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"hello", "world", "", "partial"}
+	if len(r.messages) != len(expected) {
+		t.Fatalf("Expected %d messages, got %d", len(expected), len(r.messages))
+	}
+	for i, msg := range r.messages {
+		if string(msg.Line) != expected[i] {
+			t.Fatalf("Expected message %d to be %q, got %q", i, expected[i], msg.Line)
+		}
+		if msg.Source != "stdout" {
+			t.Fatalf("Expected stdout as the source, got %s", msg.Source)
+		}
+	}
+}
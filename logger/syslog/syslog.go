@@ -0,0 +1,223 @@
+package syslog
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/logger"
+	"github.com/dotcloud/docker/utils"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const Name = "syslog"
+
+// Severities of the messages, as defined by RFC 5424
+const (
+	severityErr  = 3
+	severityInfo = 6
+)
+
+var facilities = map[string]int{
+	"kern":     0,
+	"user":     1,
+	"mail":     2,
+	"daemon":   3,
+	"auth":     4,
+	"syslog":   5,
+	"lpr":      6,
+	"news":     7,
+	"uucp":     8,
+	"cron":     9,
+	"authpriv": 10,
+	"ftp":      11,
+	"local0":   16,
+	"local1":   17,
+	"local2":   18,
+	"local3":   19,
+	"local4":   20,
+	"local5":   21,
+	"local6":   22,
+	"local7":   23,
+}
+
+// Sockets of the local syslog daemon, depending on the distribution
+var localSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+func init() {
+	logger.Register(Name, New)
+}
+
+// SyslogLogger sends the output of a container to a syslog server, one
+// RFC 5424 message per line. Lines of stdout are sent with the info
+// severity and lines of stderr with the err severity.
+type SyslogLogger struct {
+	sync.Mutex
+	network  string // "" for the local syslog daemon
+	address  string
+	conn     net.Conn
+	facility int
+	tag      string
+	hostname string
+}
+
+func New(ctx logger.Context) (logger.Logger, error) {
+	l := &SyslogLogger{
+		facility: facilities["daemon"],
+		tag:      utils.TruncateID(ctx.ContainerID),
+		hostname: "-",
+	}
+	for key, value := range ctx.Config {
+		switch key {
+		case "syslog-address":
+			network, address, err := parseAddress(value)
+			if err != nil {
+				return nil, err
+			}
+			l.network, l.address = network, address
+		case "syslog-facility":
+			facility, exists := facilities[value]
+			if !exists {
+				return nil, fmt.Errorf("Unknown syslog facility: %s", value)
+			}
+			l.facility = facility
+		case "syslog-tag":
+			l.tag = value
+		default:
+			return nil, fmt.Errorf("Unknown option for the %s log driver: %s", Name, key)
+		}
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		l.hostname = hostname
+	}
+	if err := l.connect(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// parseAddress splits an address of the form unix:///dev/log,
+// udp://host:514 or tcp://host:514
+func parseAddress(address string) (string, string, error) {
+	parts := strings.SplitN(address, "://", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("Invalid syslog address: %s", address)
+	}
+	switch parts[0] {
+	case "unix", "udp", "tcp":
+		return parts[0], parts[1], nil
+	}
+	return "", "", fmt.Errorf("Unsupported syslog protocol: %s", parts[0])
+}
+
+// connect must be called with the lock held
+func (l *SyslogLogger) connect() error {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+
+	var addresses []string
+	switch l.network {
+	case "":
+		addresses = localSockets
+	case "unix":
+		addresses = []string{l.address}
+	default:
+		conn, err := net.DialTimeout(l.network, l.address, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		l.conn = conn
+		return nil
+	}
+
+	var err error
+	for _, address := range addresses {
+		// Syslog daemons usually listen on datagram sockets
+		for _, network := range []string{"unixgram", "unix"} {
+			var conn net.Conn
+			if conn, err = net.Dial(network, address); err == nil {
+				l.conn = conn
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Unable to connect to the syslog daemon: %s", err)
+}
+
+func (l *SyslogLogger) Name() string {
+	return Name
+}
+
+func (l *SyslogLogger) Log(msg *logger.Message) error {
+	severity := severityInfo
+	if msg.Source == "stderr" {
+		severity = severityErr
+	}
+	data := []byte(format(l.facility*8+severity, msg.Timestamp, l.hostname, l.tag, msg.Line))
+	if l.network == "tcp" {
+		// RFC 6587 octet counting, as messages might contain newlines
+		data = append([]byte(fmt.Sprintf("%d ", len(data))), data...)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.conn == nil {
+		if err := l.connect(); err != nil {
+			return err
+		}
+	}
+	if _, err := l.conn.Write(data); err != nil {
+		// The server might have been restarted, try once more
+		if err := l.connect(); err != nil {
+			return err
+		}
+		_, err = l.conn.Write(data)
+		return err
+	}
+	return nil
+}
+
+func (l *SyslogLogger) Close() error {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.conn == nil {
+		return nil
+	}
+	err := l.conn.Close()
+	l.conn = nil
+	return err
+}
+
+// format returns a message in the format of RFC 5424:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func format(priority int, timestamp time.Time, hostname, tag string, line []byte) string {
+	return fmt.Sprintf("<%d>1 %s %s %s - - - %s",
+		priority,
+		timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(hostname, 255),
+		headerField(tag, 48),
+		line)
+}
+
+// Header fields are limited to printable ASCII characters other than
+// space, and "-" stands for an empty value
+func headerField(value string, maxLen int) string {
+	field := strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+	if field == "" {
+		return "-"
+	}
+	if len(field) > maxLen {
+		field = field[:maxLen]
+	}
+	return field
+}
@@ -0,0 +1,82 @@
+package syslog
+
+import (
+	"github.com/dotcloud/docker/logger"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	timestamp := time.Date(2014, 3, 1, 10, 20, 30, 123456789, time.UTC)
+	out := format(3*8+6, timestamp, "host", "my app", []byte("hello world"))
+	expected := "<30>1 2014-03-01T10:20:30.123456Z host my_app - - - hello world"
+	if out != expected {
+		t.Fatalf("Expected %q, got %q", expected, out)
+	}
+
+	if out := format(0, timestamp, "", "", nil); out != "<0>1 2014-03-01T10:20:30.123456Z - - - - - " {
+		t.Fatalf("Expected empty fields to be replaced by -, got %q", out)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	network, address, err := parseAddress("udp://127.0.0.1:514")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if network != "udp" || address != "127.0.0.1:514" {
+		t.Fatalf("Unexpected address %s %s", network, address)
+	}
+	for _, invalid := range []string{"", "127.0.0.1:514", "http://host", "tcp://"} {
+		if _, _, err := parseAddress(invalid); err == nil {
+			t.Fatalf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestLogToUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syslog-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := path.Join(dir, "log")
+	server, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	l, err := New(logger.Context{
+		Config:      map[string]string{"syslog-address": "unix://" + socket, "syslog-facility": "local0"},
+		ContainerID: "0123456789abcdef",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if err := l.Log(&logger.Message{Line: []byte("oops"), Source: "stderr", Timestamp: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1024)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// local0 is 16, err is 3
+	msg := string(buf[:n])
+	if msg[:7] != "<131>1 " {
+		t.Fatalf("Unexpected priority in %q", msg)
+	}
+	if suffix := " 0123456789ab - - - oops"; msg[len(msg)-len(suffix):] != suffix {
+		t.Fatalf("Expected %q to end with %q", msg, suffix)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"github.com/dotcloud/docker/api"
 	"github.com/dotcloud/docker/utils"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -137,6 +140,38 @@ func ValidateHost(val string) (string, error) {
 	return host, nil
 }
 
+// ValidateMirror checks that val is the URL of a registry mirror, and
+// returns the endpoint of its API, such as https://mirror.local/v1/
+func ValidateMirror(val string) (string, error) {
+	uri, err := url.Parse(val)
+	if err != nil {
+		return "", fmt.Errorf("%s is not a valid URI: %s", val, err)
+	}
+	if uri.Scheme != "http" && uri.Scheme != "https" {
+		return "", fmt.Errorf("Unsupported scheme %s for the mirror %s, expected http or https", uri.Scheme, val)
+	}
+	if uri.Host == "" || (uri.Path != "" && uri.Path != "/" && uri.Path != "/v1/") || uri.RawQuery != "" || uri.Fragment != "" {
+		return "", fmt.Errorf("%s is not the root URL of a mirror, such as https://mirror.local", val)
+	}
+	return fmt.Sprintf("%s://%s/v1/", uri.Scheme, uri.Host), nil
+}
+
+// ValidateInsecureRegistry checks that val is the address of a registry, a
+// host with an optional port such as registry.local:5000
+func ValidateInsecureRegistry(val string) (string, error) {
+	host := val
+	if h, port, err := net.SplitHostPort(val); err == nil {
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+			return "", fmt.Errorf("Invalid port in the registry address %s", val)
+		}
+		host = h
+	}
+	if host == "" || strings.ContainsAny(host, ":/?#@") {
+		return "", fmt.Errorf("%s is not the address of a registry, such as registry.local:5000", val)
+	}
+	return val, nil
+}
+
 func ValidateIp4Address(val string) (string, error) {
 	re := regexp.MustCompile(`^(([0-9]+\.){3}([0-9]+))\s*$`)
 	var ns = re.FindSubmatch([]byte(val))
@@ -22,3 +22,36 @@ func TestValidateIP4(t *testing.T) {
 	}
 
 }
+
+func TestValidateMirror(t *testing.T) {
+	valid := map[string]string{
+		"https://mirror.local":         "https://mirror.local/v1/",
+		"http://10.0.0.1:5000/":        "http://10.0.0.1:5000/v1/",
+		"https://mirror.local:443/v1/": "https://mirror.local:443/v1/",
+	}
+	for val, expected := range valid {
+		if ret, err := ValidateMirror(val); err != nil || ret != expected {
+			t.Fatalf("ValidateMirror(`%s`) got %s %v, expected %s", val, ret, err, expected)
+		}
+	}
+
+	for _, val := range []string{"mirror.local", "ftp://mirror.local", "https://", "https://mirror.local/foo", "https://mirror.local/?q=1"} {
+		if ret, err := ValidateMirror(val); err == nil {
+			t.Fatalf("ValidateMirror(`%s`) got %s, expected an error", val, ret)
+		}
+	}
+}
+
+func TestValidateInsecureRegistry(t *testing.T) {
+	for _, val := range []string{"registry.local", "registry.local:5000", "10.0.0.1:5000"} {
+		if ret, err := ValidateInsecureRegistry(val); err != nil || ret != val {
+			t.Fatalf("ValidateInsecureRegistry(`%s`) got %s %v", val, ret, err)
+		}
+	}
+
+	for _, val := range []string{"", "http://registry.local", "registry.local/v1/", "registry.local:http", ":5000"} {
+		if ret, err := ValidateInsecureRegistry(val); err == nil {
+			t.Fatalf("ValidateInsecureRegistry(`%s`) got %s, expected an error", val, ret)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package cgroups
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cgroup describes the control group a container's processes are placed
+// in, along with the resource limits applied to it.
+type Cgroup struct {
+	Name   string `json:"name,omitempty"`
+	Parent string `json:"parent,omitempty"` // relative to the cgroup of the docker daemon
+
+	DeviceAccess bool  `json:"device_access,omitempty"` // allow access to all host devices
+	Memory       int64 `json:"memory,omitempty"`        // Memory limit (in bytes)
+	MemorySwap   int64 `json:"memory_swap,omitempty"`   // Total memory usage (memory + swap); set `-1' to disable swap
+	CpuShares    int64 `json:"cpu_shares,omitempty"`    // CPU shares (relative weight vs. other containers)
+}
+
+// Subsystems a container is always placed in when they are mounted
+var subsystems = []string{"devices", "memory", "cpu", "cpuacct", "blkio", "freezer"}
+
+// Devices containers are allowed to access when DeviceAccess is not set.
+// This mirrors the whitelist of the lxc template.
+var allowedDevices = []string{
+	"c 1:3 rwm", "c 1:5 rwm", // /dev/null and zero
+	"c 5:1 rwm", "c 5:0 rwm", "c 4:0 rwm", "c 4:1 rwm", // consoles
+	"c 1:9 rwm", "c 1:8 rwm", // /dev/urandom,/dev/random
+	"c 136:* rwm", "c 5:2 rwm", // /dev/pts/ and /dev/ptmx
+	"c 10:200 rwm", // tuntap
+}
+
+// Path returns the absolute path of the cgroup for the given subsystem.
+func (c *Cgroup) Path(subsystem string) (string, error) {
+	mountpoint, err := FindCgroupMountpoint(subsystem)
+	if err != nil {
+		return "", err
+	}
+	initPath, err := GetThisCgroupDir(subsystem)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(mountpoint, initPath, c.Parent, c.Name), nil
+}
+
+// Apply creates the cgroup in every mounted subsystem, moves pid into it
+// and writes the configured limits.
+func (c *Cgroup) Apply(pid int) error {
+	for _, subsystem := range subsystems {
+		dir, err := c.Path(subsystem)
+		if err != nil {
+			// Optional subsystems might not be mounted on this host
+			if c.requires(subsystem) {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+		if err := c.setup(subsystem, dir); err != nil {
+			return err
+		}
+		if err := writeFile(dir, "tasks", strconv.Itoa(pid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cleanup removes the cgroup from every subsystem it was created in.
+// It must only be called once all processes have exited.
+func (c *Cgroup) Cleanup() error {
+	for _, subsystem := range subsystems {
+		dir, err := c.Path(subsystem)
+		if err != nil {
+			continue
+		}
+		if err := os.Remove(dir); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Paths returns the directories of the cgroup in every subsystem it
+// exists in, keyed by subsystem.
+func (c *Cgroup) Paths() map[string]string {
+	paths := make(map[string]string)
+	for _, subsystem := range subsystems {
+		dir, err := c.Path(subsystem)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		paths[subsystem] = dir
+	}
+	return paths
+}
+
+// GetPids returns the pids of all processes in the cgroup.
+func (c *Cgroup) GetPids() ([]int, error) {
+	// devices is always enabled and any cgroup used by docker works
+	dir, err := c.Path("devices")
+	if err != nil {
+		return nil, err
+	}
+	return readPids(filepath.Join(dir, "tasks"))
+}
+
+// Freeze suspends every process of the cgroup using the freezer
+// subsystem, and returns once all of them are frozen.
+func (c *Cgroup) Freeze() error {
+	return c.setFreezerState("FROZEN")
+}
+
+// Thaw resumes the processes of a frozen cgroup.
+func (c *Cgroup) Thaw() error {
+	return c.setFreezerState("THAWED")
+}
+
+func (c *Cgroup) setFreezerState(state string) error {
+	dir, err := c.Path("freezer")
+	if err != nil {
+		return err
+	}
+	// Freezing is not atomic: the cgroup stays FREEZING until the kernel
+	// managed to stop every task, which might require writing the state
+	// again.
+	for i := 0; i < 1000; i++ {
+		if err := writeFile(dir, "freezer.state", state); err != nil {
+			return err
+		}
+		current, err := ioutil.ReadFile(filepath.Join(dir, "freezer.state"))
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(string(current)) == state {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("Timeout while setting the freezer state of %s to %s", c.Name, state)
+}
+
+func (c *Cgroup) requires(subsystem string) bool {
+	switch subsystem {
+	case "devices":
+		return !c.DeviceAccess
+	case "memory":
+		return c.Memory != 0
+	case "cpu":
+		return c.CpuShares != 0
+	}
+	return false
+}
+
+func (c *Cgroup) setup(subsystem, dir string) error {
+	switch subsystem {
+	case "devices":
+		if c.DeviceAccess {
+			return nil
+		}
+		if err := writeFile(dir, "devices.deny", "a"); err != nil {
+			return err
+		}
+		for _, device := range allowedDevices {
+			if err := writeFile(dir, "devices.allow", device); err != nil {
+				return err
+			}
+		}
+	case "memory":
+		if c.Memory == 0 {
+			return nil
+		}
+		if err := writeFile(dir, "memory.limit_in_bytes", strconv.FormatInt(c.Memory, 10)); err != nil {
+			return err
+		}
+		if err := writeFile(dir, "memory.soft_limit_in_bytes", strconv.FormatInt(c.Memory, 10)); err != nil {
+			return err
+		}
+		// By default, MemorySwap is set to twice the size of RAM.
+		// If you want to omit MemorySwap, set it to `-1'.
+		if c.MemorySwap >= 0 {
+			if err := writeFile(dir, "memory.memsw.limit_in_bytes", strconv.FormatInt(c.Memory*2, 10)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	case "cpu":
+		if c.CpuShares == 0 {
+			return nil
+		}
+		return writeFile(dir, "cpu.shares", strconv.FormatInt(c.CpuShares, 10))
+	}
+	return nil
+}
+
+func writeFile(dir, file, data string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(data), 0700)
+}
+
+func readPids(filename string) ([]int, error) {
+	pids := []int{}
+	output, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return pids, err
+	}
+	for _, p := range strings.Split(string(output), "\n") {
+		if len(p) == 0 {
+			continue
+		}
+		pid, err := strconv.Atoi(p)
+		if err != nil {
+			return pids, fmt.Errorf("Invalid pid '%s': %s", p, err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
@@ -25,3 +25,14 @@ func TestParseCgroups(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestParseCgroupsCombinedSubsystems(t *testing.T) {
+	r := bytes.NewBuffer([]byte(cgroupsContents))
+	dir, err := parseCgroupFile("cpu", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir != "/" {
+		t.Fatalf("Expected / for the cpu cgroup, got %s", dir)
+	}
+}
@@ -0,0 +1,88 @@
+package cgroups
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Stats holds the resource usage of a cgroup
+type Stats struct {
+	CpuUsage    uint64 `json:"cpu_usage"` // Total CPU time consumed (in nanoseconds)
+	MemoryUsage uint64 `json:"memory_usage"`
+	MemoryLimit uint64 `json:"memory_limit"`
+	BlkioRead   uint64 `json:"blkio_read"`  // Bytes read from block devices
+	BlkioWrite  uint64 `json:"blkio_write"` // Bytes written to block devices
+}
+
+// Stats reads the resource usage of the cgroup. Subsystems which are not
+// mounted on this host are reported as zero.
+func (c *Cgroup) Stats() (*Stats, error) {
+	stats := &Stats{}
+
+	if dir, err := c.Path("cpuacct"); err == nil {
+		if stats.CpuUsage, err = readUint(filepath.Join(dir, "cpuacct.usage")); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir, err := c.Path("memory"); err == nil {
+		if stats.MemoryUsage, err = readUint(filepath.Join(dir, "memory.usage_in_bytes")); err != nil {
+			return nil, err
+		}
+		if stats.MemoryLimit, err = readUint(filepath.Join(dir, "memory.limit_in_bytes")); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir, err := c.Path("blkio"); err == nil {
+		f, err := os.Open(filepath.Join(dir, "blkio.throttle.io_service_bytes"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			defer f.Close()
+			if err := parseBlkioStats(f, stats); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+func readUint(filename string) (uint64, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// Lines are in the format of
+// MAJOR:MINOR Read|Write|Sync|Async|Total BYTES
+// followed by a grand total.
+func parseBlkioStats(r io.Reader, stats *Stats) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		switch fields[1] {
+		case "Read":
+			stats.BlkioRead += value
+		case "Write":
+			stats.BlkioWrite += value
+		}
+	}
+	return s.Err()
+}
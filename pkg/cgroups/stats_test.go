@@ -0,0 +1,31 @@
+package cgroups
+
+import (
+	"bytes"
+	"testing"
+)
+
+const blkioContents = `8:0 Read 4096
+8:0 Write 8192
+8:0 Sync 12288
+8:0 Async 0
+8:0 Total 12288
+8:16 Read 1024
+8:16 Write 0
+8:16 Sync 1024
+8:16 Async 0
+8:16 Total 1024
+Total 13312`
+
+func TestParseBlkioStats(t *testing.T) {
+	stats := &Stats{}
+	if err := parseBlkioStats(bytes.NewBufferString(blkioContents), stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.BlkioRead != 5120 {
+		t.Fatalf("Expected 5120 bytes read, got %d", stats.BlkioRead)
+	}
+	if stats.BlkioWrite != 8192 {
+		t.Fatalf("Expected 8192 bytes written, got %d", stats.BlkioWrite)
+	}
+}
@@ -10,6 +10,13 @@ import (
 	"unsafe"
 )
 
+const (
+	IFLA_INFO_KIND  = 1
+	IFLA_INFO_DATA  = 2
+	VETH_INFO_PEER  = 1
+	IFLA_NET_NS_PID = 19
+)
+
 var nextSeqNr int
 
 func nativeEndian() binary.ByteOrder {
@@ -459,8 +466,6 @@ func NetworkLinkAdd(name string, linkType string) error {
 	nameData := newRtAttr(syscall.IFLA_IFNAME, zeroTerminated(name))
 	wb.AddData(nameData)
 
-	IFLA_INFO_KIND := 1
-
 	kindData := newRtAttr(IFLA_INFO_KIND, nonZeroTerminated(linkType))
 
 	infoData := newRtAttr(syscall.IFLA_LINKINFO, kindData.ToWireFormat())
@@ -473,6 +478,94 @@ func NetworkLinkAdd(name string, linkType string) error {
 	return s.HandleAck(wb.Seq)
 }
 
+func nestedRtAttr(attrType int, children ...*RtAttr) *RtAttr {
+	var data []byte
+	for _, child := range children {
+		data = append(data, child.ToWireFormat()...)
+	}
+	return newRtAttr(attrType, data)
+}
+
+func uint32Attr(attrType int, v uint32) *RtAttr {
+	b := make([]byte, 4)
+	nativeEndian().PutUint32(b, v)
+	return newRtAttr(attrType, b)
+}
+
+// Send a RTM_SETLINK request for the given interface carrying a single
+// attribute and wait for the kernel to acknowledge it.
+func networkSetLinkAttr(iface *net.Interface, attr *RtAttr) error {
+	s, err := getNetlinkSocket()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	wb := newNetlinkRequest(syscall.RTM_SETLINK, syscall.NLM_F_ACK)
+
+	msg := newIfInfomsg(syscall.AF_UNSPEC)
+	msg.Type = syscall.RTM_SETLINK
+	msg.Flags = syscall.NLM_F_REQUEST
+	msg.Index = int32(iface.Index)
+	msg.Change = 0xFFFFFFFF
+	wb.AddData(msg)
+	wb.AddData(attr)
+
+	if err := s.Send(wb); err != nil {
+		return err
+	}
+	return s.HandleAck(wb.Seq)
+}
+
+// Create a pair of connected veth interfaces. This is identical to
+// running: ip link add name $name1 type veth peer name $name2
+func NetworkCreateVethPair(name1, name2 string) error {
+	s, err := getNetlinkSocket()
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	wb := newNetlinkRequest(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL|syscall.NLM_F_ACK)
+
+	msg := newIfInfomsg(syscall.AF_UNSPEC)
+	wb.AddData(msg)
+
+	nameData := newRtAttr(syscall.IFLA_IFNAME, zeroTerminated(name1))
+	wb.AddData(nameData)
+
+	// The peer is described by its own ifinfomsg followed by its attributes
+	peer := newIfInfomsg(syscall.AF_UNSPEC).ToWireFormat()
+	peer = append(peer, newRtAttr(syscall.IFLA_IFNAME, zeroTerminated(name2)).ToWireFormat()...)
+
+	infoData := nestedRtAttr(IFLA_INFO_DATA, newRtAttr(VETH_INFO_PEER, peer))
+	kindData := newRtAttr(IFLA_INFO_KIND, nonZeroTerminated("veth"))
+	wb.AddData(nestedRtAttr(syscall.IFLA_LINKINFO, kindData, infoData))
+
+	if err := s.Send(wb); err != nil {
+		return err
+	}
+	return s.HandleAck(wb.Seq)
+}
+
+// Attach an interface to a bridge. This is identical to running:
+// ip link set $iface master $master
+func NetworkSetMaster(iface, master *net.Interface) error {
+	return networkSetLinkAttr(iface, uint32Attr(syscall.IFLA_MASTER, uint32(master.Index)))
+}
+
+// Move an interface into the network namespace of the given pid. This is
+// identical to running: ip link set $iface netns $nspid
+func NetworkSetNsPid(iface *net.Interface, nspid int) error {
+	return networkSetLinkAttr(iface, uint32Attr(IFLA_NET_NS_PID, uint32(nspid)))
+}
+
+// Rename an interface. The interface must be down. This is identical to
+// running: ip link set $iface name $newName
+func NetworkChangeName(iface *net.Interface, newName string) error {
+	return networkSetLinkAttr(iface, newRtAttr(syscall.IFLA_IFNAME, zeroTerminated(newName)))
+}
+
 // Returns an array of IPNet for all the currently routed subnets on ipv4
 // This is similar to the first column of "ip route" output
 func NetworkGetRoutes() ([]Route, error) {
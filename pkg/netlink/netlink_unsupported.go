@@ -31,3 +31,19 @@ func AddDefaultGw(ip net.IP) error {
 func NetworkSetMTU(iface *net.Interface, mtu int) error {
 	return fmt.Errorf("Not implemented")
 }
+
+func NetworkCreateVethPair(name1, name2 string) error {
+	return fmt.Errorf("Not implemented")
+}
+
+func NetworkSetMaster(iface, master *net.Interface) error {
+	return fmt.Errorf("Not implemented")
+}
+
+func NetworkSetNsPid(iface *net.Interface, nspid int) error {
+	return fmt.Errorf("Not implemented")
+}
+
+func NetworkChangeName(iface *net.Interface, newName string) error {
+	return fmt.Errorf("Not implemented")
+}
@@ -0,0 +1,54 @@
+package tailfile
+
+import (
+	"io"
+)
+
+const blockSize = 4096
+
+// Offset returns the offset at which the last n lines of f begin, reading
+// f backwards by blocks so that only the end of a large file is read.
+// A last line without a trailing newline counts as a line.
+func Offset(f io.ReadSeeker, n int) (int64, error) {
+	size, err := f.Seek(0, 2)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 {
+		return size, nil
+	}
+
+	var (
+		end   = size
+		found = 0
+		block = make([]byte, blockSize)
+	)
+	for end > 0 {
+		start := end - blockSize
+		if start < 0 {
+			start = 0
+		}
+		if _, err := f.Seek(start, 0); err != nil {
+			return 0, err
+		}
+		buf := block[:end-start]
+		if _, err := io.ReadFull(f, buf); err != nil {
+			return 0, err
+		}
+		// The newline which ends the file does not start a line
+		if end == size && buf[len(buf)-1] == '\n' {
+			buf = buf[:len(buf)-1]
+		}
+		for i := len(buf) - 1; i >= 0; i-- {
+			if buf[i] != '\n' {
+				continue
+			}
+			if found++; found == n {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	// The file has less than n lines
+	return 0, nil
+}
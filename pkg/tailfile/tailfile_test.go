@@ -0,0 +1,49 @@
+package tailfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func tail(t *testing.T, content string, n int) string {
+	r := strings.NewReader(content)
+	offset, err := Offset(r, n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content[offset:]
+}
+
+func TestOffset(t *testing.T) {
+	content := "one\ntwo\nthree\n"
+	for n, expected := range []string{"", "three\n", "two\nthree\n", content, content} {
+		if out := tail(t, content, n); out != expected {
+			t.Fatalf("Expected %q for the last %d lines, got %q", expected, n, out)
+		}
+	}
+}
+
+func TestOffsetNoTrailingNewline(t *testing.T) {
+	if out := tail(t, "one\ntwo\nthree", 2); out != "two\nthree" {
+		t.Fatalf("Expected \"two\\nthree\", got %q", out)
+	}
+}
+
+func TestOffsetEmpty(t *testing.T) {
+	if out := tail(t, "", 10); out != "" {
+		t.Fatalf("Expected nothing, got %q", out)
+	}
+}
+
+func TestOffsetLargeFile(t *testing.T) {
+	line := strings.Repeat("x", 1000) + "\n"
+	content := bytes.Repeat([]byte(line), 100)
+	offset, err := Offset(bytes.NewReader(content), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := int64(len(line) * 90); offset != expected {
+		t.Fatalf("Expected offset %d, got %d", expected, offset)
+	}
+}
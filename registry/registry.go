@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"path"
 	"regexp"
 	"strconv"
 	"strings"
@@ -41,7 +42,15 @@ func pingRegistryEndpoint(endpoint string) (bool, error) {
 		conn.SetDeadline(time.Now().Add(time.Duration(10) * time.Second))
 		return conn, nil
 	}
-	httpTransport := &http.Transport{Dial: httpDial}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false, err
+	}
+	tlsConfig, err := newTLSConfig(u.Host)
+	if err != nil {
+		return false, err
+	}
+	httpTransport := &http.Transport{Dial: httpDial, TLSClientConfig: tlsConfig}
 	client := &http.Client{Transport: httpTransport}
 	resp, err := client.Get(endpoint + "_ping")
 	if err != nil {
@@ -91,8 +100,9 @@ func validateRepositoryName(repositoryName string) error {
 	return nil
 }
 
-// Resolves a repository name to a endpoint + name
-func ResolveRepositoryName(reposName string) (string, string, error) {
+// SplitReposName splits a repository name into the host[:port] of its
+// registry, empty for the index, and its name in the registry
+func SplitReposName(reposName string) (string, string, error) {
 	if strings.Contains(reposName, "://") {
 		// It cannot contain a scheme!
 		return "", "", ErrInvalidRepositoryName
@@ -102,7 +112,7 @@ func ResolveRepositoryName(reposName string) (string, string, error) {
 		nameParts[0] != "localhost" {
 		// This is a Docker Index repos (ex: samalba/hipache or ubuntu)
 		err := validateRepositoryName(reposName)
-		return auth.IndexServerAddress(), reposName, err
+		return "", reposName, err
 	}
 	if len(nameParts) < 2 {
 		// There is a dot in repos name (and no registry address)
@@ -117,6 +127,29 @@ func ResolveRepositoryName(reposName string) (string, string, error) {
 	if err := validateRepositoryName(reposName); err != nil {
 		return "", "", err
 	}
+	return hostname, reposName, nil
+}
+
+// RegistryAddress returns the address of the registry of a repository, to
+// find its auth config: the address of the index or the host[:port] of the
+// registry. Unlike ResolveRepositoryName, it doesn't reach the registry.
+func RegistryAddress(reposName string) (string, error) {
+	hostname, _, err := SplitReposName(reposName)
+	if err != nil {
+		return "", err
+	}
+	if hostname == "" {
+		return auth.IndexServerAddress(), nil
+	}
+	return hostname, nil
+}
+
+// Resolves a repository name to a endpoint + name
+func ResolveRepositoryName(reposName string) (string, string, error) {
+	hostname, reposName, err := SplitReposName(reposName)
+	if hostname == "" || err != nil {
+		return auth.IndexServerAddress(), reposName, err
+	}
 	endpoint, err := ExpandAndVerifyRegistryUrl(hostname)
 	if err != nil {
 		return "", "", err
@@ -126,7 +159,8 @@ func ResolveRepositoryName(reposName string) (string, string, error) {
 
 // this method expands the registry name as used in the prefix of a repo
 // to a full url. if it already is a url, there will be no change.
-// The registry is pinged to test if it http or https
+// The registry is pinged to test if it http or https. Only the insecure
+// registries may be reached over http.
 func ExpandAndVerifyRegistryUrl(hostname string) (string, error) {
 	if strings.HasPrefix(hostname, "http:") || strings.HasPrefix(hostname, "https:") {
 		// if there is no slash after https:// (8 characters) then we have no path in the url
@@ -134,6 +168,11 @@ func ExpandAndVerifyRegistryUrl(hostname string) (string, error) {
 			// there is no path given. Expand with default path
 			hostname = hostname + "/v1/"
 		}
+		if u, err := url.Parse(hostname); err != nil {
+			return "", err
+		} else if u.Scheme == "http" && !IsInsecure(u.Host) {
+			return "", fmt.Errorf("The registry %s may not be reached over http, unless the daemon is started with --insecure-registry %s", hostname, u.Host)
+		}
 		if _, err := pingRegistryEndpoint(hostname); err != nil {
 			return "", errors.New("Invalid Registry endpoint: " + err.Error())
 		}
@@ -141,6 +180,9 @@ func ExpandAndVerifyRegistryUrl(hostname string) (string, error) {
 	}
 	endpoint := fmt.Sprintf("https://%s/v1/", hostname)
 	if _, err := pingRegistryEndpoint(endpoint); err != nil {
+		if !IsInsecure(hostname) {
+			return "", fmt.Errorf("Invalid Registry endpoint %s: %s. If the registry uses a private CA, put its certificate in %s. If it only supports http, start the daemon with --insecure-registry %s", endpoint, err, path.Join(CertsDir, hostname), hostname)
+		}
 		utils.Debugf("Registry %s does not work (%s), falling back to http", endpoint, err)
 		endpoint = fmt.Sprintf("http://%s/v1/", hostname)
 		if _, err = pingRegistryEndpoint(endpoint); err != nil {
@@ -252,22 +294,44 @@ func (r *Registry) GetRemoteImageJSON(imgID, registry string, token []string) ([
 	return jsonString, imageSize, nil
 }
 
-func (r *Registry) GetRemoteImageLayer(imgID, registry string, token []string) (io.ReadCloser, error) {
+// GetRemoteImageLayer fetches the layer of imgID, from the byte offset
+// when it is not 0. The layer is fetched from its start when the registry
+// doesn't support ranges, and resumed reports whether it was fetched from
+// offset. size is the size of the whole layer, or -1 when it is unknown.
+func (r *Registry) GetRemoteImageLayer(imgID, registry string, token []string, offset int64) (layer io.ReadCloser, resumed bool, size int64, err error) {
 	req, err := r.reqFactory.NewRequest("GET", registry+"images/"+imgID+"/layer", nil)
 	if err != nil {
-		return nil, fmt.Errorf("Error while getting from the server: %s\n", err)
+		return nil, false, -1, fmt.Errorf("Error while getting from the server: %s\n", err)
 	}
 	setTokenAuth(req, token)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
 	res, err := doWithCookies(r.client, req)
 	if err != nil {
-		return nil, err
+		return nil, false, -1, err
 	}
-	if res.StatusCode != 200 {
-		res.Body.Close()
-		return nil, fmt.Errorf("Server error: Status %d while fetching image layer (%s)",
-			res.StatusCode, imgID)
+	switch {
+	case offset > 0 && res.StatusCode == 206:
+		// Content-Range: bytes <first>-<last>/<size>
+		size = -1
+		contentRange := res.Header.Get("Content-Range")
+		if i := strings.LastIndex(contentRange, "/"); i != -1 {
+			if n, err := strconv.ParseInt(contentRange[i+1:], 10, 64); err == nil {
+				size = n
+			}
+		}
+		if !strings.HasPrefix(contentRange, fmt.Sprintf("bytes %d-", offset)) {
+			res.Body.Close()
+			return nil, false, -1, fmt.Errorf("Invalid range %q while fetching image layer (%s) from %d", contentRange, imgID, offset)
+		}
+		return res.Body, true, size, nil
+	case res.StatusCode == 200:
+		return res.Body, false, res.ContentLength, nil
 	}
-	return res.Body, nil
+	res.Body.Close()
+	return nil, false, -1, utils.NewHTTPRequestError(fmt.Sprintf("Server error: Status %d while fetching image layer (%s)",
+		res.StatusCode, imgID), res)
 }
 
 func (r *Registry) GetRemoteTags(registries []string, repository string, token []string) (map[string]string, error) {
@@ -334,8 +398,8 @@ func (r *Registry) GetRepositoryData(remote string) (*RepositoryData, error) {
 	if res.StatusCode == 401 {
 		return nil, errLoginRequired
 	}
-	// TODO: Right now we're ignoring checksums in the response body.
-	// In the future, we need to use them to check image validity.
+	// The checksums in the response body are used to check the layers
+	// pulled
 	if res.StatusCode != 200 {
 		return nil, utils.NewHTTPRequestError(fmt.Sprintf("HTTP code: %d", res.StatusCode), res)
 	}
@@ -684,15 +748,10 @@ type Registry struct {
 }
 
 func NewRegistry(authConfig *auth.AuthConfig, factory *utils.HTTPRequestFactory, indexEndpoint string) (r *Registry, err error) {
-	httpTransport := &http.Transport{
-		DisableKeepAlives: true,
-		Proxy:             http.ProxyFromEnvironment,
-	}
-
 	r = &Registry{
 		authConfig: authConfig,
 		client: &http.Client{
-			Transport: httpTransport,
+			Transport: newTokenTransport(NewTransport(), authConfig),
 		},
 		indexEndpoint: indexEndpoint,
 	}
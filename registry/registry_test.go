@@ -1,8 +1,10 @@
 package registry
 
 import (
+	"bytes"
 	"github.com/dotcloud/docker/auth"
 	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
 	"strings"
 	"testing"
 )
@@ -69,15 +71,40 @@ func TestGetRemoteImageJSON(t *testing.T) {
 
 func TestGetRemoteImageLayer(t *testing.T) {
 	r := spawnTestRegistry(t)
-	data, err := r.GetRemoteImageLayer(IMAGE_ID, makeURL("/v1/"), TOKEN)
+	data, _, size, err := r.GetRemoteImageLayer(IMAGE_ID, makeURL("/v1/"), TOKEN, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
 	if data == nil {
 		t.Fatal("Expected non-nil data result")
 	}
+	layer, err := ioutil.ReadAll(data)
+	data.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != int64(len(layer)) {
+		t.Fatalf("Expected a size of %d, got %d", len(layer), size)
+	}
+
+	// Resume the download
+	data, resumed, size, err := r.GetRemoteImageLayer(IMAGE_ID, makeURL("/v1/"), TOKEN, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed || size != int64(len(layer)) {
+		t.Fatalf("Expected the download to be resumed with a size of %d, got %v and %d", len(layer), resumed, size)
+	}
+	rest, err := ioutil.ReadAll(data)
+	data.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, layer[10:]) {
+		t.Fatal("Expected the end of the layer")
+	}
 
-	_, err = r.GetRemoteImageLayer("abcdef", makeURL("/v1/"), TOKEN)
+	_, _, _, err = r.GetRemoteImageLayer("abcdef", makeURL("/v1/"), TOKEN, 0)
 	if err == nil {
 		t.Fatal("Expected image not found error")
 	}
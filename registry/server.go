@@ -0,0 +1,309 @@
+package registry
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dotcloud/docker/utils"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const serverVersion = "0.6.0"
+
+// Server serves the API of the index and of the registry for the images of
+// an ImageStore. It has no authentication: any client may pull, and may push
+// when the store is a WritableImageStore.
+type Server struct {
+	store  ImageStore
+	router *mux.Router
+}
+
+func NewServer(store ImageStore) *Server {
+	s := &Server{store: store}
+	r := mux.NewRouter()
+	r.HandleFunc("/v1/_ping", s.getPing).Methods("GET")
+	r.HandleFunc("/v1/images/{image_id:[^/]+}/{action:json|layer|ancestry}", s.getImage).Methods("GET", "HEAD")
+	r.HandleFunc("/v1/images/{image_id:[^/]+}/{action:json|layer|checksum}", s.putImage).Methods("PUT")
+	r.HandleFunc("/v1/repositories/{repository:.+}/tags", s.getTags).Methods("GET")
+	r.HandleFunc("/v1/repositories/{repository:.+}/tags/{tag:.+}", s.getTag).Methods("GET")
+	r.HandleFunc("/v1/repositories/{repository:.+}/tags/{tag:.+}", s.putTag).Methods("PUT")
+	r.HandleFunc("/v1/repositories/{repository:.+}/images", s.getImages).Methods("GET")
+	r.HandleFunc("/v1/repositories/{repository:.+}{action:/images|/}", s.putImages).Methods("PUT")
+	r.HandleFunc("/v1/search", s.search).Methods("GET")
+	s.router = r
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	utils.Debugf("[registry] %s \"%s %s\"", r.RemoteAddr, r.Method, r.URL)
+	h := w.Header()
+	h.Set("X-Docker-Registry-Version", serverVersion)
+	h.Set("X-Docker-Registry-Standalone", "true")
+	s.router.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}, code int) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// writeError answers with the JSON error the clients expect
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch err {
+	case ErrNotFound:
+		code = http.StatusNotFound
+	case ErrWrongChecksum, ErrAlreadyExists:
+		code = http.StatusBadRequest
+	}
+	if code == http.StatusInternalServerError {
+		utils.Errorf("[registry] %s", err)
+	}
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(body)
+}
+
+// writableStore returns the store of s if images may be pushed to it, and
+// otherwise refuses the request
+func (s *Server) writableStore(w http.ResponseWriter) (WritableImageStore, bool) {
+	store, ok := s.store.(WritableImageStore)
+	if !ok {
+		body, _ := json.Marshal(map[string]string{"error": "This registry is read-only"})
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		w.Write(body)
+	}
+	return store, ok
+}
+
+func (s *Server) getPing(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, true, http.StatusOK)
+}
+
+// ancestry returns the ids of image id and of its parents
+func (s *Server) ancestry(id string) ([]string, error) {
+	var ids []string
+	for id != "" {
+		jsonRaw, err := s.store.ImageJSON(id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		var img struct {
+			Parent string `json:"parent"`
+		}
+		if err := json.Unmarshal(jsonRaw, &img); err != nil {
+			return nil, err
+		}
+		id = img.Parent
+	}
+	return ids, nil
+}
+
+func (s *Server) getImage(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id := vars["image_id"]
+	switch vars["action"] {
+	case "json":
+		jsonRaw, err := s.store.ImageJSON(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		var img struct {
+			Size int64
+		}
+		if err := json.Unmarshal(jsonRaw, &img); err != nil {
+			writeError(w, err)
+			return
+		}
+		if checksum, err := s.store.ImageChecksum(id); err == nil && checksum != "" {
+			w.Header().Set("X-Docker-Checksum", checksum)
+		}
+		w.Header().Set("X-Docker-Size", strconv.FormatInt(img.Size, 10))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(jsonRaw)
+	case "ancestry":
+		ids, err := s.ancestry(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, ids, http.StatusOK)
+	case "layer":
+		layer, err := s.store.ImageLayer(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer layer.Close()
+		w.Header().Set("Content-Type", "application/octet-stream")
+		// Serve ranges, so that the clients can resume their downloads
+		if rs, ok := layer.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, "layer", time.Time{}, rs)
+			return
+		}
+		if r.Method == "HEAD" {
+			return
+		}
+		if _, err := io.Copy(w, layer); err != nil {
+			utils.Errorf("[registry] Error sending the layer of %s: %s", id, err)
+		}
+	}
+}
+
+func (s *Server) putImage(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.writableStore(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	id := vars["image_id"]
+	var err error
+	switch vars["action"] {
+	case "json":
+		var jsonRaw []byte
+		if jsonRaw, err = ioutil.ReadAll(r.Body); err != nil {
+			break
+		}
+		var img struct {
+			ID string `json:"id"`
+		}
+		if err = json.Unmarshal(jsonRaw, &img); err != nil {
+			break
+		}
+		if img.ID != id {
+			err = fmt.Errorf("The id of the image is %s, not %s", img.ID, id)
+			break
+		}
+		err = store.PutImageJSON(id, jsonRaw)
+	case "layer":
+		err = store.PutImageLayer(id, r.Body)
+	case "checksum":
+		err = store.PutImageChecksum(id, r.Header.Get("X-Docker-Checksum"))
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, true, http.StatusOK)
+}
+
+func (s *Server) getTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.store.Tags(NormalizeRepositoryName(mux.Vars(r)["repository"]))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, tags, http.StatusOK)
+}
+
+func (s *Server) getTag(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tags, err := s.store.Tags(NormalizeRepositoryName(vars["repository"]))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	id, exists := tags[vars["tag"]]
+	if !exists {
+		writeError(w, ErrNotFound)
+		return
+	}
+	writeJSON(w, id, http.StatusOK)
+}
+
+func (s *Server) putTag(w http.ResponseWriter, r *http.Request) {
+	store, ok := s.writableStore(w)
+	if !ok {
+		return
+	}
+	vars := mux.Vars(r)
+	var id string
+	if err := json.NewDecoder(r.Body).Decode(&id); err != nil {
+		writeError(w, err)
+		return
+	}
+	if err := store.SetTag(NormalizeRepositoryName(vars["repository"]), vars["tag"], id); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, true, http.StatusOK)
+}
+
+// writeEndpoints sends the registry endpoints for the index requests, which
+// are served by s too
+func writeEndpoints(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Docker-Endpoints", r.Host)
+	if r.Header.Get("X-Docker-Token") == "true" {
+		token := make([]byte, 16)
+		if _, err := rand.Read(token); err == nil {
+			w.Header().Set("X-Docker-Token", "signature="+hex.EncodeToString(token))
+		}
+	}
+}
+
+func (s *Server) getImages(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.store.Tags(NormalizeRepositoryName(mux.Vars(r)["repository"]))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	images := []*ImgData{}
+	for tag, id := range tags {
+		checksum, err := s.store.ImageChecksum(id)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		images = append(images, &ImgData{ID: id, Checksum: checksum, Tag: tag})
+	}
+	writeEndpoints(w, r)
+	writeJSON(w, images, http.StatusOK)
+}
+
+// putImages answers the index requests made before and after pushing a
+// repository. The images are only known to the registry.
+func (s *Server) putImages(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.writableStore(w); !ok {
+		return
+	}
+	if mux.Vars(r)["action"] == "/images" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeEndpoints(w, r)
+	writeJSON(w, "", http.StatusOK)
+}
+
+func (s *Server) search(w http.ResponseWriter, r *http.Request) {
+	term := r.URL.Query().Get("q")
+	names, err := s.store.Repositories()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	results := &SearchResults{Query: term, Results: []SearchResult{}}
+	for _, name := range names {
+		if strings.Contains(name, term) {
+			results.Results = append(results.Results, SearchResult{Name: name})
+		}
+	}
+	results.NumResults = len(results.Results)
+	writeJSON(w, results, http.StatusOK)
+}
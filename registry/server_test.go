@@ -0,0 +1,193 @@
+package registry
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"github.com/dotcloud/docker/auth"
+	"github.com/dotcloud/docker/utils"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestServerPushPull(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-test-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	store, err := NewFSStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewServer(store))
+	defer server.Close()
+	endpoint := server.URL + "/v1/"
+
+	r, err := NewRegistry(&auth.AuthConfig{}, utils.NewHTTPRequestFactory(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if standalone, err := pingRegistryEndpoint(endpoint); err != nil {
+		t.Fatal(err)
+	} else if !standalone {
+		t.Fatal("Expected the registry to be standalone")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	content := []byte("hello world")
+	if err := tw.WriteHeader(&tar.Header{Name: "hello", Mode: 0600, Size: int64(len(content))}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer := buf.Bytes()
+
+	// Push a base image and its child, which is tagged
+	parentID := "77dbf71da1d00e3fbddc480176eac8994025630c6590d11cfc8fe1209c2a1d20"
+	childID := IMAGE_ID
+	imgs := []struct{ id, json string }{
+		{parentID, `{"id":"` + parentID + `","Size":11}`},
+		{childID, `{"id":"` + childID + `","parent":"` + parentID + `","Size":11}`},
+	}
+	repoData, err := r.PushImageJSONIndex("foo", []*ImgData{{ID: childID, Tag: "latest"}}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repoData.Endpoints) != 1 || repoData.Endpoints[0] != endpoint {
+		t.Fatalf("Expected the endpoints to be [%s], not %v", endpoint, repoData.Endpoints)
+	}
+	for _, img := range imgs {
+		if r.LookupRemoteImage(img.id, endpoint, repoData.Tokens) {
+			t.Fatalf("Image %s should not exist yet", img.id)
+		}
+		imgData := &ImgData{ID: img.id}
+		if err := r.PushImageJSONRegistry(imgData, []byte(img.json), endpoint, repoData.Tokens); err != nil {
+			t.Fatal(err)
+		}
+		// The image isn't served before its checksum is pushed
+		if r.LookupRemoteImage(img.id, endpoint, repoData.Tokens) {
+			t.Fatalf("Image %s should not be served before its checksum", img.id)
+		}
+		checksum, err := r.PushImageLayerRegistry(img.id, bytes.NewReader(layer), endpoint, repoData.Tokens, []byte(img.json))
+		if err != nil {
+			t.Fatal(err)
+		}
+		imgData.Checksum = "tarsum+sha256:0000"
+		if err := r.PushImageChecksumRegistry(imgData, endpoint, repoData.Tokens); err == nil {
+			t.Fatal("Expected an error pushing a wrong checksum")
+		}
+		imgData.Checksum = checksum
+		if err := r.PushImageChecksumRegistry(imgData, endpoint, repoData.Tokens); err != nil {
+			t.Fatal(err)
+		}
+		if !r.LookupRemoteImage(img.id, endpoint, repoData.Tokens) {
+			t.Fatalf("Image %s should exist", img.id)
+		}
+	}
+	if err := r.PushImageJSONRegistry(&ImgData{ID: parentID}, []byte(imgs[0].json), endpoint, repoData.Tokens); err != ErrAlreadyExists {
+		t.Fatalf("Expected ErrAlreadyExists, not %v", err)
+	}
+	if err := r.PushRegistryTag("foo", childID, "latest", endpoint, repoData.Tokens); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.PushImageJSONIndex("foo", []*ImgData{{ID: childID, Tag: "latest"}}, true, repoData.Endpoints); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pull them back
+	repoData, err = r.GetRepositoryData("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repoData.ImgList) != 1 || repoData.ImgList[childID] == nil || repoData.ImgList[childID].Tag != "latest" {
+		t.Fatalf("Unexpected images %v", repoData.ImgList)
+	}
+	tags, err := r.GetRemoteTags(repoData.Endpoints, "foo", repoData.Tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, tags["latest"], childID, "Expected tag latest to be "+childID)
+	history, err := r.GetRemoteHistory(childID, endpoint, repoData.Tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || history[0] != childID || history[1] != parentID {
+		t.Fatalf("Unexpected ancestry %v", history)
+	}
+	jsonRaw, size, err := r.GetRemoteImageJSON(childID, endpoint, repoData.Tokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(jsonRaw), imgs[1].json, "Unexpected image JSON")
+	assertEqual(t, size, 11, "Expected the size to be 11")
+
+	rc, _, total, err := r.GetRemoteImageLayer(childID, endpoint, repoData.Tokens, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulled, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, int64(len(pulled)), total, "Unexpected size of the layer")
+	rc, resumed, _, err := r.GetRemoteImageLayer(childID, endpoint, repoData.Tokens, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resumed || !bytes.Equal(rest, pulled[10:]) {
+		t.Fatal("Expected the download of the layer to be resumed")
+	}
+
+	res, err := http.Get(server.URL + "/v1/search?q=fo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	results := &SearchResults{}
+	if err := json.NewDecoder(res.Body).Decode(results); err != nil {
+		t.Fatal(err)
+	}
+	if results.NumResults != 1 || results.Results[0].Name != "library/foo" {
+		t.Fatalf("Unexpected search results %v", results)
+	}
+}
+
+func TestServerReadOnly(t *testing.T) {
+	server := httptest.NewServer(NewServer(readOnlyStore{}))
+	defer server.Close()
+	endpoint := server.URL + "/v1/"
+	r, err := NewRegistry(&auth.AuthConfig{}, utils.NewHTTPRequestFactory(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.PushImageJSONIndex("foo", []*ImgData{}, false, nil); err == nil {
+		t.Fatal("Expected an error pushing to a read-only registry")
+	}
+	if _, err := r.GetRepositoryData("foo"); err == nil {
+		t.Fatal("Expected an error pulling an unknown repository")
+	}
+}
+
+type readOnlyStore struct{}
+
+func (readOnlyStore) ImageJSON(id string) ([]byte, error)               { return nil, ErrNotFound }
+func (readOnlyStore) ImageLayer(id string) (io.ReadCloser, error)       { return nil, ErrNotFound }
+func (readOnlyStore) ImageChecksum(id string) (string, error)           { return "", ErrNotFound }
+func (readOnlyStore) Tags(repository string) (map[string]string, error) { return nil, ErrNotFound }
+func (readOnlyStore) Repositories() ([]string, error)                   { return nil, nil }
@@ -0,0 +1,269 @@
+package registry
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dotcloud/docker/archive"
+	"github.com/dotcloud/docker/utils"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNotFound      = errors.New("Not found")
+	ErrWrongChecksum = errors.New("Wrong checksum")
+)
+
+var validImageID = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// An ImageStore keeps the images and the repositories served by a Server.
+// Its methods return ErrNotFound for the images and repositories which do
+// not exist.
+type ImageStore interface {
+	ImageJSON(id string) ([]byte, error)
+	ImageLayer(id string) (io.ReadCloser, error)
+	// ImageChecksum returns the checksum of the image, or "" if it is unknown
+	ImageChecksum(id string) (string, error)
+	// Tags returns the ids of the images of repository, by tag
+	Tags(repository string) (map[string]string, error)
+	Repositories() ([]string, error)
+}
+
+// A WritableImageStore is an ImageStore which images can be pushed to. An
+// image is pushed with its JSON, then its layer, then its checksum, and it
+// isn't served until then.
+type WritableImageStore interface {
+	ImageStore
+	PutImageJSON(id string, jsonRaw []byte) error
+	PutImageLayer(id string, layer io.Reader) error
+	// PutImageChecksum returns ErrWrongChecksum if the checksum doesn't
+	// match the image
+	PutImageChecksum(id, checksum string) error
+	SetTag(repository, tag, id string) error
+}
+
+// FSStore keeps the images pushed to a registry in a directory:
+// images/<id>/{json,layer,checksum} and repositories.json, the tags of each
+// repository.
+type FSStore struct {
+	sync.Mutex
+	root           string
+	RepositoryTags map[string]map[string]string `json:"Repositories"`
+}
+
+func NewFSStore(root string) (*FSStore, error) {
+	abspath, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(path.Join(abspath, "images"), 0700); err != nil {
+		return nil, err
+	}
+	store := &FSStore{
+		root:           abspath,
+		RepositoryTags: make(map[string]map[string]string),
+	}
+	if err := store.reload(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *FSStore) save() error {
+	jsonData, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(store.root, "repositories.json"), jsonData, 0600)
+}
+
+func (store *FSStore) reload() error {
+	jsonData, err := ioutil.ReadFile(path.Join(store.root, "repositories.json"))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, store)
+}
+
+func (store *FSStore) imagePath(id, name string) (string, error) {
+	if !validImageID.MatchString(id) {
+		return "", fmt.Errorf("Invalid image id %s", id)
+	}
+	return path.Join(store.root, "images", id, name), nil
+}
+
+// readImageFile returns the content of a file of the image id, which must
+// have been pushed completely unless inProgress is set
+func (store *FSStore) readImageFile(id, name string, inProgress bool) ([]byte, error) {
+	if !inProgress {
+		if p, err := store.imagePath(id, "_inprogress"); err != nil {
+			return nil, err
+		} else if _, err := os.Stat(p); err == nil {
+			return nil, ErrNotFound
+		}
+	}
+	p, err := store.imagePath(id, name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (store *FSStore) ImageJSON(id string) ([]byte, error) {
+	return store.readImageFile(id, "json", false)
+}
+
+func (store *FSStore) ImageLayer(id string) (io.ReadCloser, error) {
+	if _, err := store.ImageJSON(id); err != nil {
+		return nil, err
+	}
+	p, err := store.imagePath(id, "layer")
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (store *FSStore) ImageChecksum(id string) (string, error) {
+	checksum, err := store.readImageFile(id, "checksum", false)
+	return string(checksum), err
+}
+
+func (store *FSStore) PutImageJSON(id string, jsonRaw []byte) error {
+	if _, err := store.ImageJSON(id); err == nil {
+		return ErrAlreadyExists
+	}
+	p, err := store.imagePath(id, "json")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(path.Dir(p), "_inprogress"), nil, 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, jsonRaw, 0600)
+}
+
+func (store *FSStore) PutImageLayer(id string, layer io.Reader) error {
+	if _, err := store.readImageFile(id, "json", true); err != nil {
+		return err
+	}
+	p, err := store.imagePath(id, "layer")
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(path.Dir(p), "layer-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, layer)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+// PutImageChecksum checks that checksum is the tarsum of the layer and the
+// JSON of the image, which completes its push
+func (store *FSStore) PutImageChecksum(id, checksum string) error {
+	jsonRaw, err := store.readImageFile(id, "json", true)
+	if err != nil {
+		return err
+	}
+	p, err := store.imagePath(id, "layer")
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	layer, err := archive.DecompressStream(f)
+	if err != nil {
+		return err
+	}
+	tarsum := &utils.TarSum{Reader: layer, DisableCompression: true}
+	if _, err := io.Copy(ioutil.Discard, tarsum); err != nil {
+		return err
+	}
+	if tarsum.Sum(jsonRaw) != checksum {
+		return ErrWrongChecksum
+	}
+
+	if err := ioutil.WriteFile(path.Join(path.Dir(p), "checksum"), []byte(checksum), 0600); err != nil {
+		return err
+	}
+	return os.Remove(path.Join(path.Dir(p), "_inprogress"))
+}
+
+func (store *FSStore) Tags(repository string) (map[string]string, error) {
+	store.Lock()
+	defer store.Unlock()
+	tags, exists := store.RepositoryTags[repository]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	result := make(map[string]string, len(tags))
+	for tag, id := range tags {
+		result[tag] = id
+	}
+	return result, nil
+}
+
+func (store *FSStore) SetTag(repository, tag, id string) error {
+	if _, err := store.ImageJSON(id); err != nil {
+		return err
+	}
+	store.Lock()
+	defer store.Unlock()
+	if store.RepositoryTags[repository] == nil {
+		store.RepositoryTags[repository] = make(map[string]string)
+	}
+	store.RepositoryTags[repository][tag] = id
+	return store.save()
+}
+
+func (store *FSStore) Repositories() ([]string, error) {
+	store.Lock()
+	defer store.Unlock()
+	names := make([]string, 0, len(store.RepositoryTags))
+	for name := range store.RepositoryTags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// NormalizeRepositoryName returns the name of a repository for a registry,
+// where the repositories without namespace are in library
+func NormalizeRepositoryName(name string) string {
+	if !strings.Contains(name, "/") {
+		return "library/" + name
+	}
+	return name
+}
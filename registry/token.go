@@ -0,0 +1,179 @@
+package registry
+
+import (
+	"bytes"
+	"github.com/dotcloud/docker/auth"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// tokenTransport answers the Bearer challenges of the registries: it asks
+// the realm of the challenge for a token, with the credentials of the
+// registry, and sends the request again with the token. The tokens are
+// cached per scope until they expire, and the last challenge of each
+// registry is remembered, so that the next requests to it carry a token
+// from the start, even those whose body can't be sent again.
+type tokenTransport struct {
+	sync.Mutex
+	base       http.RoundTripper
+	authConfig *auth.AuthConfig
+	tokens     map[auth.BearerChallenge]*auth.Token
+	challenges map[string]*auth.BearerChallenge
+}
+
+func newTokenTransport(base http.RoundTripper, authConfig *auth.AuthConfig) *tokenTransport {
+	return &tokenTransport{
+		base:       base,
+		authConfig: authConfig,
+		tokens:     make(map[auth.BearerChallenge]*auth.Token),
+		challenges: make(map[string]*auth.BearerChallenge),
+	}
+}
+
+// token returns the cached token of challenge, or fetches a new one if
+// there is none or it expired
+func (t *tokenTransport) token(challenge *auth.BearerChallenge) (string, error) {
+	t.Lock()
+	token, exists := t.tokens[*challenge]
+	t.Unlock()
+	if exists && !token.Expired() {
+		return token.Token, nil
+	}
+	utils.Debugf("Fetching a token from %s for the scope %q", challenge.Realm, challenge.Scope)
+	token, err := auth.FetchToken(t.authConfig, challenge, &http.Client{Transport: t.base}, IsInsecure)
+	if err != nil {
+		return "", err
+	}
+	t.Lock()
+	t.tokens[*challenge] = token
+	t.Unlock()
+	return token.Token, nil
+}
+
+func (t *tokenTransport) forget(challenge *auth.BearerChallenge) {
+	t.Lock()
+	delete(t.tokens, *challenge)
+	t.Unlock()
+}
+
+// withToken returns a copy of req with the token, if any, in place of the
+// other credentials of req
+func withToken(req *http.Request, token string) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+func (t *tokenTransport) challenge(host string) *auth.BearerChallenge {
+	t.Lock()
+	defer t.Unlock()
+	return t.challenges[host]
+}
+
+func (t *tokenTransport) setChallenge(host string, challenge *auth.BearerChallenge) {
+	t.Lock()
+	t.challenges[host] = challenge
+	t.Unlock()
+}
+
+// probe asks the registry for the challenge of the URL of req with a HEAD,
+// which has no body. It returns nil if the registry doesn't send one.
+func (t *tokenTransport) probe(req *http.Request) (*auth.BearerChallenge, error) {
+	head := withToken(req, "")
+	head.Method = "HEAD"
+	head.Body = nil
+	head.ContentLength = 0
+	head.TransferEncoding = nil
+	res, err := t.base.RoundTrip(head)
+	if err != nil {
+		return nil, err
+	}
+	res.Body.Close()
+	if res.StatusCode != 401 {
+		return nil, nil
+	}
+	challenge := auth.ParseBearerChallenge(res.Header.Get("WWW-Authenticate"))
+	if challenge != nil {
+		t.setChallenge(req.URL.Host, challenge)
+	}
+	return challenge, nil
+}
+
+// RoundTrip sends req with the token of the last challenge of its host, and
+// sends it again with a new token if it gets a new challenge. The bodies of
+// known length are kept in memory to be sent again. The others, such as the
+// layers, are streamed: they are sent once, with a token asked for the
+// challenge of their URL beforehand if the host didn't send one yet.
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body *bytes.Reader
+	challenge := t.challenge(req.URL.Host)
+	if req.Body != nil && req.ContentLength > 0 {
+		buf, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(buf)
+	} else if req.Body != nil && challenge == nil {
+		var err error
+		if challenge, err = t.probe(req); err != nil {
+			req.Body.Close()
+			return nil, err
+		}
+	}
+	send := func(token string) (*http.Response, error) {
+		sent := withToken(req, token)
+		if body != nil {
+			if _, err := body.Seek(0, 0); err != nil {
+				return nil, err
+			}
+			sent.Body = ioutil.NopCloser(body)
+		}
+		return t.base.RoundTrip(sent)
+	}
+
+	var token string
+	if challenge != nil {
+		var err error
+		if token, err = t.token(challenge); err != nil {
+			if req.Body != nil && body == nil {
+				req.Body.Close()
+			}
+			return nil, err
+		}
+	}
+	res, err := send(token)
+	if err != nil || res.StatusCode != 401 {
+		return res, err
+	}
+	newChallenge := auth.ParseBearerChallenge(res.Header.Get("WWW-Authenticate"))
+	if newChallenge == nil {
+		return res, nil
+	}
+	if challenge != nil && *newChallenge == *challenge {
+		// The token was refused, don't use it again
+		t.forget(challenge)
+	}
+	t.setChallenge(req.URL.Host, newChallenge)
+
+	if req.Body != nil && body == nil {
+		// The body was streamed, only the next requests can be
+		// authenticated
+		return res, nil
+	}
+	if token, err = t.token(newChallenge); err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	res.Body.Close()
+	return send(token)
+}
@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"encoding/base64"
+	"fmt"
+	"github.com/dotcloud/docker/auth"
+	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenAuth(t *testing.T) {
+	root, err := ioutil.TempDir("", "docker-test-registry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	store, err := NewFSStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetches := 0
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("foo:bar")) {
+			w.WriteHeader(401)
+			return
+		}
+		if r.URL.Query().Get("service") != "registry.local" || r.URL.Query().Get("scope") != "repository:foo:push,pull" {
+			t.Errorf("Unexpected token request %s", r.URL)
+		}
+		fetches++
+		fmt.Fprintf(w, `{"token":"token%d","expires_in":300}`, fetches)
+	}))
+	defer tokenServer.Close()
+
+	registryServer := NewServer(store)
+	heads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			heads++
+		}
+		if r.Header.Get("Authorization") != fmt.Sprintf("Bearer token%d", fetches) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+tokenServer.URL+`/token",service="registry.local",scope="repository:foo:push,pull"`)
+			w.WriteHeader(401)
+			return
+		}
+		registryServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	endpoint := server.URL + "/v1/"
+
+	authConfig := &auth.AuthConfig{Username: "foo", Password: "bar"}
+	r, err := NewRegistry(authConfig, utils.NewHTTPRequestFactory(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imgJSON := []byte(`{"id":"` + IMAGE_ID + `","Size":11}`)
+	// The request is sent again with a token after the challenge
+	if err := r.PushImageJSONRegistry(&ImgData{ID: IMAGE_ID}, imgJSON, endpoint, nil); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, fetches, 1, "Expected a token to be fetched")
+	// The token is cached
+	if err := r.PushImageJSONRegistry(&ImgData{ID: IMAGE_ID}, imgJSON, endpoint, nil); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, fetches, 1, "Expected the token to be cached")
+
+	// The token is fetched again when it expires
+	transport := r.client.Transport.(*tokenTransport)
+	for _, token := range transport.tokens {
+		token.Expires = time.Now().Add(-time.Second)
+	}
+	if err := r.PushImageJSONRegistry(&ImgData{ID: IMAGE_ID}, imgJSON, endpoint, nil); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, fetches, 2, "Expected the expired token to be fetched again")
+
+	r, err = NewRegistry(&auth.AuthConfig{Username: "foo", Password: "baz"}, utils.NewHTTPRequestFactory(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.PushImageJSONRegistry(&ImgData{ID: IMAGE_ID}, imgJSON, endpoint, nil); err == nil {
+		t.Fatal("Expected an error with the wrong credentials")
+	}
+
+	// A layer is streamed, it can't be sent again after a challenge: the
+	// challenge is asked first
+	r, err = NewRegistry(authConfig, utils.NewHTTPRequestFactory(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.PushImageLayerRegistry(IMAGE_ID, strings.NewReader(""), endpoint, nil, imgJSON); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, heads, 1, "Expected the challenge of the layer to be asked first")
+	assertEqual(t, fetches, 3, "Expected a token to be fetched for the layer")
+}
@@ -0,0 +1,121 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+var (
+	// CertsDir has a directory for each registry, named after its
+	// host[:port], with the certificates of its CAs (*.crt), and the
+	// certificate (client.cert) and key (client.key) to present to it
+	CertsDir = "/etc/docker/certs.d"
+
+	// InsecureRegistries may be reached over plain HTTP, and their
+	// certificates aren't verified. They are given as host[:port], a host
+	// without port matches all the ports. The registries on the loopback
+	// interface are always insecure.
+	InsecureRegistries []string
+)
+
+// IsInsecure returns whether the registry hostname, given as host[:port],
+// is insecure
+func IsInsecure(hostname string) bool {
+	host := hostname
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return true
+	}
+	for _, insecure := range InsecureRegistries {
+		if insecure == hostname || insecure == host {
+			return true
+		}
+	}
+	return false
+}
+
+// newTLSConfig returns the TLS config to reach the registry hostname, with
+// the certificates of its directory of CertsDir
+func newTLSConfig(hostname string) (*tls.Config, error) {
+	config := &tls.Config{
+		InsecureSkipVerify: IsInsecure(hostname),
+	}
+	dir := path.Join(CertsDir, hostname)
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return config, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		name := f.Name()
+		switch {
+		case strings.HasSuffix(name, ".crt"):
+			pem, err := ioutil.ReadFile(path.Join(dir, name))
+			if err != nil {
+				return nil, err
+			}
+			// Only the CAs of the registry are trusted
+			if config.RootCAs == nil {
+				config.RootCAs = x509.NewCertPool()
+			}
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("Invalid CA certificate %s", path.Join(dir, name))
+			}
+		case name == "client.cert":
+			keyName := path.Join(dir, "client.key")
+			cert, err := tls.LoadX509KeyPair(path.Join(dir, name), keyName)
+			if err != nil {
+				return nil, fmt.Errorf("Couldn't load the client certificate of %s: %s", hostname, err)
+			}
+			config.Certificates = append(config.Certificates, cert)
+		}
+	}
+	return config, nil
+}
+
+// transport sends the requests to each registry with its TLS config
+type transport struct {
+	sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// NewTransport returns a transport for the requests to the registries,
+// which trusts the CAs of each registry and presents its client
+// certificate, as found in CertsDir
+func NewTransport() http.RoundTripper {
+	return &transport{transports: make(map[string]*http.Transport)}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.Lock()
+	hostTransport, exists := t.transports[req.URL.Host]
+	if !exists {
+		tlsConfig, err := newTLSConfig(req.URL.Host)
+		if err != nil {
+			t.Unlock()
+			return nil, err
+		}
+		hostTransport = &http.Transport{
+			DisableKeepAlives: true,
+			Proxy:             http.ProxyFromEnvironment,
+			TLSClientConfig:   tlsConfig,
+		}
+		t.transports[req.URL.Host] = hostTransport
+	}
+	t.Unlock()
+	return hostTransport.RoundTrip(req)
+}
@@ -0,0 +1,78 @@
+package registry
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestIsInsecure(t *testing.T) {
+	defer func(insecure []string) { InsecureRegistries = insecure }(InsecureRegistries)
+	InsecureRegistries = []string{"registry.local", "10.0.0.1:5000"}
+
+	for _, hostname := range []string{"localhost:5000", "127.0.0.1", "registry.local", "registry.local:5000", "10.0.0.1:5000"} {
+		if !IsInsecure(hostname) {
+			t.Fatalf("Expected %s to be insecure", hostname)
+		}
+	}
+	for _, hostname := range []string{"index.docker.io", "registry.com:5000", "10.0.0.1", "10.0.0.1:443"} {
+		if IsInsecure(hostname) {
+			t.Fatalf("Expected %s not to be insecure", hostname)
+		}
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	cert := server.Certificate()
+
+	certsDir, err := ioutil.TempDir("", "docker-test-certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(certsDir)
+	defer func(dir string) { CertsDir = dir }(CertsDir)
+	CertsDir = certsDir
+
+	// Without its directory, the registry is verified with the CAs of the system
+	config, err := newTLSConfig("registry.local:5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.InsecureSkipVerify || config.RootCAs != nil {
+		t.Fatal("Expected the default TLS config")
+	}
+
+	dir := path.Join(certsDir, "registry.local:5000")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := ioutil.WriteFile(path.Join(dir, "ca.crt"), ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err = newTLSConfig("registry.local:5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.InsecureSkipVerify || config.RootCAs == nil {
+		t.Fatal("Expected the CA of the registry to be trusted")
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{Roots: config.RootCAs}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The client certificate needs its key
+	if err := ioutil.WriteFile(path.Join(dir, "client.cert"), ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTLSConfig("registry.local:5000"); err == nil {
+		t.Fatal("Expected an error loading a client certificate without key")
+	}
+}
@@ -0,0 +1,136 @@
+package docker
+
+import (
+	"github.com/dotcloud/docker/engine"
+	"github.com/dotcloud/docker/registry"
+	"github.com/dotcloud/docker/utils"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path"
+	"sort"
+)
+
+// graphRegistryStore serves the images of the daemon and its repositories
+// to the registry server. It is read-only. The repositories named after a
+// registry, such as localhost:5000/foo, are served without the registry
+// part.
+type graphRegistryStore struct {
+	graph        *Graph
+	repositories *TagStore
+}
+
+func (store *graphRegistryStore) image(id string) (*Image, error) {
+	img, err := store.graph.Get(id)
+	if err != nil || img.ID != id {
+		// graph.Get also finds the images by prefix
+		return nil, registry.ErrNotFound
+	}
+	return img, nil
+}
+
+func (store *graphRegistryStore) ImageJSON(id string) ([]byte, error) {
+	if _, err := store.image(id); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path.Join(store.graph.Root, id, "json"))
+}
+
+func (store *graphRegistryStore) ImageLayer(id string) (io.ReadCloser, error) {
+	img, err := store.image(id)
+	if err != nil {
+		return nil, err
+	}
+	layer, err := img.TarLayer()
+	if err != nil {
+		return nil, err
+	}
+	if rc, ok := layer.(io.ReadCloser); ok {
+		return rc, nil
+	}
+	return ioutil.NopCloser(layer), nil
+}
+
+func (store *graphRegistryStore) ImageChecksum(id string) (string, error) {
+	if _, err := store.image(id); err != nil {
+		return "", err
+	}
+	// The checksums of the layers are only known to the registries
+	return "", nil
+}
+
+// remoteName returns the name of the local repository name in the
+// registry, or "" if it can't be served
+func remoteName(name string) string {
+	_, remote, err := registry.SplitReposName(name)
+	if err != nil {
+		return ""
+	}
+	return registry.NormalizeRepositoryName(remote)
+}
+
+func (store *graphRegistryStore) Tags(repository string) (map[string]string, error) {
+	var tags map[string]string
+	for name, repo := range store.repositories.copyRepositories() {
+		if remoteName(name) != repository {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		for tag, id := range repo {
+			tags[tag] = id
+		}
+	}
+	if tags == nil {
+		return nil, registry.ErrNotFound
+	}
+	return tags, nil
+}
+
+func (store *graphRegistryStore) Repositories() ([]string, error) {
+	seen := make(map[string]bool)
+	names := []string{}
+	for name := range store.repositories.copyRepositories() {
+		if remote := remoteName(name); remote != "" && !seen[remote] {
+			seen[remote] = true
+			names = append(names, remote)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ServeRegistry serves the API of a registry on the tcp address of the job.
+// The images pushed are stored in Root, or the images of the daemon are
+// served read-only if Root is empty.
+func (srv *Server) ServeRegistry(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s ADDR", job.Name)
+	}
+	var store registry.ImageStore
+	if root := job.Getenv("Root"); root != "" {
+		fsStore, err := registry.NewFSStore(root)
+		if err != nil {
+			return job.Error(err)
+		}
+		store = fsStore
+	} else {
+		store = &graphRegistryStore{
+			graph:        srv.runtime.graph,
+			repositories: srv.runtime.repositories,
+		}
+	}
+	l, err := net.Listen("tcp", job.Args[0])
+	if err != nil {
+		return job.Error(err)
+	}
+	job.Logf("Serving the registry on %s", l.Addr())
+	go func() {
+		if err := http.Serve(l, registry.NewServer(store)); err != nil {
+			utils.Errorf("Error serving the registry: %s", err)
+		}
+	}()
+	return engine.StatusOK
+}
@@ -8,16 +8,22 @@ import (
 	"github.com/dotcloud/docker/execdriver"
 	"github.com/dotcloud/docker/execdriver/chroot"
 	"github.com/dotcloud/docker/execdriver/lxc"
+	"github.com/dotcloud/docker/execdriver/native"
 	"github.com/dotcloud/docker/graphdriver"
 	"github.com/dotcloud/docker/graphdriver/aufs"
 	_ "github.com/dotcloud/docker/graphdriver/btrfs"
 	_ "github.com/dotcloud/docker/graphdriver/devmapper"
+	_ "github.com/dotcloud/docker/graphdriver/overlay"
 	_ "github.com/dotcloud/docker/graphdriver/vfs"
+	_ "github.com/dotcloud/docker/graphdriver/zfs"
+	"github.com/dotcloud/docker/logger"
+	_ "github.com/dotcloud/docker/logger/syslog"
 	_ "github.com/dotcloud/docker/networkdriver/lxc"
 	"github.com/dotcloud/docker/networkdriver/portallocator"
 	"github.com/dotcloud/docker/pkg/graphdb"
 	"github.com/dotcloud/docker/pkg/sysinfo"
 	"github.com/dotcloud/docker/utils"
+	"github.com/dotcloud/docker/volumedriver/local"
 	"io"
 	"io/ioutil"
 	"os"
@@ -48,7 +54,8 @@ type Runtime struct {
 	repositories   *TagStore
 	idIndex        *utils.TruncIndex
 	sysInfo        *sysinfo.SysInfo
-	volumes        *Graph
+	volumes        *VolumeStore
+	volumesDriver  graphdriver.Driver
 	srv            *Server
 	eng            *engine.Engine
 	config         *DaemonConfig
@@ -156,6 +163,13 @@ func (runtime *Runtime) Register(container *Container) error {
 	runtime.containers.PushBack(container)
 	runtime.idIndex.Add(container.ID)
 
+	// Count the volumes in use, which are not saved with the volumes
+	for _, p := range container.Volumes {
+		if volume := runtime.volumes.ByPath(p); volume != nil {
+			runtime.volumes.Ref(volume, container.ID)
+		}
+	}
+
 	// FIXME: if the container is supposed to be running but is not, auto restart it?
 	//        if so, then we need to restart monitor and init a new lock
 	// If the container is supposed to be running, make sure of it
@@ -164,7 +178,7 @@ func (runtime *Runtime) Register(container *Container) error {
 
 		if !info.IsRunning() {
 			utils.Debugf("Container %s was supposed to be running but is not.", container.ID)
-			if runtime.config.AutoRestart {
+			if runtime.config.AutoRestart || container.hostConfig.RestartPolicy.Name == "always" {
 				utils.Debugf("Restarting")
 				container.State.SetGhost(false)
 				container.State.SetStopped(0)
@@ -218,15 +232,6 @@ func (runtime *Runtime) ensureName(container *Container) error {
 	return nil
 }
 
-func (runtime *Runtime) LogToDisk(src *utils.WriteBroadcaster, dst, stream string) error {
-	log, err := os.OpenFile(dst, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
-	if err != nil {
-		return err
-	}
-	src.AddWriter(log, stream)
-	return nil
-}
-
 // Destroy unregisters a container from the runtime and cleanly removes its contents from the filesystem.
 func (runtime *Runtime) Destroy(container *Container) error {
 	if container == nil {
@@ -258,6 +263,11 @@ func (runtime *Runtime) Destroy(container *Container) error {
 	// Deregister the container before removing its directory, to avoid race conditions
 	runtime.idIndex.Delete(container.ID)
 	runtime.containers.Remove(element)
+	for _, p := range container.Volumes {
+		if volume := runtime.volumes.ByPath(p); volume != nil {
+			runtime.volumes.Unref(volume, container.ID)
+		}
+	}
 	if err := os.RemoveAll(container.root); err != nil {
 		return fmt.Errorf("Unable to remove filesystem for %v: %v", container.ID, err)
 	}
@@ -463,7 +473,10 @@ func (runtime *Runtime) Create(config *Config, name string) (*Container, []strin
 		return nil, nil, err
 	}
 
+	// The layers have no owner until the container is registered
 	initID := fmt.Sprintf("%s-init", container.ID)
+	defer runtime.graph.reserveLayers(initID, container.ID)()
+
 	if err := runtime.driver.Create(initID, img.ID); err != nil {
 		return nil, nil, err
 	}
@@ -528,7 +541,7 @@ func (runtime *Runtime) Create(config *Config, name string) (*Container, []strin
 // Commit creates a new filesystem image from the current state of a container.
 // The image can optionally be tagged into a repository
 func (runtime *Runtime) Commit(container *Container, repository, tag, comment, author string, config *Config) (*Image, error) {
-	// FIXME: freeze the container before copying it to avoid data corruption?
+	// The container should be paused by the caller to get a consistent snapshot
 	// FIXME: this shouldn't be in commands.
 	if err := container.Mount(); err != nil {
 		return nil, err
@@ -625,7 +638,7 @@ func NewRuntimeFromDirectory(config *DaemonConfig, eng *engine.Engine) (*Runtime
 	graphdriver.DefaultDriver = config.GraphDriver
 
 	// Load storage driver
-	driver, err := graphdriver.New(config.Root)
+	driver, err := graphdriver.New(config.Root, config.GraphOptions)
 	if err != nil {
 		return nil, err
 	}
@@ -652,15 +665,19 @@ func NewRuntimeFromDirectory(config *DaemonConfig, eng *engine.Engine) (*Runtime
 
 	// We don't want to use a complex driver like aufs or devmapper
 	// for volumes, just a plain filesystem
-	volumesDriver, err := graphdriver.GetDriver("vfs", config.Root)
+	volumesDriver, err := graphdriver.GetDriver("vfs", config.Root, nil)
 	if err != nil {
 		return nil, err
 	}
 	utils.Debugf("Creating volumes graph")
-	volumes, err := NewGraph(path.Join(config.Root, "volumes"), volumesDriver)
+	volumesGraph, err := NewGraph(path.Join(config.Root, "volumes"), volumesDriver)
 	if err != nil {
 		return nil, err
 	}
+	volumes, err := NewVolumeStore(path.Join(config.Root, "volumes.json"), config.Root, volumesGraph, local.New(volumesDriver))
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create volume store: %s", err)
+	}
 	utils.Debugf("Creating repository list")
 	repositories, err := NewTagStore(path.Join(config.Root, "repositories-"+driver.String()), g)
 	if err != nil {
@@ -710,24 +727,26 @@ func NewRuntimeFromDirectory(config *DaemonConfig, eng *engine.Engine) (*Runtime
 
 	sysInfo := sysinfo.New(false)
 
-	/*
-		temporarilly disabled.
-	*/
-	if false {
-		var ed execdriver.Driver
-		if driver := os.Getenv("EXEC_DRIVER"); driver == "lxc" {
-			ed, err = lxc.NewDriver(config.Root, sysInfo.AppArmor)
-		} else {
-			ed, err = chroot.NewDriver()
-		}
-		if ed != nil {
-		}
+	var ed execdriver.Driver
+	utils.Debugf("Using exec driver %s", config.ExecDriver)
+	switch config.ExecDriver {
+	case "", "lxc":
+		ed, err = lxc.NewDriver(config.Root, sysInfo.AppArmor)
+	case "native":
+		ed, err = native.NewDriver(config.Root)
+	case "chroot":
+		ed, err = chroot.NewDriver()
+	default:
+		return nil, fmt.Errorf("Unknown exec driver %s", config.ExecDriver)
 	}
-	ed, err := lxc.NewDriver(config.Root, sysInfo.AppArmor)
 	if err != nil {
 		return nil, err
 	}
 
+	if config.LogDriver != "" && !logger.Exists(config.LogDriver) {
+		return nil, fmt.Errorf("Unknown log driver %s", config.LogDriver)
+	}
+
 	runtime := &Runtime{
 		repository:     runtimeRepo,
 		containers:     list.New(),
@@ -736,6 +755,7 @@ func NewRuntimeFromDirectory(config *DaemonConfig, eng *engine.Engine) (*Runtime
 		idIndex:        utils.NewTruncIndex(),
 		sysInfo:        sysInfo,
 		volumes:        volumes,
+		volumesDriver:  volumesDriver,
 		config:         config,
 		containerGraph: graph,
 		driver:         driver,
@@ -836,6 +856,18 @@ func (runtime *Runtime) Kill(c *Container, sig int) error {
 	return runtime.execDriver.Kill(c.command, sig)
 }
 
+func (runtime *Runtime) Exec(c *Container, p *execdriver.Process, startCallback execdriver.ProcessCallback) (int, error) {
+	return runtime.execDriver.Exec(c.command, p, startCallback)
+}
+
+func (runtime *Runtime) Pause(c *Container) error {
+	return runtime.execDriver.Pause(c.command)
+}
+
+func (runtime *Runtime) Unpause(c *Container) error {
+	return runtime.execDriver.Unpause(c.command)
+}
+
 func (runtime *Runtime) RestoreCommand(c *Container) error {
 	return runtime.execDriver.Restore(c.command)
 }
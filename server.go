@@ -1,13 +1,16 @@
 package docker
 
 import (
+	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/dotcloud/docker/archive"
 	"github.com/dotcloud/docker/auth"
 	"github.com/dotcloud/docker/engine"
+	"github.com/dotcloud/docker/logger/jsonfilelog"
 	"github.com/dotcloud/docker/pkg/graphdb"
+	"github.com/dotcloud/docker/pkg/tailfile"
 	"github.com/dotcloud/docker/registry"
 	"github.com/dotcloud/docker/utils"
 	"io"
@@ -85,9 +88,15 @@ func jobInitServer(job *engine.Job) engine.Status {
 		"container_copy":   srv.ContainerCopy,
 		"insert":           srv.ImageInsert,
 		"attach":           srv.ContainerAttach,
+		"logs":             srv.ContainerLogs,
+		"exec":             srv.ContainerExec,
+		"execwait":         srv.ContainerExecWait,
+		"pause":            srv.ContainerPause,
+		"unpause":          srv.ContainerUnpause,
 		"search":           srv.ImagesSearch,
 		"changes":          srv.ContainerChanges,
 		"top":              srv.ContainerTop,
+		"stats":            srv.ContainerStats,
 		"load":             srv.ImageLoad,
 		"build":            srv.Build,
 		"pull":             srv.ImagePull,
@@ -98,6 +107,12 @@ func jobInitServer(job *engine.Job) engine.Status {
 		"push":             srv.ImagePush,
 		"containers":       srv.Containers,
 		"auth":             srv.Auth,
+		"gc":               srv.GarbageCollect,
+		"volume_create":    srv.VolumeCreate,
+		"volumes":          srv.Volumes,
+		"volume_inspect":   srv.VolumeInspect,
+		"volume_delete":    srv.VolumeDelete,
+		"serveregistry":    srv.ServeRegistry,
 	} {
 		if err := job.Eng.Register(name, handler); err != nil {
 			return job.Error(err)
@@ -214,7 +229,7 @@ func (srv *Server) Auth(job *engine.Job) engine.Status {
 		}
 		authConfig.ServerAddress = addr
 	}
-	status, err := auth.Login(authConfig, srv.HTTPRequestFactory(nil))
+	status, err := auth.Login(authConfig, srv.HTTPRequestFactory(nil), registry.NewTransport(), registry.IsInsecure)
 	if err != nil {
 		return job.Error(err)
 	}
@@ -1047,6 +1062,19 @@ func (srv *Server) ContainerCommit(job *engine.Job) engine.Status {
 		return job.Error(err)
 	}
 
+	// Freeze the container while its changes are copied, unless it was
+	// paused already, so the image is a consistent snapshot
+	if job.GetenvBool("pause") && container.State.IsRunning() && !container.State.IsPaused() {
+		if err := container.Pause(); err != nil {
+			return job.Errorf("Cannot pause container %s: %s", name, err)
+		}
+		defer func() {
+			if err := container.Unpause(); err != nil {
+				utils.Errorf("Cannot unpause container %s: %s", name, err)
+			}
+		}()
+	}
+
 	img, err := srv.runtime.Commit(container, job.Getenv("repo"), job.Getenv("tag"), job.Getenv("comment"), job.Getenv("author"), &config)
 	if err != nil {
 		return job.Error(err)
@@ -1069,59 +1097,277 @@ func (srv *Server) ImageTag(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
-func (srv *Server) pullImage(r *registry.Registry, out io.Writer, imgID, endpoint string, token []string, sf *utils.StreamFormatter) error {
+const maxDownloadAttempts = 5
+
+// downloadRetryDelay is the delay before the first retry of a download,
+// which doubles after each attempt
+var downloadRetryDelay = time.Second
+
+// retryableError is an error of a download which may not happen again: a
+// network error, an error of the server or a truncated response
+type retryableError struct {
+	error
+}
+
+// retryableReader reads a response, whose errors are retryable
+type retryableReader struct {
+	io.ReadCloser
+}
+
+func (r retryableReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		err = retryableError{err}
+	}
+	return n, err
+}
+
+// downloadLayer downloads the layer of the image id to a file of the graph,
+// which the caller removes. After a retryable error, the download is resumed
+// where it stopped. The file is kept when the download fails, for the next
+// pull of the image to resume it too. The layer is verified once downloaded,
+// and downloaded again from scratch if it's invalid.
+func (srv *Server) downloadLayer(r *registry.Registry, out io.Writer, id, endpoint string, token []string, imgSize int, sf *utils.StreamFormatter) (*os.File, error) {
+	tmp := path.Join(srv.runtime.graph.Root, "_tmp")
+	if err := os.MkdirAll(tmp, 0700); err != nil {
+		return nil, err
+	}
+	// The pulls of a layer don't run at the same time, see fetchLayer
+	f, err := os.OpenFile(path.Join(tmp, "layer-"+id), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := f.Seek(0, 2)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if offset > 0 {
+		out.Write(sf.FormatProgress(utils.TruncateID(id), fmt.Sprintf("Resuming the download at %d bytes", offset), nil))
+	}
+	var (
+		size  int64 = -1
+		delay       = downloadRetryDelay
+	)
+	err = func() error {
+		for attempt := 1; ; attempt++ {
+			err := func() error {
+				layer, resumed, total, err := r.GetRemoteImageLayer(id, endpoint, token, offset)
+				if err != nil {
+					switch e := err.(type) {
+					case *url.Error:
+						return retryableError{err}
+					case *utils.JSONError:
+						if e.Code >= 500 {
+							return retryableError{err}
+						}
+						if e.Code == http.StatusRequestedRangeNotSatisfiable && offset > 0 {
+							// What was kept is not a part of the layer,
+							// download the whole layer again
+							if err := f.Truncate(0); err != nil {
+								return err
+							}
+							if _, err := f.Seek(0, 0); err != nil {
+								return err
+							}
+							offset = 0
+							return retryableError{err}
+						}
+					}
+					return err
+				}
+				defer layer.Close()
+				if !resumed && offset > 0 {
+					// The registry sent the whole layer again
+					if _, err := f.Seek(0, 0); err != nil {
+						return err
+					}
+					if err := f.Truncate(0); err != nil {
+						return err
+					}
+					offset = 0
+				}
+				if total >= 0 {
+					size = total
+				}
+				remaining := imgSize - int(offset)
+				if remaining < 0 {
+					remaining = 0
+				}
+				n, err := io.Copy(f, utils.ProgressReader(retryableReader{layer}, remaining, out, sf, false, utils.TruncateID(id), "Downloading"))
+				offset += n
+				if err == nil && size >= 0 && offset < size {
+					err = retryableError{io.ErrUnexpectedEOF}
+				}
+				return err
+			}()
+			if err == nil {
+				return nil
+			}
+			retryable, ok := err.(retryableError)
+			if !ok {
+				return err
+			}
+			err = retryable.error
+			if attempt == maxDownloadAttempts {
+				return fmt.Errorf("Failed to download the layer of %s after %d attempts: %s", utils.TruncateID(id), attempt, err)
+			}
+			out.Write(sf.FormatProgress(utils.TruncateID(id), fmt.Sprintf("Download interrupted (%s), resuming at %d bytes in %s", err, offset, delay), nil))
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}()
+	if err == nil {
+		out.Write(sf.FormatProgress(utils.TruncateID(id), "Verifying", nil))
+		if size >= 0 && offset != size {
+			err = fmt.Errorf("Downloaded %d bytes of the layer of %s instead of %d", offset, utils.TruncateID(id), size)
+		} else if _, err = f.Seek(0, 0); err == nil {
+			if err = archive.Verify(f); err != nil {
+				err = fmt.Errorf("Invalid layer for %s: %s", utils.TruncateID(id), err)
+			} else {
+				_, err = f.Seek(0, 0)
+			}
+		}
+		if err != nil {
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// layerDownload is the download of the layer of an image, which is
+// registered once its parent is
+type layerDownload struct {
+	id string
+	// checksum is the checksum of the image given by the index, if any
+	checksum string
+	img      *Image
+	imgJSON  []byte
+	// layer is nil if the image was already in the graph
+	layer *os.File
+	// pooled is set if the download holds the pull of the layer in the pool
+	pooled bool
+	err    error
+	done   chan struct{}
+}
+
+// fetchLayer downloads the image and its layer unless it is already in the
+// graph. The download waits for one of the download slots.
+func (srv *Server) fetchLayer(r *registry.Registry, out io.Writer, d *layerDownload, endpoint string, token []string, sf *utils.StreamFormatter) {
+	defer close(d.done)
+
+	// ensure no two downloads of the same layer happen at the same time
+	if c, err := srv.poolAdd("pull", "layer:"+d.id); err != nil {
+		utils.Errorf("Image (id: %s) pull is already running, skipping: %v", d.id, err)
+		<-c
+	} else {
+		d.pooled = true
+	}
+	if srv.runtime.graph.Exists(d.id) {
+		return
+	}
+
+	srv.downloadSlots <- struct{}{}
+	defer func() { <-srv.downloadSlots }()
+
+	out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Pulling metadata", nil))
+	imgJSON, imgSize, err := r.GetRemoteImageJSON(d.id, endpoint, token)
+	if err != nil {
+		d.err = err
+		return
+	}
+	img, err := NewImgJSON(imgJSON)
+	if err != nil {
+		d.err = fmt.Errorf("Failed to parse json: %s", err)
+		return
+	}
+	out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Pulling fs layer", nil))
+	layer, err := srv.downloadLayer(r, out, img.ID, endpoint, token, imgSize, sf)
+	if err != nil {
+		d.err = err
+		return
+	}
+	// The index doesn't know the checksums of the images pushed without
+	// them, those can't be checked, nor the legacy sha256: checksums
+	if strings.HasPrefix(d.checksum, "tarsum+sha256:") {
+		out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Verifying checksum", nil))
+		checksum, err := LayerDigest(layer, imgJSON)
+		if err == nil && checksum != d.checksum {
+			err = fmt.Errorf("The layer of %s doesn't match its checksum: expected %s, got %s", utils.TruncateID(d.id), d.checksum, checksum)
+		}
+		if err == nil {
+			_, err = layer.Seek(0, 0)
+		}
+		if err != nil {
+			layer.Close()
+			os.Remove(layer.Name())
+			d.err = err
+			return
+		}
+	}
+	d.img, d.imgJSON, d.layer = img, imgJSON, layer
+}
+
+// pullImage pulls the image imgID and its parents. The layers are checked
+// against checksums, the checksums of the images given by the index.
+func (srv *Server) pullImage(r *registry.Registry, out io.Writer, imgID, endpoint string, token []string, checksums map[string]string, sf *utils.StreamFormatter) error {
 	history, err := r.GetRemoteHistory(imgID, endpoint, token)
 	if err != nil {
 		return err
 	}
 	out.Write(sf.FormatProgress(utils.TruncateID(imgID), "Pulling dependent layers", nil))
-	// FIXME: Try to stream the images?
-	// FIXME: Launch the getRemoteImage() in goroutines
 
+	// The layers are downloaded at the same time, and registered from the
+	// base image, as each layer needs its parent in the graph
+	var downloads []*layerDownload
 	for i := len(history) - 1; i >= 0; i-- {
-		id := history[i]
-
-		// ensure no two downloads of the same layer happen at the same time
-		if c, err := srv.poolAdd("pull", "layer:"+id); err != nil {
-			utils.Errorf("Image (id: %s) pull is already running, skipping: %v", id, err)
-			<-c
-		}
-		defer srv.poolRemove("pull", "layer:"+id)
-
-		if !srv.runtime.graph.Exists(id) {
-			out.Write(sf.FormatProgress(utils.TruncateID(id), "Pulling metadata", nil))
-			imgJSON, imgSize, err := r.GetRemoteImageJSON(id, endpoint, token)
-			if err != nil {
-				out.Write(sf.FormatProgress(utils.TruncateID(id), "Error pulling dependent layers", nil))
-				// FIXME: Keep going in case of error?
-				return err
+		d := &layerDownload{id: history[i], checksum: checksums[history[i]], done: make(chan struct{})}
+		downloads = append(downloads, d)
+		go srv.fetchLayer(r, out, d, endpoint, token, sf)
+	}
+	defer func() {
+		for _, d := range downloads {
+			<-d.done
+			if d.layer != nil {
+				d.layer.Close()
+				os.Remove(d.layer.Name())
 			}
-			img, err := NewImgJSON(imgJSON)
-			if err != nil {
-				out.Write(sf.FormatProgress(utils.TruncateID(id), "Error pulling dependent layers", nil))
-				return fmt.Errorf("Failed to parse json: %s", err)
+			if d.pooled {
+				srv.poolRemove("pull", "layer:"+d.id)
 			}
+		}
+	}()
 
-			// Get the layer
-			out.Write(sf.FormatProgress(utils.TruncateID(id), "Pulling fs layer", nil))
-			layer, err := r.GetRemoteImageLayer(img.ID, endpoint, token)
-			if err != nil {
-				out.Write(sf.FormatProgress(utils.TruncateID(id), "Error pulling dependent layers", nil))
-				return err
-			}
-			defer layer.Close()
-			if err := srv.runtime.graph.Register(imgJSON, utils.ProgressReader(layer, imgSize, out, sf, false, utils.TruncateID(id), "Downloading"), img); err != nil {
-				out.Write(sf.FormatProgress(utils.TruncateID(id), "Error downloading dependent layers", nil))
+	for _, d := range downloads {
+		<-d.done
+		if d.err != nil {
+			out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Error pulling dependent layers", nil))
+			return d.err
+		}
+		if d.layer != nil {
+			if err := srv.runtime.graph.Register(d.imgJSON, d.layer, d.img); err != nil {
+				out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Error downloading dependent layers", nil))
 				return err
 			}
 		}
-		out.Write(sf.FormatProgress(utils.TruncateID(id), "Download complete", nil))
-
+		// Let the other pulls waiting for this layer go on
+		if d.pooled {
+			srv.poolRemove("pull", "layer:"+d.id)
+			d.pooled = false
+		}
+		out.Write(sf.FormatProgress(utils.TruncateID(d.id), "Download complete", nil))
 	}
 	return nil
 }
 
-func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName, remoteName, askedTag string, sf *utils.StreamFormatter, parallel bool) error {
+// pullRepository pulls the images of a repository. They are pulled from the
+// mirrors first, which are not given the tokens of the index, then from the
+// endpoints of the repository.
+func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName, remoteName, askedTag string, sf *utils.StreamFormatter, parallel bool, mirrors []string) error {
 	out.Write(sf.FormatStatus("", "Pulling repository %s", localName))
 
 	repoData, err := r.GetRepositoryData(remoteName)
@@ -1129,6 +1375,10 @@ func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName
 		return err
 	}
 
+	// The layers which a mirror doesn't have are pulled from the next
+	// endpoint, as the layers already pulled are skipped
+	endpoints := append(append([]string{}, mirrors...), repoData.Endpoints...)
+
 	utils.Debugf("Retrieving the tag list")
 	tagsList, err := r.GetRemoteTags(repoData.Endpoints, remoteName, repoData.Tokens)
 	if err != nil {
@@ -1136,11 +1386,18 @@ func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName
 		return err
 	}
 
+	// Keep the checksums of the index, the layers are checked against them
+	checksums := make(map[string]string)
+	for id, img := range repoData.ImgList {
+		if img.Checksum != "" {
+			checksums[id] = img.Checksum
+		}
+	}
 	for tag, id := range tagsList {
 		repoData.ImgList[id] = &registry.ImgData{
 			ID:       id,
 			Tag:      tag,
-			Checksum: "",
+			Checksum: checksums[id],
 		}
 	}
 
@@ -1197,9 +1454,13 @@ func (srv *Server) pullRepository(r *registry.Registry, out io.Writer, localName
 			out.Write(sf.FormatProgress(utils.TruncateID(img.ID), fmt.Sprintf("Pulling image (%s) from %s", img.Tag, localName), nil))
 			success := false
 			var lastErr error
-			for _, ep := range repoData.Endpoints {
+			for i, ep := range endpoints {
+				tokens := repoData.Tokens
+				if i < len(mirrors) {
+					tokens = nil
+				}
 				out.Write(sf.FormatProgress(utils.TruncateID(img.ID), fmt.Sprintf("Pulling image (%s) from %s, endpoint: %s", img.Tag, localName, ep), nil))
-				if err := srv.pullImage(r, out, img.ID, ep, repoData.Tokens, sf); err != nil {
+				if err := srv.pullImage(r, out, img.ID, ep, tokens, checksums, sf); err != nil {
 					// Its not ideal that only the last error  is returned, it would be better to concatenate the errors.
 					// As the error is also given to the output stream the user will see the error.
 					lastErr = err
@@ -1340,18 +1601,37 @@ func (srv *Server) ImagePull(job *engine.Job) engine.Status {
 		return job.Error(err)
 	}
 
+	var mirrors []string
 	if endpoint == auth.IndexServerAddress() {
 		// If pull "index.docker.io/foo/bar", it's stored locally under "foo/bar"
 		localName = remoteName
+		// The mirrors only cache the public images of the index
+		if len(srv.runtime.config.Mirrors) > 0 && isPublicRepository(authConfig, srv.HTTPRequestFactory(metaHeaders), endpoint, remoteName) {
+			mirrors = srv.runtime.config.Mirrors
+		}
 	}
 
-	if err = srv.pullRepository(r, job.Stdout, localName, remoteName, tag, sf, job.GetenvBool("parallel")); err != nil {
+	if err = srv.pullRepository(r, job.Stdout, localName, remoteName, tag, sf, job.GetenvBool("parallel"), mirrors); err != nil {
 		return job.Error(err)
 	}
 
 	return engine.StatusOK
 }
 
+// isPublicRepository returns whether the index serves the repository
+// remoteName without credentials
+func isPublicRepository(authConfig *auth.AuthConfig, factory *utils.HTTPRequestFactory, endpoint, remoteName string) bool {
+	if authConfig.Username == "" {
+		return true
+	}
+	r, err := registry.NewRegistry(&auth.AuthConfig{}, factory, endpoint)
+	if err != nil {
+		return false
+	}
+	_, err = r.GetRepositoryData(remoteName)
+	return err == nil
+}
+
 // Retrieve the all the images to be uploaded in the correct order
 func (srv *Server) getImageList(localRepo map[string]string) ([]string, map[string][]string, error) {
 	var (
@@ -1443,16 +1723,17 @@ func (srv *Server) pushRepository(r *registry.Registry, out io.Writer, localName
 	for _, ep := range repoData.Endpoints {
 		out.Write(sf.FormatStatus("", "Pushing repository %s (%d tags)", localName, len(localRepo)))
 
-		for _, imgId := range imgList {
-			if r.LookupRemoteImage(imgId, ep, repoData.Tokens) {
-				out.Write(sf.FormatStatus("", "Image %s already pushed, skipping", utils.TruncateID(imgId)))
-			} else {
-				if _, err := srv.pushImage(r, out, remoteName, imgId, ep, repoData.Tokens, sf); err != nil {
-					// FIXME: Continue on error?
-					return err
-				}
+		checksums, err := srv.pushImages(r, out, remoteName, imgList, ep, repoData.Tokens, sf)
+		if err != nil {
+			return err
+		}
+		// Give the index the checksums of the images, to check the pulls
+		for _, data := range imageIndex {
+			if checksum, exists := checksums[data.ID]; exists {
+				data.Checksum = checksum
 			}
-
+		}
+		for _, imgId := range imgList {
 			for _, tag := range tagsByImage[imgId] {
 				out.Write(sf.FormatStatus("", "Pushing tag for rev [%s] on {%s}", utils.TruncateID(imgId), ep+"repositories/"+remoteName+"/tags/"+tag))
 
@@ -1470,25 +1751,83 @@ func (srv *Server) pushRepository(r *registry.Registry, out io.Writer, localName
 	return nil
 }
 
-func (srv *Server) pushImage(r *registry.Registry, out io.Writer, remote, imgID, ep string, token []string, sf *utils.StreamFormatter) (checksum string, err error) {
-	out = utils.NewWriteFlusher(out)
+// pushImages pushes the images of imgList which the registry doesn't have
+// yet. The registry refuses an image whose parent it doesn't have, so the
+// JSONs are pushed first, one after the other in the order of imgList,
+// which has the parents first. Then the layers are uploaded at the same
+// time, in the upload slots. It returns the checksums of the images pushed.
+func (srv *Server) pushImages(r *registry.Registry, out io.Writer, remote string, imgList []string, ep string, token []string, sf *utils.StreamFormatter) (map[string]string, error) {
+	jsons := make(map[string][]byte)
+	var pending []string
+	for _, imgId := range imgList {
+		if r.LookupRemoteImage(imgId, ep, token) {
+			out.Write(sf.FormatStatus("", "Image %s already pushed, skipping", utils.TruncateID(imgId)))
+			continue
+		}
+		jsonRaw, err := srv.pushImageJSON(r, out, imgId, ep, token, sf)
+		if err != nil {
+			return nil, err
+		}
+		if jsonRaw != nil {
+			jsons[imgId] = jsonRaw
+			pending = append(pending, imgId)
+		}
+	}
+
+	type upload struct {
+		imgId, checksum string
+		err             error
+	}
+	uploads := make(chan upload)
+	for _, imgId := range pending {
+		go func(imgId string) {
+			srv.uploadSlots <- struct{}{}
+			defer func() { <-srv.uploadSlots }()
+			checksum, err := srv.pushImageLayer(r, out, imgId, jsons[imgId], ep, token, sf)
+			uploads <- upload{imgId, checksum, err}
+		}(imgId)
+	}
+	var lastError error
+	checksums := make(map[string]string)
+	for i := 0; i < len(pending); i++ {
+		if u := <-uploads; u.err != nil {
+			lastError = u.err
+		} else {
+			checksums[u.imgId] = u.checksum
+		}
+	}
+	if lastError != nil {
+		return nil, lastError
+	}
+	return checksums, nil
+}
+
+// pushImageJSON pushes the JSON of the image imgID and returns it, or nil if
+// the registry already has the image
+func (srv *Server) pushImageJSON(r *registry.Registry, out io.Writer, imgID, ep string, token []string, sf *utils.StreamFormatter) ([]byte, error) {
 	jsonRaw, err := ioutil.ReadFile(path.Join(srv.runtime.graph.Root, imgID, "json"))
 	if err != nil {
-		return "", fmt.Errorf("Cannot retrieve the path for {%s}: %s", imgID, err)
+		return nil, fmt.Errorf("Cannot retrieve the path for {%s}: %s", imgID, err)
 	}
 	out.Write(sf.FormatProgress(utils.TruncateID(imgID), "Pushing", nil))
 
-	imgData := &registry.ImgData{
-		ID: imgID,
-	}
-
 	// Send the json
-	if err := r.PushImageJSONRegistry(imgData, jsonRaw, ep, token); err != nil {
+	if err := r.PushImageJSONRegistry(&registry.ImgData{ID: imgID}, jsonRaw, ep, token); err != nil {
 		if err == registry.ErrAlreadyExists {
-			out.Write(sf.FormatProgress(utils.TruncateID(imgData.ID), "Image already pushed, skipping", nil))
-			return "", nil
+			out.Write(sf.FormatProgress(utils.TruncateID(imgID), "Image already pushed, skipping", nil))
+			return nil, nil
 		}
-		return "", err
+		return nil, err
+	}
+	return jsonRaw, nil
+}
+
+// pushImageLayer pushes the layer of the image imgID, whose JSON jsonRaw is
+// pushed, then its checksum. It must be called with one of the upload slots
+// held.
+func (srv *Server) pushImageLayer(r *registry.Registry, out io.Writer, imgID string, jsonRaw []byte, ep string, token []string, sf *utils.StreamFormatter) (checksum string, err error) {
+	imgData := &registry.ImgData{
+		ID: imgID,
 	}
 
 	layerData, err := srv.runtime.graph.TempLayerArchive(imgID, archive.Uncompressed, sf, out)
@@ -1512,6 +1851,17 @@ func (srv *Server) pushImage(r *registry.Registry, out io.Writer, remote, imgID,
 	return imgData.Checksum, nil
 }
 
+// pushImage pushes the image imgID, which must be called with one of the
+// upload slots held
+func (srv *Server) pushImage(r *registry.Registry, out io.Writer, remote, imgID, ep string, token []string, sf *utils.StreamFormatter) (checksum string, err error) {
+	out = utils.NewWriteFlusher(out)
+	jsonRaw, err := srv.pushImageJSON(r, out, imgID, ep, token, sf)
+	if err != nil || jsonRaw == nil {
+		return "", err
+	}
+	return srv.pushImageLayer(r, out, imgID, jsonRaw, ep, token, sf)
+}
+
 // FIXME: Allow to interrupt current push when new push of same image is done.
 func (srv *Server) ImagePush(job *engine.Job) engine.Status {
 	if n := len(job.Args); n != 1 {
@@ -1558,6 +1908,8 @@ func (srv *Server) ImagePush(job *engine.Job) engine.Status {
 
 	var token []string
 	job.Stdout.Write(sf.FormatStatus("", "The push refers to an image: [%s]", localName))
+	srv.uploadSlots <- struct{}{}
+	defer func() { <-srv.uploadSlots }()
 	if _, err := srv.pushImage(r, job.Stdout, remoteName, img.ID, endpoint, token, sf); err != nil {
 		return job.Error(err)
 	}
@@ -1744,20 +2096,15 @@ func (srv *Server) ContainerDestroy(job *engine.Job) engine.Status {
 		srv.LogEvent("destroy", container.ID, srv.runtime.repositories.ImageName(container.Image))
 
 		if removeVolume {
-			var (
-				volumes     = make(map[string]struct{})
-				binds       = make(map[string]struct{})
-				usedVolumes = make(map[string]*Container)
-			)
-
-			// the volume id is always the base of the path
-			getVolumeId := func(p string) string {
-				return filepath.Base(strings.TrimSuffix(p, "/layer"))
-			}
+			binds := make(map[string]struct{})
 
 			// populate bind map so that they can be skipped and not removed
 			for _, bind := range container.hostConfig.Binds {
 				source := strings.Split(bind, ":")[0]
+				// named volumes are not host paths
+				if !filepath.IsAbs(source) {
+					continue
+				}
 				// TODO: refactor all volume stuff, all of it
 				// this is very important that we eval the link
 				// or comparing the keys to container.Volumes will not work
@@ -1769,34 +2116,24 @@ func (srv *Server) ContainerDestroy(job *engine.Job) engine.Status {
 				binds[source] = struct{}{}
 			}
 
-			// Store all the deleted containers volumes
-			for _, volumeId := range container.Volumes {
+			for _, p := range container.Volumes {
 				// Skip the volumes mounted from external
 				// bind mounts here will will be evaluated for a symlink
-				if _, exists := binds[volumeId]; exists {
+				if _, exists := binds[p]; exists {
 					continue
 				}
-
-				volumeId = getVolumeId(volumeId)
-				volumes[volumeId] = struct{}{}
-			}
-
-			// Retrieve all volumes from all remaining containers
-			for _, container := range srv.runtime.List() {
-				for _, containerVolumeId := range container.Volumes {
-					containerVolumeId = getVolumeId(containerVolumeId)
-					usedVolumes[containerVolumeId] = container
+				// The named volumes are kept until they are removed
+				// with docker volume rm
+				volume := srv.runtime.volumes.ByPath(p)
+				if volume == nil || !volume.Anonymous {
+					continue
 				}
-			}
-
-			for volumeId := range volumes {
-				// If the requested volu
-				if c, exists := usedVolumes[volumeId]; exists {
-					log.Printf("The volume %s is used by the container %s. Impossible to remove it. Skipping.\n", volumeId, c.ID)
+				if refs := srv.runtime.volumes.Refs(volume); len(refs) != 0 {
+					log.Printf("The volume %s is used by the container %s. Impossible to remove it. Skipping.\n", volume.ID, refs[0])
 					continue
 				}
-				if err := srv.runtime.volumes.Delete(volumeId); err != nil {
-					return job.Errorf("Error calling volumes.Delete(%q): %v", volumeId, err)
+				if err := srv.runtime.volumes.Delete(volume.Name); err != nil {
+					return job.Errorf("Error calling volumes.Delete(%q): %v", volume.Name, err)
 				}
 			}
 		}
@@ -1806,6 +2143,82 @@ func (srv *Server) ContainerDestroy(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
+func (srv *Server) VolumeCreate(job *engine.Job) engine.Status {
+	var name string
+	switch len(job.Args) {
+	case 0:
+	case 1:
+		name = job.Args[0]
+	default:
+		return job.Errorf("Usage: %s [NAME]", job.Name)
+	}
+	options := make(map[string]string)
+	for _, opt := range job.GetenvList("Opts") {
+		parts := strings.SplitN(opt, "=", 2)
+		if len(parts) != 2 {
+			return job.Errorf("Bad parameter, invalid volume option %s, expected key=value", opt)
+		}
+		options[parts[0]] = parts[1]
+	}
+	volume, err := srv.runtime.volumes.Create(name, job.Getenv("Driver"), options)
+	if err != nil {
+		return job.Error(err)
+	}
+	job.Printf("%s\n", volume.Name)
+	return engine.StatusOK
+}
+
+func (srv *Server) Volumes(job *engine.Job) engine.Status {
+	outs := engine.NewTable("", 0)
+	for _, volume := range srv.runtime.volumes.List() {
+		out := &engine.Env{}
+		out.Set("Name", volume.Name)
+		out.Set("Id", volume.ID)
+		out.Set("Driver", volume.Driver)
+		out.SetInt64("Created", volume.Created.Unix())
+		out.SetList("Containers", srv.runtime.volumes.Refs(volume))
+		outs.Add(out)
+	}
+	if _, err := outs.WriteListTo(job.Stdout); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
+func (srv *Server) VolumeInspect(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s NAME", job.Name)
+	}
+	volume := srv.runtime.volumes.Get(job.Args[0])
+	if volume == nil {
+		return job.Errorf("No such volume: %s", job.Args[0])
+	}
+	p, err := srv.runtime.volumes.Path(volume)
+	if err != nil {
+		return job.Error(err)
+	}
+	b, err := json.Marshal(&struct {
+		*Volume
+		Path       string
+		Containers []string
+	}{volume, p, srv.runtime.volumes.Refs(volume)})
+	if err != nil {
+		return job.Error(err)
+	}
+	job.Stdout.Write(b)
+	return engine.StatusOK
+}
+
+func (srv *Server) VolumeDelete(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s NAME", job.Name)
+	}
+	if err := srv.runtime.volumes.Delete(job.Args[0]); err != nil {
+		return job.Error(err)
+	}
+	return engine.StatusOK
+}
+
 var ErrImageReferenced = errors.New("Image referenced by a repository")
 
 func (srv *Server) deleteImageAndChildren(id string, imgs *engine.Table, byParents map[string][]*Image) error {
@@ -2080,6 +2493,11 @@ func (srv *Server) ContainerStart(job *engine.Job) engine.Status {
 				return job.Errorf("Invalid bind mount '%s' : source can't be '/'", bind)
 			}
 
+			// named volumes are created when the container starts
+			if !filepath.IsAbs(source) {
+				continue
+			}
+
 			// ensure the source exists on the host
 			_, err := os.Stat(source)
 			if err != nil && os.IsNotExist(err) {
@@ -2096,6 +2514,8 @@ func (srv *Server) ContainerStart(job *engine.Job) engine.Status {
 		container.hostConfig = hostConfig
 		container.ToDisk()
 	}
+	// The restart policy counts restarts from the last explicit start
+	container.RestartCount = 0
 	if err := container.Start(); err != nil {
 		return job.Errorf("Cannot start container %s: %s", name, err)
 	}
@@ -2126,6 +2546,38 @@ func (srv *Server) ContainerStop(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
+func (srv *Server) ContainerPause(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER", job.Name)
+	}
+	name := job.Args[0]
+	container := srv.runtime.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	if err := container.Pause(); err != nil {
+		return job.Errorf("Cannot pause container %s: %s", name, err)
+	}
+	srv.LogEvent("pause", container.ID, srv.runtime.repositories.ImageName(container.Image))
+	return engine.StatusOK
+}
+
+func (srv *Server) ContainerUnpause(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER", job.Name)
+	}
+	name := job.Args[0]
+	container := srv.runtime.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	if err := container.Unpause(); err != nil {
+		return job.Errorf("Cannot unpause container %s: %s", name, err)
+	}
+	srv.LogEvent("unpause", container.ID, srv.runtime.repositories.ImageName(container.Image))
+	return engine.StatusOK
+}
+
 func (srv *Server) ContainerWait(job *engine.Job) engine.Status {
 	if len(job.Args) != 1 {
 		return job.Errorf("Usage: %s", job.Name)
@@ -2181,7 +2633,9 @@ func (srv *Server) ContainerAttach(job *engine.Job) engine.Status {
 	}
 
 	//logs
-	if logs {
+	if logs && container.logDriverName() != jsonfilelog.Name {
+		utils.Debugf("%s: No logs to replay with the %s log driver", container.ID, container.logDriverName())
+	} else if logs {
 		cLog, err := container.ReadLog("json")
 		if err != nil && os.IsNotExist(err) {
 			// Legacy logs
@@ -2265,6 +2719,322 @@ func (srv *Server) ContainerAttach(job *engine.Job) engine.Status {
 	return engine.StatusOK
 }
 
+func (srv *Server) ContainerStats(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER", job.Name)
+	}
+	var (
+		name   = job.Args[0]
+		stream = job.GetenvBool("stream")
+	)
+	container := srv.runtime.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	if !container.State.IsRunning() {
+		return job.Errorf("Container %s is not running", name)
+	}
+
+	enc := json.NewEncoder(job.Stdout)
+	for {
+		stats, err := container.Stats()
+		if err != nil {
+			// The container stopped while we were streaming
+			if !container.State.IsRunning() {
+				return engine.StatusOK
+			}
+			return job.Errorf("Cannot get the statistics of container %s: %s", name, err)
+		}
+		if err := enc.Encode(stats); err != nil {
+			// The client went away
+			return engine.StatusOK
+		}
+		if !stream {
+			return engine.StatusOK
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
+func (srv *Server) ContainerExec(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+
+	var (
+		name   = job.Args[0]
+		id     = job.Getenv("Id")
+		cmd    = job.GetenvList("Cmd")
+		user   = job.Getenv("User")
+		tty    = job.GetenvBool("Tty")
+		stdin  = job.GetenvBool("stdin")
+		stdout = job.GetenvBool("stdout")
+		stderr = job.GetenvBool("stderr")
+	)
+
+	if len(cmd) == 0 {
+		return job.Errorf("No command specified")
+	}
+
+	container := srv.runtime.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+	if !container.State.IsRunning() {
+		return job.Errorf("Container %s is not running", name)
+	}
+
+	// The exit code of the exec is kept until it is waited for by id
+	exitCode := -1
+	if id != "" {
+		exited, err := srv.newExec(id)
+		if err != nil {
+			return job.Error(err)
+		}
+		defer func() { srv.execExited(id, exited, exitCode) }()
+	}
+
+	var (
+		cStdin           io.Reader
+		cStdout, cStderr io.Writer
+	)
+	if stdin {
+		r, w := io.Pipe()
+		go func() {
+			defer w.Close()
+			defer utils.Debugf("Closing buffered stdin pipe")
+			io.Copy(w, job.Stdin)
+		}()
+		cStdin = r
+	}
+	if stdout {
+		cStdout = job.Stdout
+	}
+	if stderr {
+		cStderr = job.Stderr
+	}
+
+	exitCode, err := container.Exec(cmd, user, tty, cStdin, cStdout, cStderr)
+	if err != nil {
+		return job.Errorf("Cannot exec in container %s: %s", name, err)
+	}
+	utils.Debugf("Exec of %v in container %s exited with code %d", cmd, name, exitCode)
+	return engine.StatusOK
+}
+
+// execRetention is how long the exit code of an exec is kept once it has
+// exited, for clients that never wait for it
+var execRetention = time.Minute
+
+// newExec registers the exec id, to which the exit code is sent
+func (srv *Server) newExec(id string) (chan int, error) {
+	srv.Lock()
+	defer srv.Unlock()
+	if _, exists := srv.execs[id]; exists {
+		return nil, fmt.Errorf("Conflict, the exec id %s is already in use", id)
+	}
+	exited := make(chan int, 1)
+	srv.execs[id] = exited
+	return exited, nil
+}
+
+// execExited sends the exit code of the exec and forgets about it after
+// execRetention if nobody waited for it in the meantime
+func (srv *Server) execExited(id string, exited chan int, exitCode int) {
+	exited <- exitCode
+	time.AfterFunc(execRetention, func() {
+		srv.Lock()
+		if srv.execs[id] == exited {
+			delete(srv.execs, id)
+		}
+		srv.Unlock()
+	})
+}
+
+// ContainerExecWait blocks until the exec started with the id of the job
+// exits, and prints its exit code
+func (srv *Server) ContainerExecWait(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s ID", job.Name)
+	}
+	id := job.Args[0]
+	srv.Lock()
+	exited, exists := srv.execs[id]
+	srv.Unlock()
+	if !exists {
+		return job.Errorf("No such exec: %s", id)
+	}
+	exitCode := <-exited
+	srv.Lock()
+	if srv.execs[id] == exited {
+		delete(srv.execs, id)
+	}
+	srv.Unlock()
+	job.Printf("%d\n", exitCode)
+	return engine.StatusOK
+}
+
+func (srv *Server) ContainerLogs(job *engine.Job) engine.Status {
+	if len(job.Args) != 1 {
+		return job.Errorf("Usage: %s CONTAINER\n", job.Name)
+	}
+
+	var (
+		name   = job.Args[0]
+		stdout = job.GetenvBool("stdout")
+		stderr = job.GetenvBool("stderr")
+		follow = job.GetenvBool("follow")
+		since  = job.GetenvInt64("since")
+		tail   = job.Getenv("tail")
+		format string
+		lines  = -1
+	)
+	if !(stdout || stderr) {
+		return job.Errorf("You must choose at least one stream")
+	}
+	if job.GetenvBool("timestamps") {
+		format = time.RFC3339Nano
+	}
+	if tail != "" && tail != "all" {
+		n, err := strconv.Atoi(tail)
+		if err != nil || n < 0 {
+			return job.Errorf("Invalid number of lines to tail: %s", tail)
+		}
+		lines = n
+	}
+
+	container := srv.runtime.Get(name)
+	if container == nil {
+		return job.Errorf("No such container: %s", name)
+	}
+
+	if driver := container.logDriverName(); driver != jsonfilelog.Name {
+		return job.Errorf("The logs of %s are not available with the %s log driver", name, driver)
+	}
+
+	logPath := container.logPath("json")
+	f, err := os.Open(logPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Legacy logs can only be replayed as a whole
+			utils.Debugf("Old logs format")
+			if stdout {
+				if cLog, err := container.ReadLog("stdout"); err == nil {
+					io.Copy(job.Stdout, cLog)
+				}
+			}
+			if stderr {
+				if cLog, err := container.ReadLog("stderr"); err == nil {
+					io.Copy(job.Stderr, cLog)
+				}
+			}
+			return engine.StatusOK
+		}
+		return job.Error(err)
+	}
+	defer func() {
+		f.Close()
+	}()
+
+	if lines != -1 {
+		offset, err := tailfile.Offset(f, lines)
+		if err != nil {
+			return job.Error(err)
+		}
+		if _, err := f.Seek(offset, 0); err != nil {
+			return job.Error(err)
+		}
+	}
+
+	var (
+		r       = bufio.NewReader(f)
+		pending []byte
+		stopped = !follow
+		rotated bool
+	)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// When following, poll for new entries until the container
+			// stops, then read the last ones
+			pending = append(pending, line...)
+			if stopped {
+				break
+			}
+			if rotated {
+				// The end of the previous file has been read, go on
+				// with the new one
+				f.Close()
+				if f, err = os.Open(logPath); err != nil {
+					return job.Error(err)
+				}
+				r = bufio.NewReader(f)
+				pending = nil
+				rotated = false
+				continue
+			}
+			stopped = !container.State.IsRunning()
+			if !stopped {
+				if rotated = logRotated(f, logPath); !rotated {
+					time.Sleep(100 * time.Millisecond)
+				}
+			}
+			continue
+		} else if err != nil {
+			return job.Error(err)
+		}
+		if pending != nil {
+			line = append(pending, line...)
+			pending = nil
+		}
+
+		l := &utils.JSONLog{}
+		if err := json.Unmarshal(line, l); err != nil {
+			utils.Errorf("Error streaming logs: %s", err)
+			continue
+		}
+		if since != 0 && l.Created.Unix() < since {
+			continue
+		}
+		var dst io.Writer
+		if l.Stream == "stdout" && stdout {
+			dst = job.Stdout
+		} else if l.Stream == "stderr" && stderr {
+			dst = job.Stderr
+		} else {
+			continue
+		}
+		if format != "" {
+			_, err = fmt.Fprintf(dst, "%s %s", l.Created.Format(format), l.Log)
+		} else {
+			_, err = io.WriteString(dst, l.Log)
+		}
+		if err != nil {
+			// The client went away
+			return engine.StatusOK
+		}
+	}
+	return engine.StatusOK
+}
+
+// logRotated returns whether the log file f has been rotated by the
+// json-file log driver since it was opened, that is renamed or truncated
+func logRotated(f *os.File, logPath string) bool {
+	fi, err := os.Stat(logPath)
+	if err != nil {
+		return false
+	}
+	current, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	if !os.SameFile(fi, current) {
+		return true
+	}
+	offset, err := f.Seek(0, 1)
+	return err == nil && fi.Size() < offset
+}
+
 func (srv *Server) ContainerInspect(name string) (*Container, error) {
 	if container := srv.runtime.Get(name); container != nil {
 		return container, nil
@@ -2353,13 +3123,24 @@ func NewServer(eng *engine.Engine, config *DaemonConfig) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
+	downloads, uploads := config.MaxConcurrentDownloads, config.MaxConcurrentUploads
+	if downloads <= 0 {
+		downloads = DefaultMaxConcurrentDownloads
+	}
+	if uploads <= 0 {
+		uploads = DefaultMaxConcurrentUploads
+	}
+	registry.InsecureRegistries = config.InsecureRegistries
 	srv := &Server{
-		Eng:         eng,
-		runtime:     runtime,
-		pullingPool: make(map[string]chan struct{}),
-		pushingPool: make(map[string]chan struct{}),
-		events:      make([]utils.JSONMessage, 0, 64), //only keeps the 64 last events
-		listeners:   make(map[string]chan utils.JSONMessage),
+		Eng:           eng,
+		runtime:       runtime,
+		pullingPool:   make(map[string]chan struct{}),
+		pushingPool:   make(map[string]chan struct{}),
+		downloadSlots: make(chan struct{}, downloads),
+		uploadSlots:   make(chan struct{}, uploads),
+		events:        make([]utils.JSONMessage, 0, 64), //only keeps the 64 last events
+		listeners:     make(map[string]chan utils.JSONMessage),
+		execs:         make(map[string]chan int),
 	}
 	runtime.srv = srv
 	return srv, nil
@@ -2414,7 +3195,13 @@ type Server struct {
 	runtime     *Runtime
 	pullingPool map[string]chan struct{}
 	pushingPool map[string]chan struct{}
-	events      []utils.JSONMessage
-	listeners   map[string]chan utils.JSONMessage
-	Eng         *engine.Engine
+	// The layers downloaded and uploaded at the same time, by all the
+	// pulls and pushes, are bounded by the size of these channels
+	downloadSlots chan struct{}
+	uploadSlots   chan struct{}
+	events        []utils.JSONMessage
+	listeners     map[string]chan utils.JSONMessage
+	// The exit codes of the execs, by id, until they are waited for
+	execs map[string]chan int
+	Eng   *engine.Engine
 }
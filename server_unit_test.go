@@ -1,7 +1,21 @@
 package docker
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/dotcloud/docker/auth"
+	"github.com/dotcloud/docker/graphdriver"
+	"github.com/dotcloud/docker/registry"
 	"github.com/dotcloud/docker/utils"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -80,6 +94,50 @@ func TestLogEvent(t *testing.T) {
 	})
 }
 
+func TestExecRetention(t *testing.T) {
+	defer func(d time.Duration) { execRetention = d }(execRetention)
+	execRetention = 100 * time.Millisecond
+
+	srv := &Server{execs: make(map[string]chan int)}
+
+	exited, err := srv.newExec("detached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.newExec("detached"); err == nil {
+		t.Fatal("Expected a conflict on the exec id")
+	}
+	srv.execExited("detached", exited, 42)
+
+	// Nobody waits for this exec, it has to be forgotten about
+	setTimeout(t, "The exec was never removed", 2*time.Second, func() {
+		for {
+			srv.Lock()
+			_, exists := srv.execs["detached"]
+			srv.Unlock()
+			if !exists {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+
+	// A new exec with the same id must not be removed by the timer of
+	// the previous one
+	exited, err = srv.newExec("detached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(2 * execRetention)
+	srv.Lock()
+	_, exists := srv.execs["detached"]
+	srv.Unlock()
+	if !exists {
+		t.Fatal("The running exec was removed")
+	}
+	srv.execExited("detached", exited, 0)
+}
+
 // FIXME: this is duplicated from integration/commands_test.go
 func setTimeout(t *testing.T, msg string, d time.Duration, f func()) {
 	c := make(chan bool)
@@ -97,3 +155,302 @@ func setTimeout(t *testing.T, msg string, d time.Duration, f func()) {
 		t.Fatal(msg)
 	}
 }
+
+func TestDownloadLayerRetries(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	srv := &Server{runtime: &Runtime{graph: &Graph{Root: tmp}}}
+	defer func(d time.Duration) { downloadRetryDelay = d }(downloadRetryDelay)
+	downloadRetryDelay = time.Millisecond
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/_ping" {
+			return
+		}
+		// An error of the server, then a layer which doesn't exist
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	endpoint := server.URL + "/v1/"
+	r, err := registry.NewRegistry(&auth.AuthConfig{}, utils.NewHTTPRequestFactory(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := srv.downloadLayer(r, ioutil.Discard, "foo", endpoint, nil, 0, utils.NewStreamFormatter(false)); err == nil {
+		t.Fatal("Expected an error downloading a layer which doesn't exist")
+	}
+	if requests != 2 {
+		t.Fatalf("Expected the server error to be retried and not the 404, got %d requests", requests)
+	}
+}
+
+func TestDownloadLayerResumesAcrossPulls(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	srv := &Server{runtime: &Runtime{graph: &Graph{Root: tmp}}}
+	defer func(d time.Duration) { downloadRetryDelay = d }(downloadRetryDelay)
+	downloadRetryDelay = time.Millisecond
+
+	archive, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := ioutil.ReadAll(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := len(layer) / 2
+
+	var (
+		interrupted = true
+		ranges      []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/_ping" {
+			return
+		}
+		ranges = append(ranges, r.Header.Get("Range"))
+		switch {
+		case interrupted && len(ranges) == 1:
+			// Half of the layer, then the connection is lost
+			w.Header().Set("Content-Length", strconv.Itoa(len(layer)))
+			w.Write(layer[:half])
+		case interrupted:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.Header.Get("Range") == fmt.Sprintf("bytes=%d-", half):
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", half, len(layer)-1, len(layer)))
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(layer[half:])
+		default:
+			w.Write(layer)
+		}
+	}))
+	defer server.Close()
+	endpoint := server.URL + "/v1/"
+	r, err := registry.NewRegistry(&auth.AuthConfig{}, utils.NewHTTPRequestFactory(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := srv.downloadLayer(r, ioutil.Discard, "foo", endpoint, nil, len(layer), utils.NewStreamFormatter(false)); err == nil {
+		t.Fatal("Expected the download to fail")
+	}
+	if len(ranges) != maxDownloadAttempts {
+		t.Fatalf("Expected %d attempts, got %d", maxDownloadAttempts, len(ranges))
+	}
+
+	// The next pull resumes where the first one stopped
+	interrupted = false
+	ranges = nil
+	f, err := srv.downloadLayer(r, ioutil.Discard, "foo", endpoint, nil, len(layer), utils.NewStreamFormatter(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if len(ranges) != 1 || ranges[0] != fmt.Sprintf("bytes=%d-", half) {
+		t.Fatalf("Expected the download to resume at %d bytes, got the ranges %q", half, ranges)
+	}
+	downloaded, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(downloaded, layer) {
+		t.Fatal("The downloaded layer doesn't match the layer")
+	}
+}
+
+func TestPushImagesParentFirst(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	driver, err := graphdriver.GetDriver("vfs", tmp, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer driver.Cleanup()
+	graph, err := NewGraph(path.Join(tmp, "graph"), driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imgList []string
+	for i, parent := 0, ""; i < 4; i++ {
+		layer, err := fakeTar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		img := &Image{ID: GenerateID(), Parent: parent}
+		if err := graph.Register(nil, layer, img); err != nil {
+			t.Fatal(err)
+		}
+		imgList = append(imgList, img.ID)
+		parent = img.ID
+	}
+	srv := &Server{
+		runtime:     &Runtime{graph: graph},
+		uploadSlots: make(chan struct{}, len(imgList)),
+	}
+
+	store, err := registry.NewFSStore(path.Join(tmp, "registry"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	registryServer := registry.NewServer(store)
+	var (
+		lock   sync.Mutex
+		pushed = make(map[string]bool)
+		// Closed once all the layers are being uploaded at the same time
+		uploading    = 0
+		allUploading = make(chan struct{})
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Like the registry, refuse the images whose parent isn't pushed
+		parts := strings.Split(r.URL.Path, "/")
+		if r.Method == "PUT" && len(parts) == 5 && parts[2] == "images" && parts[4] == "layer" {
+			lock.Lock()
+			if uploading++; uploading == len(imgList) {
+				close(allUploading)
+			}
+			lock.Unlock()
+			select {
+			case <-allUploading:
+			case <-time.After(5 * time.Second):
+				t.Error("Expected the layers to be uploaded at the same time")
+			}
+		}
+		if r.Method == "PUT" && len(parts) == 5 && parts[2] == "images" && parts[4] == "json" {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			var img struct {
+				Parent string `json:"parent"`
+			}
+			json.Unmarshal(body, &img)
+			lock.Lock()
+			parentPushed := img.Parent == "" || pushed[img.Parent]
+			lock.Unlock()
+			if !parentPushed {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		registryServer.ServeHTTP(w, r)
+		if r.Method == "PUT" && len(parts) == 5 && parts[2] == "images" && parts[4] == "json" {
+			lock.Lock()
+			pushed[parts[3]] = true
+			lock.Unlock()
+		}
+	}))
+	defer server.Close()
+	endpoint := server.URL + "/v1/"
+	r, err := registry.NewRegistry(&auth.AuthConfig{}, utils.NewHTTPRequestFactory(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checksums, err := srv.pushImages(r, ioutil.Discard, "foo", imgList, endpoint, nil, utils.NewStreamFormatter(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range imgList {
+		if !pushed[id] {
+			t.Fatalf("Expected %s to be pushed", id)
+		}
+		if checksum, err := store.ImageChecksum(id); err != nil {
+			t.Fatal(err)
+		} else if checksums[id] != checksum {
+			t.Fatalf("Expected the checksum of %s to be %s, got %s", id, checksum, checksums[id])
+		}
+	}
+}
+
+func TestPullImageChecksum(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	newServer := func(root string) *Server {
+		driver, err := graphdriver.GetDriver("vfs", path.Join(tmp, root), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		graph, err := NewGraph(path.Join(tmp, root, "graph"), driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Server{
+			runtime:       &Runtime{graph: graph},
+			pullingPool:   make(map[string]chan struct{}),
+			pushingPool:   make(map[string]chan struct{}),
+			downloadSlots: make(chan struct{}, 1),
+			uploadSlots:   make(chan struct{}, 1),
+		}
+	}
+	pusher, puller, legacyPuller := newServer("push"), newServer("pull"), newServer("legacy")
+	layer, err := fakeTar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := &Image{ID: GenerateID()}
+	if err := pusher.runtime.graph.Register(nil, layer, img); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := registry.NewFSStore(path.Join(tmp, "registry"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(registry.NewServer(store))
+	defer server.Close()
+	endpoint := server.URL + "/v1/"
+	r, err := registry.NewRegistry(&auth.AuthConfig{}, utils.NewHTTPRequestFactory(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf := utils.NewStreamFormatter(false)
+	checksums, err := pusher.pushImages(r, ioutil.Discard, "foo", []string{img.ID}, endpoint, nil, sf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrong := map[string]string{img.ID: "tarsum+sha256:0000"}
+	if err := puller.pullImage(r, ioutil.Discard, img.ID, endpoint, nil, wrong, sf); err == nil {
+		t.Fatal("Expected an error pulling a layer which doesn't match its checksum")
+	}
+	if puller.runtime.graph.Exists(img.ID) {
+		t.Fatal("Expected the layer not to be registered")
+	}
+	if err := puller.pullImage(r, ioutil.Discard, img.ID, endpoint, nil, checksums, sf); err != nil {
+		t.Fatal(err)
+	}
+	if !puller.runtime.graph.Exists(img.ID) {
+		t.Fatal("Expected the layer to be registered")
+	}
+
+	// The legacy checksums, a sha256 of the JSON and the layer, aren't
+	// checked
+	legacy := map[string]string{img.ID: "sha256:0000"}
+	if err := legacyPuller.pullImage(r, ioutil.Discard, img.ID, endpoint, nil, legacy, sf); err != nil {
+		t.Fatal(err)
+	}
+	if !legacyPuller.runtime.graph.Exists(img.ID) {
+		t.Fatal("Expected the layer with a legacy checksum to be registered")
+	}
+}
@@ -15,6 +15,7 @@ type State struct {
 	StartedAt  time.Time
 	FinishedAt time.Time
 	Ghost      bool
+	Paused     bool
 }
 
 // String returns a human-readable description of the state
@@ -26,6 +27,9 @@ func (s *State) String() string {
 		if s.Ghost {
 			return fmt.Sprintf("Ghost")
 		}
+		if s.Paused {
+			return fmt.Sprintf("Up %s (Paused)", utils.HumanDuration(time.Now().UTC().Sub(s.StartedAt)))
+		}
 		return fmt.Sprintf("Up %s", utils.HumanDuration(time.Now().UTC().Sub(s.StartedAt)))
 	}
 	return fmt.Sprintf("Exit %d", s.ExitCode)
@@ -45,6 +49,13 @@ func (s *State) IsGhost() bool {
 	return s.Ghost
 }
 
+func (s *State) IsPaused() bool {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.Paused
+}
+
 func (s *State) GetExitCode() int {
 	s.RLock()
 	defer s.RUnlock()
@@ -64,6 +75,7 @@ func (s *State) SetRunning(pid int) {
 	defer s.Unlock()
 
 	s.Running = true
+	s.Paused = false
 	s.Ghost = false
 	s.ExitCode = 0
 	s.Pid = pid
@@ -75,7 +87,22 @@ func (s *State) SetStopped(exitCode int) {
 	defer s.Unlock()
 
 	s.Running = false
+	s.Paused = false
 	s.Pid = 0
 	s.FinishedAt = time.Now().UTC()
 	s.ExitCode = exitCode
 }
+
+func (s *State) SetPaused() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Paused = true
+}
+
+func (s *State) SetUnpaused() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Paused = false
+}
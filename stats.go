@@ -0,0 +1,185 @@
+package docker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The unit of the CPU times in /proc/stat (USER_HZ), which is 100 on
+// every architecture docker supports
+const clockTicksPerSecond = 100
+
+type ContainerStats struct {
+	Read           time.Time
+	CpuUsage       uint64 // CPU time consumed by the container (in nanoseconds)
+	SystemCpuUsage uint64 // CPU time consumed by the whole host (in nanoseconds)
+	MemoryUsage    uint64
+	MemoryLimit    uint64
+	BlkioRead      uint64
+	BlkioWrite     uint64
+	Network        NetworkStats
+}
+
+// NetworkStats are the counters of the eth0 interface of the container,
+// which is the container end of its veth pair
+type NetworkStats struct {
+	RxBytes   uint64
+	RxPackets uint64
+	RxErrors  uint64
+	RxDropped uint64
+	TxBytes   uint64
+	TxPackets uint64
+	TxErrors  uint64
+	TxDropped uint64
+}
+
+// Stats returns the current resource usage of the container, read from
+// its cgroups and its network interface.
+func (container *Container) Stats() (*ContainerStats, error) {
+	if !container.State.IsRunning() {
+		return nil, fmt.Errorf("Container %s is not running", container.ID)
+	}
+	cgroupStats, err := container.runtime.execDriver.GetStatsForContainer(container.ID)
+	if err != nil {
+		return nil, err
+	}
+	stats := &ContainerStats{
+		Read:        time.Now().UTC(),
+		CpuUsage:    cgroupStats.CpuUsage,
+		MemoryUsage: cgroupStats.MemoryUsage,
+		MemoryLimit: cgroupStats.MemoryLimit,
+		BlkioRead:   cgroupStats.BlkioRead,
+		BlkioWrite:  cgroupStats.BlkioWrite,
+	}
+
+	if stats.SystemCpuUsage, err = getSystemCpuUsage(); err != nil {
+		return nil, err
+	}
+
+	// Without a limit, the cgroup reports a huge number
+	if total, err := getTotalMemory(); err == nil && (stats.MemoryLimit == 0 || stats.MemoryLimit > total) {
+		stats.MemoryLimit = total
+	}
+
+	if !container.Config.NetworkDisabled {
+		// Any process of the container sees its network namespace
+		pids, err := container.runtime.execDriver.GetPidsForContainer(container.ID)
+		if err != nil {
+			return nil, err
+		}
+		if len(pids) > 0 {
+			f, err := os.Open(filepath.Join("/proc", strconv.Itoa(pids[0]), "net", "dev"))
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			if err := parseNetDev(f, "eth0", &stats.Network); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return stats, nil
+}
+
+// Parse the counters of iface from a /proc/net/dev file:
+// IFACE: RX_BYTES RX_PACKETS RX_ERRS RX_DROP FIFO FRAME COMPRESSED MULTICAST TX_BYTES TX_PACKETS TX_ERRS TX_DROP ...
+func parseNetDev(r io.Reader, iface string, stats *NetworkStats) error {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		parts := strings.SplitN(s.Text(), ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) != iface {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) < 12 {
+			return fmt.Errorf("Invalid network statistics for %s: %s", iface, s.Text())
+		}
+		for i, counter := range []*uint64{
+			&stats.RxBytes, &stats.RxPackets, &stats.RxErrors, &stats.RxDropped,
+			nil, nil, nil, nil,
+			&stats.TxBytes, &stats.TxPackets, &stats.TxErrors, &stats.TxDropped,
+		} {
+			if counter == nil {
+				continue
+			}
+			value, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				return err
+			}
+			*counter = value
+		}
+		return nil
+	}
+	return s.Err()
+}
+
+// getSystemCpuUsage returns the CPU time consumed by the host since boot,
+// in nanoseconds, from the first line of /proc/stat:
+// cpu USER NICE SYSTEM IDLE IOWAIT IRQ SOFTIRQ ...
+func getSystemCpuUsage() (uint64, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return parseSystemCpuUsage(f)
+}
+
+func parseSystemCpuUsage(r io.Reader) (uint64, error) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || fields[0] != "cpu" {
+			continue
+		}
+		if len(fields) < 8 {
+			return 0, fmt.Errorf("Invalid cpu line in /proc/stat: %s", s.Text())
+		}
+		var ticks uint64
+		for _, field := range fields[1:8] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			ticks += value
+		}
+		return ticks * uint64(time.Second) / clockTicksPerSecond, nil
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("No cpu line found in /proc/stat")
+}
+
+// getTotalMemory returns the memory of the host, in bytes
+func getTotalMemory() (uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// MemTotal:        8069456 kB
+		fields := strings.Fields(s.Text())
+		if len(fields) == 3 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("No MemTotal found in /proc/meminfo")
+}
@@ -0,0 +1,36 @@
+package docker
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+const netDevContents = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo:     420       6    0    0    0     0          0         0      420       6    0    0    0     0       0          0
+  eth0:    1296      16    1    2    0     0          0         0      648       8    3    4    0     0       0          0`
+
+func TestParseNetDev(t *testing.T) {
+	stats := &NetworkStats{}
+	if err := parseNetDev(bytes.NewBufferString(netDevContents), "eth0", stats); err != nil {
+		t.Fatal(err)
+	}
+	expected := NetworkStats{
+		RxBytes: 1296, RxPackets: 16, RxErrors: 1, RxDropped: 2,
+		TxBytes: 648, TxPackets: 8, TxErrors: 3, TxDropped: 4,
+	}
+	if *stats != expected {
+		t.Fatalf("Expected %v, got %v", expected, *stats)
+	}
+}
+
+func TestParseSystemCpuUsage(t *testing.T) {
+	usage, err := parseSystemCpuUsage(bytes.NewBufferString("cpu  100 0 50 800 40 5 5 0 0 0\ncpu0 100 0 50 800 40 5 5 0 0 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := uint64(10 * time.Second); usage != expected {
+		t.Fatalf("Expected %d, got %d", expected, usage)
+	}
+}
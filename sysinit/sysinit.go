@@ -7,9 +7,11 @@ import (
 	"github.com/dotcloud/docker/execdriver"
 	_ "github.com/dotcloud/docker/execdriver/chroot"
 	_ "github.com/dotcloud/docker/execdriver/lxc"
+	_ "github.com/dotcloud/docker/execdriver/native"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"strings"
 )
 
@@ -59,12 +61,13 @@ func SysInit() {
 		privileged = flag.Bool("privileged", false, "privileged mode")
 		mtu        = flag.Int("mtu", 1500, "interface mtu")
 		driver     = flag.String("driver", "", "exec driver")
+		root       = flag.String("root", "/", "root filesystem of the container")
 	)
 	flag.Parse()
 
 	// Get env
 	var env []string
-	content, err := ioutil.ReadFile("/.dockerenv")
+	content, err := ioutil.ReadFile(path.Join(*root, ".dockerenv"))
 	if err != nil {
 		log.Fatalf("Unable to load environment variables: %v", err)
 	}
@@ -85,6 +88,7 @@ func SysInit() {
 		Args:       flag.Args(),
 		Mtu:        *mtu,
 		Driver:     *driver,
+		Root:       *root,
 	}
 
 	if err := executeProgram(args); err != nil {
@@ -9,11 +9,13 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 )
 
 const DEFAULTTAG = "latest"
 
 type TagStore struct {
+	sync.Mutex   // held while the tags are reloaded or changed
 	path         string
 	graph        *Graph
 	Repositories map[string]Repository
@@ -86,6 +88,8 @@ func (store *TagStore) LookupImage(name string) (*Image, error) {
 // Return a reverse-lookup table of all the names which refer to each image
 // Eg. {"43b5f19b10584": {"base:latest", "base:v1"}}
 func (store *TagStore) ByID() map[string][]string {
+	store.Lock()
+	defer store.Unlock()
 	byID := make(map[string][]string)
 	for repoName, repository := range store.Repositories {
 		for tag, id := range repository {
@@ -129,6 +133,8 @@ func (store *TagStore) DeleteAll(id string) error {
 }
 
 func (store *TagStore) Delete(repoName, tag string) (bool, error) {
+	store.Lock()
+	defer store.Unlock()
 	deleted := false
 	if err := store.Reload(); err != nil {
 		return false, err
@@ -168,6 +174,8 @@ func (store *TagStore) Set(repoName, tag, imageName string, force bool) error {
 	if err := validateTagName(tag); err != nil {
 		return err
 	}
+	store.Lock()
+	defer store.Unlock()
 	if err := store.Reload(); err != nil {
 		return err
 	}
@@ -186,6 +194,8 @@ func (store *TagStore) Set(repoName, tag, imageName string, force bool) error {
 }
 
 func (store *TagStore) Get(repoName string) (Repository, error) {
+	store.Lock()
+	defer store.Unlock()
 	if err := store.Reload(); err != nil {
 		return nil, err
 	}
@@ -214,6 +224,21 @@ func (store *TagStore) GetImage(repoName, tagOrID string) (*Image, error) {
 	return nil, nil
 }
 
+// copyRepositories returns a copy of the repositories, to go through them
+// while the tags change
+func (store *TagStore) copyRepositories() map[string]Repository {
+	store.Lock()
+	defer store.Unlock()
+	repositories := make(map[string]Repository, len(store.Repositories))
+	for name, repo := range store.Repositories {
+		repositories[name] = make(Repository, len(repo))
+		for tag, id := range repo {
+			repositories[name][tag] = id
+		}
+	}
+	return repositories
+}
+
 // Validate the name of a repository
 func validateRepoName(name string) error {
 	if name == "" {
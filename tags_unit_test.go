@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"fmt"
 	"github.com/dotcloud/docker/graphdriver"
 	"github.com/dotcloud/docker/utils"
 	"os"
@@ -14,7 +15,7 @@ const (
 )
 
 func mkTestTagStore(root string, t *testing.T) *TagStore {
-	driver, err := graphdriver.New(root)
+	driver, err := graphdriver.New(root, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -84,3 +85,49 @@ func TestLookupImage(t *testing.T) {
 		t.Errorf("Expected 1 image, none found")
 	}
 }
+
+func TestTagsWhileServingRegistry(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestTagStore(tmp, t)
+	defer store.graph.driver.Cleanup()
+	registryStore := &graphRegistryStore{graph: store.graph, repositories: store}
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 50; i++ {
+			if err := store.Set("localhost:5000/"+testImageName, fmt.Sprintf("v%d", i), testImageID, false); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	for serving := true; serving; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			serving = false
+		default:
+		}
+		if _, err := registryStore.Repositories(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := registryStore.Tags("library/" + testImageName); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tags, err := registryStore.Tags("library/" + testImageName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 51 {
+		t.Fatalf("Expected 51 tags, got %d", len(tags))
+	}
+}
@@ -323,6 +323,55 @@ func migratePortMappings(config *Config, hostConfig *HostConfig) error {
 	return nil
 }
 
+// Restart policies come in the format of
+// no | always | on-failure[:max-retries]
+func parseRestartPolicy(policy string) (RestartPolicy, error) {
+	p := RestartPolicy{}
+	if policy == "" {
+		return p, nil
+	}
+	parts := strings.SplitN(policy, ":", 2)
+	p.Name = parts[0]
+	switch p.Name {
+	case "no", "always":
+		if len(parts) == 2 {
+			return p, fmt.Errorf("Maximum retry count cannot be used with restart policy '%s'", p.Name)
+		}
+	case "on-failure":
+		if len(parts) == 2 {
+			count, err := strconv.Atoi(parts[1])
+			if err != nil || count < 0 {
+				return p, fmt.Errorf("Invalid maximum retry count: %s", parts[1])
+			}
+			p.MaximumRetryCount = count
+		}
+	default:
+		return p, fmt.Errorf("Invalid restart policy: %s", p.Name)
+	}
+	return p, nil
+}
+
+// Options of the log driver come in the format of
+// key=value
+func parseLogConfig(driver string, opts ListOpts) (LogConfig, error) {
+	c := LogConfig{Type: driver}
+	if opts.Len() == 0 {
+		return c, nil
+	}
+	if driver == "none" {
+		return c, fmt.Errorf("The none log driver has no options")
+	}
+	c.Config = make(map[string]string, opts.Len())
+	for _, o := range opts.GetAll() {
+		parts := strings.SplitN(o, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return c, fmt.Errorf("Invalid log option: %s", o)
+		}
+		c.Config[parts[0]] = parts[1]
+	}
+	return c, nil
+}
+
 // Links come in the format of
 // name:alias
 func parseLink(rawLink string) (map[string]string, error) {
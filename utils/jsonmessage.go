@@ -47,6 +47,11 @@ func (p *JSONProgress) String() string {
 	}
 	total := HumanSize(int64(p.Total))
 	percentage := int(float64(p.Current)/float64(p.Total)*100) / 2
+	// The size given by the registry is the size of the files, the layer
+	// downloaded can be bigger
+	if percentage > 50 {
+		percentage = 50
+	}
 	if width > 110 {
 		pbBox = fmt.Sprintf("[%s>%s] ", strings.Repeat("=", percentage), strings.Repeat(" ", 50-percentage))
 	}
@@ -117,7 +122,7 @@ func DisplayJSONMessagesStream(in io.Reader, out io.Writer, terminalFd uintptr,
 	var (
 		dec  = json.NewDecoder(in)
 		ids  = make(map[string]int)
-		diff = 0
+		diff int
 	)
 	for {
 		var jm JSONMessage
@@ -131,27 +136,35 @@ func DisplayJSONMessagesStream(in io.Reader, out io.Writer, terminalFd uintptr,
 		if jm.Progress != nil {
 			jm.Progress.terminalFd = terminalFd
 		}
-		if jm.Progress != nil || jm.ProgressMessage != "" {
-			line, ok := ids[jm.ID]
-			if !ok {
-				line = len(ids)
-				ids[jm.ID] = line
-				fmt.Fprintf(out, "\n")
-				diff = 0
-			} else {
+		diff = 0
+		newLine := false
+		if jm.ID != "" && (jm.Progress != nil || jm.ProgressMessage != "") {
+			// Each id has its line above the cursor, where its progress
+			// is updated while the other ids make progress too
+			if line, ok := ids[jm.ID]; ok {
 				diff = len(ids) - line
+			} else {
+				ids[jm.ID] = len(ids)
+				newLine = true
 			}
-			if isTerminal {
-				// <ESC>[{diff}A = move cursor up diff rows
-				fmt.Fprintf(out, "%c[%dA", 27, diff)
-			}
+		} else {
+			// The other messages are printed below the lines of the ids,
+			// which can't be found anymore
+			ids = make(map[string]int)
+		}
+		if isTerminal && diff > 0 {
+			// <ESC>[{diff}A = move cursor up diff rows
+			fmt.Fprintf(out, "%c[%dA", 27, diff)
 		}
 		err := jm.Display(out, isTerminal)
-		if jm.ID != "" {
-			if isTerminal {
+		if isTerminal {
+			if diff > 0 {
 				// <ESC>[{diff}B = move cursor down diff rows
 				fmt.Fprintf(out, "%c[%dB", 27, diff)
 			}
+			if newLine {
+				fmt.Fprintf(out, "\n")
+			}
 		}
 		if err != nil {
 			return err
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"bytes"
+	"encoding/json"
 	"testing"
 )
 
@@ -27,3 +29,32 @@ func TestProgress(t *testing.T) {
 		t.Fatalf("Expected '[=========================>                         ]     50 B/100 B', got '%s'", jp3.String())
 	}
 }
+
+func TestDisplayJSONMessagesStream(t *testing.T) {
+	in := bytes.NewBuffer(nil)
+	enc := json.NewEncoder(in)
+	for _, jm := range []JSONMessage{
+		{ID: "a", Status: "Downloading", Progress: &JSONProgress{}},
+		{ID: "b", Status: "Downloading", Progress: &JSONProgress{}},
+		{ID: "a", Status: "Download complete", Progress: &JSONProgress{}},
+		{Status: "Done"},
+		{ID: "b", Status: "Download complete", Progress: &JSONProgress{}},
+	} {
+		if err := enc.Encode(jm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := bytes.NewBuffer(nil)
+	if err := DisplayJSONMessagesStream(in, out, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	// Each id keeps its line, the cursor stays below them
+	expected := "\x1b[2K\ra: Downloading \r\n" +
+		"\x1b[2K\rb: Downloading \r\n" +
+		"\x1b[2A\x1b[2K\ra: Download complete \r\x1b[2B" +
+		"\x1b[2K\rDone\r\n" +
+		"\x1b[2K\rb: Download complete \r\n"
+	if out.String() != expected {
+		t.Fatalf("Expected %q, got %q", expected, out.String())
+	}
+}
@@ -76,6 +76,10 @@ func (sf *StreamFormatter) FormatProgress(id, action string, progress *JSONProgr
 	if progress.String() == "" {
 		endl += "\n"
 	}
+	if id != "" {
+		// Tell apart the progress of the layers transferred at the same time
+		action = id + ": " + action
+	}
 	return []byte(action + " " + progress.String() + endl)
 }
 
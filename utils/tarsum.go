@@ -92,6 +92,11 @@ func (ts *TarSum) Read(buf []byte) (int, error) {
 	buf2 := make([]byte, len(buf), cap(buf))
 
 	n, err := ts.tarR.Read(buf2)
+	if err == io.EOF && n > 0 {
+		// The end of the file may come with its last bytes, handle them
+		// first: the next read returns io.EOF again
+		err = nil
+	}
 	if err != nil {
 		if err == io.EOF {
 			if _, err := ts.h.Write(buf2[:n]); err != nil {
@@ -364,7 +364,14 @@ type JSONLog struct {
 func (w *WriteBroadcaster) Write(p []byte) (n int, err error) {
 	w.Lock()
 	defer w.Unlock()
-	w.buf.Write(p)
+	// Only writers with a stream consume the buffer, don't let it grow
+	// without them
+	for sw := range w.writers {
+		if sw.stream != "" {
+			w.buf.Write(p)
+			break
+		}
+	}
 	for sw := range w.writers {
 		lp := p
 		if sw.stream != "" {
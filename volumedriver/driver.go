@@ -0,0 +1,51 @@
+package volumedriver
+
+import (
+	"fmt"
+)
+
+type InitFunc func(root string) (Driver, error)
+
+// Driver manages volumes, which are kept outside of the filesystems of the
+// containers and bind mounted in them
+type Driver interface {
+	String() string
+
+	// Create creates the volume name, with options specific to the driver
+	Create(name string, options map[string]string) error
+	// Remove removes the volume name and its content
+	Remove(name string) error
+
+	// Mount makes the volume name available on the host for a container,
+	// and returns its path. Each Mount is released by an Unmount.
+	Mount(name string) (dir string, err error)
+	Unmount(name string) error
+	// Path returns the path of the volume name on the host
+	Path(name string) (dir string, err error)
+}
+
+// All registered drivers
+var drivers map[string]InitFunc
+
+func init() {
+	drivers = make(map[string]InitFunc)
+}
+
+func Register(name string, initFunc InitFunc) error {
+	if _, exists := drivers[name]; exists {
+		return fmt.Errorf("Name already registered %s", name)
+	}
+	drivers[name] = initFunc
+
+	return nil
+}
+
+// GetDriver returns the built-in driver name, or else the plugin listening
+// on the socket name.sock of PluginsDir. root is the root of docker, in
+// which the drivers keep their data in a directory of their own.
+func GetDriver(name, root string) (Driver, error) {
+	if initFunc, exists := drivers[name]; exists {
+		return initFunc(root)
+	}
+	return getPlugin(name)
+}
@@ -0,0 +1,69 @@
+package local
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/graphdriver"
+	_ "github.com/dotcloud/docker/graphdriver/vfs"
+	"github.com/dotcloud/docker/volumedriver"
+)
+
+func init() {
+	volumedriver.Register("local", Init)
+}
+
+// Init returns a local driver keeping the volumes in the directories of
+// the vfs graph driver of root, where docker always kept them
+func Init(root string) (volumedriver.Driver, error) {
+	driver, err := graphdriver.GetDriver("vfs", root, nil)
+	if err != nil {
+		return nil, err
+	}
+	return New(driver), nil
+}
+
+// New returns a local driver keeping the volumes in the layers of a plain
+// graph driver, such as vfs
+func New(driver graphdriver.Driver) *Driver {
+	return &Driver{driver}
+}
+
+type Driver struct {
+	driver graphdriver.Driver
+}
+
+func (d *Driver) String() string {
+	return "local"
+}
+
+func (d *Driver) Create(name string, options map[string]string) error {
+	if len(options) != 0 {
+		return fmt.Errorf("The local volume driver takes no options")
+	}
+	return d.driver.Create(name, "")
+}
+
+func (d *Driver) Remove(name string) error {
+	return d.driver.Remove(name)
+}
+
+func (d *Driver) Mount(name string) (string, error) {
+	dir, err := d.driver.Get(name)
+	if err != nil {
+		return "", fmt.Errorf("Driver %s failed to get volume rootfs %s: %s", d.driver, name, err)
+	}
+	return dir, nil
+}
+
+func (d *Driver) Unmount(name string) error {
+	d.driver.Put(name)
+	return nil
+}
+
+func (d *Driver) Path(name string) (string, error) {
+	dir, err := d.Mount(name)
+	if err != nil {
+		return "", err
+	}
+	d.driver.Put(name)
+	return dir, nil
+}
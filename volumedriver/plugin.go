@@ -0,0 +1,130 @@
+package volumedriver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"time"
+)
+
+// PluginsDir is where the out-of-process drivers listen, each on a unix
+// socket named after the driver
+var PluginsDir = "/run/docker/plugins"
+
+const pluginTimeout = 30 * time.Second
+
+// The names of the plugins are the names of their sockets, so they must not
+// be paths
+var validPluginNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// pluginDriver forwards the operations on volumes to an out-of-process
+// driver. Each operation is a POST of a JSON object to /VolumeDriver.<Op>,
+// such as {"Name": "data", "Opts": {"size": "10G"}} to /VolumeDriver.Create.
+// The response is a JSON object whose Err is set when the operation failed,
+// and whose Mountpoint is the path of the volume for Mount and Path.
+type pluginDriver struct {
+	name   string
+	client *http.Client
+}
+
+type pluginRequest struct {
+	Name string
+	Opts map[string]string `json:",omitempty"`
+}
+
+type pluginResponse struct {
+	Mountpoint string
+	Err        string
+}
+
+func getPlugin(name string) (Driver, error) {
+	if !validPluginNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("Invalid volume driver name (%s), only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	socket := path.Join(PluginsDir, name+".sock")
+	if _, err := os.Stat(socket); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("No such volume driver: %s", name)
+		}
+		return nil, err
+	}
+	return &pluginDriver{
+		name: name,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Dial: func(proto, addr string) (net.Conn, error) {
+					return net.DialTimeout("unix", socket, pluginTimeout)
+				},
+				ResponseHeaderTimeout: pluginTimeout,
+			},
+		},
+	}, nil
+}
+
+func (d *pluginDriver) call(op string, req *pluginRequest) (*pluginResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	// The host is ignored, the connection is made to the socket
+	res, err := d.client.Post("http://plugin/VolumeDriver."+op, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("Error calling the volume driver %s: %s", d.name, err)
+	}
+	defer res.Body.Close()
+
+	var ret pluginResponse
+	if err := json.NewDecoder(res.Body).Decode(&ret); err != nil {
+		return nil, fmt.Errorf("Invalid response of the volume driver %s to %s: %s", d.name, op, err)
+	}
+	if ret.Err != "" {
+		return nil, fmt.Errorf("Volume driver %s failed to %s %s: %s", d.name, op, req.Name, ret.Err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Volume driver %s failed to %s %s: %s", d.name, op, req.Name, res.Status)
+	}
+	return &ret, nil
+}
+
+func (d *pluginDriver) String() string {
+	return d.name
+}
+
+func (d *pluginDriver) Create(name string, options map[string]string) error {
+	_, err := d.call("Create", &pluginRequest{Name: name, Opts: options})
+	return err
+}
+
+func (d *pluginDriver) Remove(name string) error {
+	_, err := d.call("Remove", &pluginRequest{Name: name})
+	return err
+}
+
+func (d *pluginDriver) mountpoint(op, name string) (string, error) {
+	ret, err := d.call(op, &pluginRequest{Name: name})
+	if err != nil {
+		return "", err
+	}
+	if !path.IsAbs(ret.Mountpoint) {
+		return "", fmt.Errorf("Volume driver %s returned an invalid path for %s: %q", d.name, name, ret.Mountpoint)
+	}
+	return ret.Mountpoint, nil
+}
+
+func (d *pluginDriver) Mount(name string) (string, error) {
+	return d.mountpoint("Mount", name)
+}
+
+func (d *pluginDriver) Unmount(name string) error {
+	_, err := d.call("Unmount", &pluginRequest{Name: name})
+	return err
+}
+
+func (d *pluginDriver) Path(name string) (string, error) {
+	return d.mountpoint("Path", name)
+}
@@ -0,0 +1,95 @@
+package volumedriver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"testing"
+)
+
+func startTestPlugin(t *testing.T, name string, handler http.HandlerFunc) func() {
+	dir, err := ioutil.TempDir("", "docker-plugins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", path.Join(dir, name+".sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	go http.Serve(l, handler)
+
+	old := PluginsDir
+	PluginsDir = dir
+	return func() {
+		PluginsDir = old
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPluginDriver(t *testing.T) {
+	var requests []string
+	var opts map[string]string
+	stop := startTestPlugin(t, "test", func(w http.ResponseWriter, r *http.Request) {
+		var req pluginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		requests = append(requests, r.URL.Path+" "+req.Name)
+		res := pluginResponse{}
+		switch r.URL.Path {
+		case "/VolumeDriver.Create":
+			opts = req.Opts
+		case "/VolumeDriver.Mount", "/VolumeDriver.Path":
+			res.Mountpoint = "/mnt/" + req.Name
+		case "/VolumeDriver.Remove":
+			res.Err = "busy"
+		}
+		json.NewEncoder(w).Encode(res)
+	})
+	defer stop()
+
+	driver, err := GetDriver("test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Create("data", map[string]string{"size": "10G"}); err != nil {
+		t.Fatal(err)
+	}
+	if opts["size"] != "10G" {
+		t.Fatalf("Expected the options to be sent to the plugin, got %v", opts)
+	}
+	p, err := driver.Mount("data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p != "/mnt/data" {
+		t.Fatalf("Expected /mnt/data, got %s", p)
+	}
+	if err := driver.Unmount("data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Remove("data"); err == nil {
+		t.Fatal("Expected the error of the plugin")
+	}
+	if len(requests) != 4 || requests[0] != "/VolumeDriver.Create data" {
+		t.Fatalf("Unexpected requests %v", requests)
+	}
+}
+
+func TestPluginDriverNotFound(t *testing.T) {
+	stop := startTestPlugin(t, "test", http.NotFound)
+	defer stop()
+
+	if _, err := GetDriver("nothing", ""); err == nil {
+		t.Fatal("Expected an error getting a driver which does not exist")
+	}
+	// The socket of the test plugin, out of the plugins directory
+	if _, err := GetDriver(path.Join("..", path.Base(PluginsDir), "test"), ""); err == nil {
+		t.Fatal("Expected an error getting a driver named by a path")
+	}
+}
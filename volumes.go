@@ -0,0 +1,397 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dotcloud/docker/volumedriver"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var validVolumeNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]+$`)
+
+const defaultVolumeDriver = "local"
+
+// A Volume is a directory managed by a volume driver, which containers
+// mount. The anonymous volumes, created for the volumes of a container
+// which have no source, are named after their id.
+type Volume struct {
+	Name      string
+	ID        string // name of the volume for its driver
+	Driver    string
+	Options   map[string]string `json:",omitempty"`
+	Anonymous bool
+	Created   time.Time
+	// Path of the volume when it was last mounted, to find the volume of
+	// a path of a container
+	Mountpoint string `json:",omitempty"`
+}
+
+// VolumeStore keeps the names of the volumes and their drivers, and counts
+// the containers which use each of them. The references are not saved:
+// they are rebuilt from the containers when they are registered.
+type VolumeStore struct {
+	sync.Mutex
+	path    string
+	root    string
+	legacy  *Graph // volumes created before the volume drivers
+	drivers map[string]volumedriver.Driver
+	Volumes map[string]*Volume
+	refs    map[string]map[string]struct{} // ids of the containers, by volume name
+	// The volumes which their driver is creating, by name. The store is
+	// not locked meanwhile, as plugins might take their time.
+	creating map[string]*Volume
+	created  *sync.Cond
+}
+
+// NewVolumeStore returns the volume store saved at path, where the local
+// volumes are kept by local. The other drivers are looked up in root.
+func NewVolumeStore(path, root string, legacy *Graph, local volumedriver.Driver) (*VolumeStore, error) {
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	store := &VolumeStore{
+		path:     abspath,
+		root:     root,
+		legacy:   legacy,
+		drivers:  map[string]volumedriver.Driver{defaultVolumeDriver: local},
+		Volumes:  make(map[string]*Volume),
+		refs:     make(map[string]map[string]struct{}),
+		creating: make(map[string]*Volume),
+	}
+	store.created = sync.NewCond(&store.Mutex)
+	if err := store.Reload(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, volume := range store.Volumes {
+		// Saved before the volume drivers
+		if volume.Driver == "" {
+			volume.Driver = defaultVolumeDriver
+			volume.Anonymous = volume.Name == volume.ID
+		}
+		if volume.Driver == defaultVolumeDriver {
+			known[volume.ID] = true
+		}
+	}
+	// Record the volumes created before the store
+	images, err := legacy.Map()
+	if err != nil {
+		return nil, err
+	}
+	for id, img := range images {
+		if !known[id] {
+			store.Volumes[id] = &Volume{
+				Name:      id,
+				ID:        id,
+				Driver:    defaultVolumeDriver,
+				Anonymous: true,
+				Created:   img.Created,
+			}
+		}
+	}
+	if err := store.Save(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *VolumeStore) Save() error {
+	jsonData, err := json.Marshal(store)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(store.path, jsonData, 0600)
+}
+
+func (store *VolumeStore) Reload() error {
+	jsonData, err := ioutil.ReadFile(store.path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(jsonData, store)
+}
+
+// driver returns the volume driver name, which must be called with the
+// store locked
+func (store *VolumeStore) driver(name string) (volumedriver.Driver, error) {
+	if driver, exists := store.drivers[name]; exists {
+		return driver, nil
+	}
+	driver, err := volumedriver.GetDriver(name, store.root)
+	if err != nil {
+		return nil, err
+	}
+	store.drivers[name] = driver
+	return driver, nil
+}
+
+func (store *VolumeStore) driverOf(volume *Volume) (volumedriver.Driver, error) {
+	store.Lock()
+	defer store.Unlock()
+	return store.driver(volume.Driver)
+}
+
+// Create creates a volume with the driver driverName, or the local driver
+// when it is empty. The volume is anonymous if name is empty.
+func (store *VolumeStore) Create(name, driverName string, options map[string]string) (*Volume, error) {
+	store.Lock()
+	volume, driver, err := store.reserve(name, driverName, options)
+	store.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return store.create(volume, driver)
+}
+
+// reserve returns a new volume and its driver, and reserves its name until
+// it is created. It must be called with the store locked.
+func (store *VolumeStore) reserve(name, driverName string, options map[string]string) (*Volume, volumedriver.Driver, error) {
+	if name != "" && !validVolumeNamePattern.MatchString(name) {
+		return nil, nil, fmt.Errorf("Invalid volume name (%s), only [a-zA-Z0-9][a-zA-Z0-9_.-] are allowed", name)
+	}
+	if driverName == "" {
+		driverName = defaultVolumeDriver
+	}
+
+	if _, exists := store.Volumes[name]; exists {
+		return nil, nil, fmt.Errorf("Conflict, the volume name %s is already in use", name)
+	}
+	if _, exists := store.creating[name]; exists {
+		return nil, nil, fmt.Errorf("Conflict, the volume name %s is already in use", name)
+	}
+	driver, err := store.driver(driverName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	volume := &Volume{
+		Name:      name,
+		ID:        GenerateID(),
+		Driver:    driverName,
+		Options:   options,
+		Anonymous: name == "",
+		Created:   time.Now().UTC(),
+	}
+	if volume.Anonymous {
+		volume.Name = volume.ID
+	} else if driverName != defaultVolumeDriver {
+		// The volumes of the plugins are known by their name, such as
+		// the name of an NFS export
+		volume.ID = name
+	}
+	store.creating[volume.Name] = volume
+	return volume, driver, nil
+}
+
+// create creates a volume reserved by reserve with its driver, without
+// the store locked, and records it
+func (store *VolumeStore) create(volume *Volume, driver volumedriver.Driver) (*Volume, error) {
+	err := driver.Create(volume.ID, volume.Options)
+
+	store.Lock()
+	defer store.Unlock()
+	delete(store.creating, volume.Name)
+	store.created.Broadcast()
+	if err != nil {
+		return nil, err
+	}
+	store.Volumes[volume.Name] = volume
+	if err := store.Save(); err != nil {
+		return nil, err
+	}
+	return volume, nil
+}
+
+// Get returns the volume with the given name, or nil
+func (store *VolumeStore) Get(name string) *Volume {
+	store.Lock()
+	defer store.Unlock()
+	return store.Volumes[name]
+}
+
+// GetOrCreate returns the volume with the given name, which is created
+// with the driver driverName if it does not exist yet
+func (store *VolumeStore) GetOrCreate(name, driverName string) (*Volume, error) {
+	store.Lock()
+	// Share the volume with whoever is creating it
+	for store.creating[name] != nil {
+		store.created.Wait()
+	}
+	if volume := store.Volumes[name]; volume != nil {
+		store.Unlock()
+		if driverName != "" && driverName != volume.Driver {
+			return nil, fmt.Errorf("Conflict, the volume %s exists with the driver %s", name, volume.Driver)
+		}
+		return volume, nil
+	}
+	volume, driver, err := store.reserve(name, driverName, nil)
+	store.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return store.create(volume, driver)
+}
+
+// Exists returns whether id is the directory of a local volume, or of one
+// being created
+func (store *VolumeStore) Exists(id string) bool {
+	store.Lock()
+	defer store.Unlock()
+	for _, volumes := range []map[string]*Volume{store.Volumes, store.creating} {
+		for _, volume := range volumes {
+			if volume.Driver == defaultVolumeDriver && volume.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ByPath returns the volume whose directory is p, or nil when p is not
+// the directory of a volume, such as the source of a bind mount
+func (store *VolumeStore) ByPath(p string) *Volume {
+	// the id of a local volume is always the base of its path
+	id := filepath.Base(strings.TrimSuffix(p, "/layer"))
+
+	store.Lock()
+	defer store.Unlock()
+	for _, volume := range store.Volumes {
+		if volume.Mountpoint == p || (volume.Driver == defaultVolumeDriver && volume.ID == id) {
+			return volume
+		}
+	}
+	return nil
+}
+
+// List returns the volumes sorted by name
+func (store *VolumeStore) List() []*Volume {
+	store.Lock()
+	defer store.Unlock()
+
+	names := make([]string, 0, len(store.Volumes))
+	for name := range store.Volumes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	volumes := make([]*Volume, len(names))
+	for i, name := range names {
+		volumes[i] = store.Volumes[name]
+	}
+	return volumes
+}
+
+// Mount makes a volume available on the host for a container, and returns
+// its path. Each Mount is released by an Unmount.
+func (store *VolumeStore) Mount(volume *Volume) (string, error) {
+	driver, err := store.driverOf(volume)
+	if err != nil {
+		return "", err
+	}
+	p, err := driver.Mount(volume.ID)
+	if err != nil {
+		return "", err
+	}
+	if p, err = filepath.EvalSymlinks(p); err != nil {
+		driver.Unmount(volume.ID)
+		return "", err
+	}
+
+	store.Lock()
+	defer store.Unlock()
+	if volume.Mountpoint != p {
+		volume.Mountpoint = p
+		if err := store.Save(); err != nil {
+			driver.Unmount(volume.ID)
+			return "", err
+		}
+	}
+	return p, nil
+}
+
+func (store *VolumeStore) Unmount(volume *Volume) error {
+	driver, err := store.driverOf(volume)
+	if err != nil {
+		return err
+	}
+	return driver.Unmount(volume.ID)
+}
+
+// Path returns the directory of a volume on the host
+func (store *VolumeStore) Path(volume *Volume) (string, error) {
+	driver, err := store.driverOf(volume)
+	if err != nil {
+		return "", err
+	}
+	return driver.Path(volume.ID)
+}
+
+// Ref records that the container id uses the volume
+func (store *VolumeStore) Ref(volume *Volume, id string) {
+	store.Lock()
+	defer store.Unlock()
+	if store.refs[volume.Name] == nil {
+		store.refs[volume.Name] = make(map[string]struct{})
+	}
+	store.refs[volume.Name][id] = struct{}{}
+}
+
+// Unref records that the container id does not use the volume anymore,
+// and returns the number of containers which still do
+func (store *VolumeStore) Unref(volume *Volume, id string) int {
+	store.Lock()
+	defer store.Unlock()
+	delete(store.refs[volume.Name], id)
+	if len(store.refs[volume.Name]) == 0 {
+		delete(store.refs, volume.Name)
+	}
+	return len(store.refs[volume.Name])
+}
+
+// Refs returns the ids of the containers which use the volume
+func (store *VolumeStore) Refs(volume *Volume) []string {
+	store.Lock()
+	defer store.Unlock()
+	ids := make([]string, 0, len(store.refs[volume.Name]))
+	for id := range store.refs[volume.Name] {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Delete removes a volume and its content. It fails if a container uses it.
+func (store *VolumeStore) Delete(name string) error {
+	store.Lock()
+	defer store.Unlock()
+
+	volume, exists := store.Volumes[name]
+	if !exists {
+		return fmt.Errorf("No such volume: %s", name)
+	}
+	if refs := len(store.refs[name]); refs != 0 {
+		return fmt.Errorf("Conflict, the volume %s is used by %d container(s)", name, refs)
+	}
+	if volume.Driver == defaultVolumeDriver && store.legacy.Exists(volume.ID) {
+		if err := store.legacy.Delete(volume.ID); err != nil {
+			return err
+		}
+	} else {
+		driver, err := store.driver(volume.Driver)
+		if err != nil {
+			return err
+		}
+		if err := driver.Remove(volume.ID); err != nil {
+			return err
+		}
+	}
+	delete(store.Volumes, name)
+	return store.Save()
+}
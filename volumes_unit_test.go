@@ -0,0 +1,258 @@
+package docker
+
+import (
+	"fmt"
+	"github.com/dotcloud/docker/graphdriver"
+	"github.com/dotcloud/docker/utils"
+	"github.com/dotcloud/docker/volumedriver/local"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+)
+
+func mkTestVolumeStore(root string, t *testing.T) *VolumeStore {
+	driver, err := graphdriver.GetDriver("vfs", root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	graph, err := NewGraph(path.Join(root, "volumes"), driver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := NewVolumeStore(path.Join(root, "volumes.json"), root, graph, local.New(driver))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestVolumeStoreCreate(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestVolumeStore(tmp, t)
+
+	named, err := store.Create("data", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if named.Name != "data" || named.Anonymous {
+		t.Fatalf("Expected a volume named data, got %v", named)
+	}
+	if _, err := store.Create("data", "", nil); err == nil {
+		t.Fatal("Expected an error creating a volume with the name of another")
+	}
+	if _, err := store.Create("/data", "", nil); err == nil {
+		t.Fatal("Expected an error creating a volume with an invalid name")
+	}
+
+	anonymous, err := store.GetOrCreate("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !anonymous.Anonymous {
+		t.Fatalf("Expected an anonymous volume, got %v", anonymous)
+	}
+
+	p, err := store.Path(named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := store.ByPath(p); v != named {
+		t.Fatalf("Expected the volume of %s to be %v, got %v", p, named, v)
+	}
+	if v := store.ByPath("/var/lib/data"); v != nil {
+		t.Fatalf("Expected no volume for /var/lib/data, got %v", v)
+	}
+
+	// The names are kept across restarts
+	store = mkTestVolumeStore(tmp, t)
+	if volumes := store.List(); len(volumes) != 2 {
+		t.Fatalf("Expected 2 volumes, got %d", len(volumes))
+	}
+	if v := store.Get("data"); v == nil || v.ID != named.ID {
+		t.Fatalf("Expected volume data to be %s, got %v", named.ID, v)
+	}
+}
+
+func TestVolumeStoreGetOrCreate(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestVolumeStore(tmp, t)
+
+	// The containers started at once with the same named volume share it
+	var wg sync.WaitGroup
+	volumes := make([]*Volume, 10)
+	errs := make([]error, len(volumes))
+	for i := range volumes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			volumes[i], errs[i] = store.GetOrCreate("data", "")
+		}(i)
+	}
+	wg.Wait()
+	for i, volume := range volumes {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		if volume != volumes[0] {
+			t.Fatalf("Expected the volume %s, got %s", volumes[0].ID, volume.ID)
+		}
+	}
+
+	if _, err := store.GetOrCreate("data", "foo"); err == nil {
+		t.Fatal("Expected an error getting a volume with another driver")
+	}
+}
+
+func TestVolumeStoreRefs(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestVolumeStore(tmp, t)
+
+	volume, err := store.Create("data", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Ref(volume, "a")
+	store.Ref(volume, "b")
+	store.Ref(volume, "a")
+	if refs := store.Refs(volume); len(refs) != 2 {
+		t.Fatalf("Expected 2 containers, got %v", refs)
+	}
+
+	if err := store.Delete("data"); err == nil {
+		t.Fatal("Expected an error removing a volume in use")
+	}
+	if n := store.Unref(volume, "a"); n != 1 {
+		t.Fatalf("Expected 1 container left, got %d", n)
+	}
+	if n := store.Unref(volume, "b"); n != 0 {
+		t.Fatalf("Expected no container left, got %d", n)
+	}
+	if refs := store.Refs(volume); len(refs) != 0 {
+		t.Fatalf("Expected no container, got %v", refs)
+	}
+	if err := store.Delete("nothing"); err == nil {
+		t.Fatal("Expected an error removing a volume which does not exist")
+	}
+}
+
+func TestVolumeStoreLegacy(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestVolumeStore(tmp, t)
+
+	// A volume created before the store
+	img, err := store.legacy.Create(nil, nil, "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path.Join(tmp, "volumes.json"))
+
+	store = mkTestVolumeStore(tmp, t)
+	if v := store.Get(img.ID); v == nil || !v.Anonymous {
+		t.Fatalf("Expected an anonymous volume %s, got %v", img.ID, v)
+	}
+}
+
+// blockingVolumeDriver creates its volumes once it is told to
+type blockingVolumeDriver struct {
+	creating chan string
+	release  chan error
+}
+
+func (d *blockingVolumeDriver) String() string {
+	return "blocking"
+}
+
+func (d *blockingVolumeDriver) Create(name string, options map[string]string) error {
+	d.creating <- name
+	return <-d.release
+}
+
+func (d *blockingVolumeDriver) Remove(name string) error {
+	return nil
+}
+
+func (d *blockingVolumeDriver) Mount(name string) (string, error) {
+	return d.Path(name)
+}
+
+func (d *blockingVolumeDriver) Unmount(name string) error {
+	return nil
+}
+
+func (d *blockingVolumeDriver) Path(name string) (string, error) {
+	return path.Join("/blocking", name), nil
+}
+
+func TestVolumeStoreCreateSlowDriver(t *testing.T) {
+	tmp, err := utils.TestDirectory("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	store := mkTestVolumeStore(tmp, t)
+	driver := &blockingVolumeDriver{
+		creating: make(chan string),
+		release:  make(chan error),
+	}
+	store.drivers["blocking"] = driver
+
+	create := func(name string) chan error {
+		errs := make(chan error, 1)
+		go func() {
+			_, err := store.Create(name, "blocking", nil)
+			errs <- err
+		}()
+		<-driver.creating
+		return errs
+	}
+
+	// The store is usable while a driver creates a volume, whose name
+	// is reserved meanwhile
+	errs := create("slow")
+	setTimeout(t, "The store is locked while the volume is created", 2*time.Second, func() {
+		if v := store.ByPath("/blocking/slow"); v != nil {
+			t.Fatalf("Expected no volume yet, got %v", v)
+		}
+		if _, err := store.Create("slow", "", nil); err == nil {
+			t.Fatal("Expected an error creating a volume with a reserved name")
+		}
+	})
+	driver.release <- nil
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	if v := store.Get("slow"); v == nil {
+		t.Fatal("Expected the volume slow to be recorded")
+	}
+
+	// The name is free again when the driver fails
+	errs = create("failed")
+	driver.release <- fmt.Errorf("plugin failure")
+	if err := <-errs; err == nil {
+		t.Fatal("Expected the error of the driver")
+	}
+	if v := store.Get("failed"); v != nil {
+		t.Fatalf("Expected no volume failed, got %v", v)
+	}
+	if _, err := store.Create("failed", "", nil); err != nil {
+		t.Fatal(err)
+	}
+}